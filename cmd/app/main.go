@@ -35,6 +35,30 @@ func main() {
 				logger.Infof("output topic ready: %s", cfg.KafkaProducerTopic)
 			}
 		}
+
+		if cfg.KafkaDLQTopic != "" {
+			if err := kafkautil.EnsureTopicWithRetry(ctx, cfg.KafkaProducerBroker,
+				cfg.KafkaDLQTopic,
+				cfg.KafkaOutputTopicPartitions,
+				cfg.KafkaOutputTopicReplication,
+				nil, 5, 300*time.Millisecond); err != nil {
+				logger.Warnf("ensure dlq topic %q: %v", cfg.KafkaDLQTopic, err)
+			} else {
+				logger.Infof("dlq topic ready: %s", cfg.KafkaDLQTopic)
+			}
+		}
+
+		if cfg.KafkaProgressTopic != "" {
+			if err := kafkautil.EnsureTopicWithRetry(ctx, cfg.KafkaProducerBroker,
+				cfg.KafkaProgressTopic,
+				cfg.KafkaOutputTopicPartitions,
+				cfg.KafkaOutputTopicReplication,
+				nil, 5, 300*time.Millisecond); err != nil {
+				logger.Warnf("ensure progress topic %q: %v", cfg.KafkaProgressTopic, err)
+			} else {
+				logger.Infof("progress topic ready: %s", cfg.KafkaProgressTopic)
+			}
+		}
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
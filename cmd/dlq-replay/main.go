@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/yangjie500/media_extractor_ffmpeg/internal/consumer"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/config"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+)
+
+// dlq-replay reads the dead-letter topic and re-injects the selected
+// messages, with their original key/value/headers, into the input topic.
+func main() {
+	partition := flag.Int("partition", -1, "only replay this DLQ partition (-1 = all)")
+	fromOffset := flag.Int64("from", 0, "first DLQ offset to consider (inclusive)")
+	toOffset := flag.Int64("to", -1, "last DLQ offset to consider (inclusive, -1 = end)")
+	key := flag.String("key", "", "only replay messages with this key")
+	match := flag.String("match", "", "only replay messages whose error contains this text")
+	limit := flag.Int("limit", 0, "stop after replaying this many messages (0 = no limit)")
+	target := flag.String("target", "", "topic to replay into (default: KAFKA_TOPIC)")
+	dryRun := flag.Bool("dry-run", false, "print the selected messages without replaying them")
+	timeout := flag.Duration("timeout", 2*time.Minute, "overall timeout")
+	flag.Parse()
+
+	cfg, err := config.LoadAll("configs/.env.production")
+	if err != nil {
+		logger.Errorf("config error: %v", err)
+		os.Exit(1)
+	}
+	if cfg.KafkaDLQTopic == "" {
+		logger.Errorf("KAFKA_TOPIC_DLQ is not configured")
+		os.Exit(1)
+	}
+	if *target == "" {
+		*target = cfg.KafkaTopic
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	sel := selector{
+		fromOffset: *fromOffset,
+		toOffset:   *toOffset,
+		key:        *key,
+		match:      *match,
+	}
+
+	w := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  cfg.KafkaBrokers,
+		Topic:    *target,
+		Balancer: &kafka.Hash{},
+	})
+	defer w.Close()
+
+	partitions, err := dlqPartitions(ctx, cfg.KafkaProducerBroker, cfg.KafkaDLQTopic)
+	if err != nil {
+		logger.Errorf("list dlq partitions: %v", err)
+		os.Exit(1)
+	}
+
+	replayed := 0
+	for _, p := range partitions {
+		if *partition >= 0 && p.ID != *partition {
+			continue
+		}
+		n, err := replayPartition(ctx, cfg, p, sel, w, *dryRun, *limit-replayed, *limit > 0)
+		replayed += n
+		if err != nil {
+			logger.Errorf("replay partition %d: %v", p.ID, err)
+			os.Exit(1)
+		}
+		if *limit > 0 && replayed >= *limit {
+			break
+		}
+	}
+
+	logger.Infof("replayed %d message(s) from %s into %s (dry-run=%t)", replayed, cfg.KafkaDLQTopic, *target, *dryRun)
+	fmt.Println("OK", replayed)
+}
+
+type selector struct {
+	fromOffset int64
+	toOffset   int64
+	key        string
+	match      string
+}
+
+func (s selector) matches(msg kafka.Message) bool {
+	if msg.Offset < s.fromOffset {
+		return false
+	}
+	if s.toOffset >= 0 && msg.Offset > s.toOffset {
+		return false
+	}
+	if s.key != "" && string(msg.Key) != s.key {
+		return false
+	}
+	if s.match != "" && !strings.Contains(consumer.HeaderValue(msg, consumer.DLQHeaderError), s.match) {
+		return false
+	}
+	return true
+}
+
+func dlqPartitions(ctx context.Context, brokers []string, topic string) ([]kafka.Partition, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("no brokers configured")
+	}
+	conn, err := kafka.DialContext(ctx, "tcp", brokers[0])
+	if err != nil {
+		return nil, fmt.Errorf("dial broker %s: %w", brokers[0], err)
+	}
+	defer conn.Close()
+
+	return conn.ReadPartitions(topic)
+}
+
+// replayPartition reads one DLQ partition from the first selected offset up
+// to the current end of the log.
+func replayPartition(
+	ctx context.Context,
+	cfg config.Config,
+	p kafka.Partition,
+	sel selector,
+	w *kafka.Writer,
+	dryRun bool,
+	remaining int,
+	limited bool,
+) (int, error) {
+	leader, err := kafka.DialLeader(ctx, "tcp", cfg.KafkaProducerBroker[0], p.Topic, p.ID)
+	if err != nil {
+		return 0, fmt.Errorf("dial leader: %w", err)
+	}
+	first, last, err := leader.ReadOffsets()
+	leader.Close()
+	if err != nil {
+		return 0, fmt.Errorf("read offsets: %w", err)
+	}
+
+	start := first
+	if sel.fromOffset > start {
+		start = sel.fromOffset
+	}
+	end := last // exclusive
+	if sel.toOffset >= 0 && sel.toOffset+1 < end {
+		end = sel.toOffset + 1
+	}
+	if start >= end {
+		return 0, nil
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   cfg.KafkaProducerBroker,
+		Topic:     p.Topic,
+		Partition: p.ID,
+		MaxBytes:  cfg.KafkaMaxBytes,
+	})
+	defer r.Close()
+	if err := r.SetOffset(start); err != nil {
+		return 0, fmt.Errorf("seek to %d: %w", start, err)
+	}
+
+	replayed := 0
+	for {
+		msg, err := r.ReadMessage(ctx)
+		if err != nil {
+			return replayed, fmt.Errorf("read: %w", err)
+		}
+		if sel.matches(msg) {
+			logger.Infof("replay partition=%d offset=%d key=%q source=%s/%s/%s attempts=%s err=%q",
+				msg.Partition, msg.Offset, string(msg.Key),
+				consumer.HeaderValue(msg, consumer.DLQHeaderTopic),
+				consumer.HeaderValue(msg, consumer.DLQHeaderPartition),
+				consumer.HeaderValue(msg, consumer.DLQHeaderOffset),
+				consumer.HeaderValue(msg, consumer.DLQHeaderAttempts),
+				consumer.HeaderValue(msg, consumer.DLQHeaderError))
+
+			if !dryRun {
+				out := kafka.Message{
+					Key:     msg.Key,
+					Value:   msg.Value,
+					Headers: consumer.StripDLQHeaders(msg.Headers),
+					Time:    time.Now().UTC(),
+				}
+				if err := w.WriteMessages(ctx, out); err != nil {
+					return replayed, fmt.Errorf("write offset %d: %w", msg.Offset, err)
+				}
+			}
+			replayed++
+			if limited && replayed >= remaining {
+				return replayed, nil
+			}
+		}
+		if msg.Offset+1 >= end {
+			return replayed, nil
+		}
+	}
+}
@@ -19,9 +19,10 @@ func main() {
 
 	logger.Infof("%+v\n", result)
 
-	err = ffmpegx.MergeAV(ctx, "./connor.mp4", "./connor.m4a", "./connor-merged.mp4", "aac")
+	plan, err := ffmpegx.MergeAV(ctx, ffmpegx.FileInput("./connor.mp4"), ffmpegx.FileInput("./connor.m4a"), ffmpegx.FileOutput("./connor-merged.mp4"), ffmpegx.MergeOptions{})
 	if err != nil {
 		logger.Errorf("%v", err)
 	}
+	logger.Infof("%+v\n", plan)
 
 }
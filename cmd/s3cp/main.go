@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/config"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/s3x"
+)
+
+// s3cp copies a file to or from the configured object store, using the same
+// endpoint/credential settings as the consumer. Handy for seeding inputs
+// into MinIO or LocalStack:
+//
+//	go run ./cmd/s3cp ./connor.mp4 s3://media-extractor/connor/video.mp4
+//	go run ./cmd/s3cp s3://media-extractor/connor/video_merged.mp4 ./out.mp4
+func main() {
+	region := flag.String("region", "", "region (default: AWS_REGION)")
+	timeout := flag.Duration("timeout", 30*time.Minute, "overall timeout")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: s3cp [-region r] <src> <dst>  (one side must be s3://bucket/key)")
+		os.Exit(2)
+	}
+	src, dst := flag.Arg(0), flag.Arg(1)
+
+	// Only the S3 settings are needed here, so Kafka errors are not fatal.
+	cfg, err := config.LoadAll("configs/.env.production")
+	if err != nil {
+		logger.Warnf("config: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	c, err := s3x.NewFromConfig(ctx, s3x.ConfigFrom(cfg, *region))
+	if err != nil {
+		logger.Errorf("s3 init: %v", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case strings.HasPrefix(dst, "s3://") && !strings.HasPrefix(src, "s3://"):
+		bucket, key, err := splitS3URL(dst)
+		if err != nil {
+			logger.Errorf("%v", err)
+			os.Exit(2)
+		}
+		up, err := c.PutObjectFromFile(ctx, bucket, key, src, s3x.UploadOptions{ContentType: mime.TypeByExtension(filepath.Ext(src))})
+		if err != nil {
+			logger.Errorf("upload: %v", err)
+			os.Exit(1)
+		}
+		fmt.Printf("uploaded %s -> %s (%d bytes, etag %s)\n", src, dst, up.Size, up.ETag)
+
+	case strings.HasPrefix(src, "s3://") && !strings.HasPrefix(dst, "s3://"):
+		bucket, key, err := splitS3URL(src)
+		if err != nil {
+			logger.Errorf("%v", err)
+			os.Exit(2)
+		}
+		f, err := os.Create(dst)
+		if err != nil {
+			logger.Errorf("create %s: %v", dst, err)
+			os.Exit(1)
+		}
+		info, err := c.Download(ctx, bucket, key, f)
+		f.Close()
+		if err != nil {
+			logger.Errorf("download: %v", err)
+			os.Exit(1)
+		}
+		fmt.Printf("downloaded %s -> %s (%d bytes)\n", src, dst, info.Size)
+
+	default:
+		fmt.Fprintln(os.Stderr, "exactly one of src/dst must be an s3:// URL")
+		os.Exit(2)
+	}
+}
+
+func splitS3URL(u string) (bucket, key string, err error) {
+	rest := strings.TrimPrefix(u, "s3://")
+	bucket, key, ok := strings.Cut(rest, "/")
+	if !ok || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid s3 URL %q (want s3://bucket/key)", u)
+	}
+	return bucket, key, nil
+}
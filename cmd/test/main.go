@@ -33,9 +33,8 @@ func main() {
 	time.Sleep(150 * time.Millisecond)
 	logger.Infof("consumer exited")
 
-	// region := os.Getenv("AWS_REGION") // e.g., "ap-southeast-1"
 	// ctx := context.Background()
-	// s3client, err := s3c.New(ctx, region)
+	// s3client, err := s3x.NewFromConfig(ctx, s3x.ConfigFrom(cfg, "")) // honours S3_ENDPOINT etc.
 	// if err != nil {
 	// 	logger.Errorf("init s3: %v", err)
 	// 	return
@@ -0,0 +1,147 @@
+package consumer
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/ffmpegx"
+)
+
+// MergeCodecs pick the codecs and bitrates for merge streams that can't be
+// copied into the output. Empty fields use the MERGE_* settings.
+type MergeCodecs struct {
+	VideoCodec   string `json:"video_codec,omitempty"`
+	AudioCodec   string `json:"audio_codec,omitempty"`
+	VideoBitrate string `json:"video_bitrate,omitempty"`
+	AudioBitrate string `json:"audio_bitrate,omitempty"`
+}
+
+// MergeSync sets how merge audio lines up with the video.
+type MergeSync struct {
+	// Mismatch is shortest, pad, loop or fail; default MERGE_MISMATCH_POLICY
+	Mismatch            string `json:"mismatch,omitempty"`
+	MismatchToleranceMs *int64 `json:"mismatch_tolerance_ms,omitempty"` // for fail; default MERGE_MISMATCH_TOLERANCE
+	AudioOffsetMs       int64  `json:"audio_offset_ms,omitempty"`       // positive delays the audio
+}
+
+// maxAudioOffset bounds audio_offset_ms; larger shifts are a bad request,
+// not a sync correction.
+const maxAudioOffset = time.Minute
+
+var bitrateRe = regexp.MustCompile(`^[1-9][0-9]*(\.[0-9]+)?[kKmM]?$`)
+
+func (v *validator) outputFormat(name string) {
+	if _, err := ffmpegx.LookupOutputFormat(name); name != "" && err != nil {
+		v.add("output_format", fmt.Sprintf("must be one of %v", ffmpegx.OutputFormats()))
+	}
+}
+
+// mergeCodecs checks codec settings; with a valid format the codecs must
+// also fit in it.
+func (v *validator) mergeCodecs(format string, c MergeCodecs) {
+	videoCodecs, audioCodecs := ffmpegx.VideoCodecs(), ffmpegx.AudioCodecs()
+	if f, err := ffmpegx.LookupOutputFormat(format); format != "" && err == nil {
+		videoCodecs, audioCodecs = f.VideoTargets(), f.AudioTargets()
+	}
+	if c.VideoCodec != "" && !slices.Contains(videoCodecs, strings.ToLower(c.VideoCodec)) {
+		v.add("video_codec", fmt.Sprintf("must be one of %v", videoCodecs))
+	}
+	if c.AudioCodec != "" && !slices.Contains(audioCodecs, strings.ToLower(c.AudioCodec)) {
+		v.add("audio_codec", fmt.Sprintf("must be one of %v", audioCodecs))
+	}
+	if c.VideoBitrate != "" && !bitrateRe.MatchString(c.VideoBitrate) {
+		v.add("video_bitrate", `must look like "4M" or "2500k"`)
+	}
+	if c.AudioBitrate != "" && !bitrateRe.MatchString(c.AudioBitrate) {
+		v.add("audio_bitrate", `must look like "128k"`)
+	}
+}
+
+func (v *validator) mergeSync(s MergeSync) {
+	if s.Mismatch != "" && !slices.Contains(ffmpegx.MismatchPolicies(), strings.ToLower(s.Mismatch)) {
+		v.add("mismatch", fmt.Sprintf("must be one of %v", ffmpegx.MismatchPolicies()))
+	}
+	if s.MismatchToleranceMs != nil && *s.MismatchToleranceMs < 0 {
+		v.add("mismatch_tolerance_ms", "must not be negative")
+	}
+	if off := time.Duration(s.AudioOffsetMs) * time.Millisecond; off > maxAudioOffset || off < -maxAudioOffset {
+		v.add("audio_offset_ms", fmt.Sprintf("must be within ±%d", maxAudioOffset.Milliseconds()))
+	}
+}
+
+// transcodeOptions checks a transcode's codecs, bitrates and preset the
+// same way merges check theirs, so a typo fails validation rather than
+// every ffmpeg attempt.
+func (v *validator) transcodeOptions(r TranscodeRequest) {
+	mp4, _ := ffmpegx.LookupOutputFormat("mp4")
+	if _, err := ffmpegx.TranscodeVideoEncoder(r.VideoCodec); r.VideoCodec != "" && err != nil {
+		v.add("video_codec", fmt.Sprintf("must be one of %v or its encoder name", mp4.VideoTargets()))
+	}
+	if _, err := ffmpegx.TranscodeAudioEncoder(r.AudioCodec); r.AudioCodec != "" && err != nil {
+		v.add("audio_codec", fmt.Sprintf("must be one of %v or its encoder name", mp4.AudioTargets()))
+	}
+	if r.VideoBitrate != "" && !bitrateRe.MatchString(r.VideoBitrate) {
+		v.add("video_bitrate", `must look like "4M" or "2500k"`)
+	}
+	if r.AudioBitrate != "" && !bitrateRe.MatchString(r.AudioBitrate) {
+		v.add("audio_bitrate", `must look like "128k"`)
+	}
+	if r.Preset != "" && !slices.Contains(ffmpegx.Presets(), r.Preset) {
+		v.add("preset", fmt.Sprintf("must be one of %v", ffmpegx.Presets()))
+	}
+	if r.Width < 0 {
+		v.add("width", "must not be negative")
+	}
+	if r.Height < 0 {
+		v.add("height", "must not be negative")
+	}
+}
+
+// mergeOptions layers the request's codec settings over the service's. A
+// configured codec the format can't hold is dropped in favour of the
+// format's own default, so MERGE_VIDEO_CODEC=h264 still allows WebM.
+func (s *Service) mergeOptions(f ffmpegx.OutputFormat, req MergeCodecs, sync MergeSync) ffmpegx.MergeOptions {
+	video, audio := req.VideoCodec, req.AudioCodec
+	if video == "" && slices.Contains(f.VideoTargets(), s.cfg.MergeVideoCodec) {
+		video = s.cfg.MergeVideoCodec
+	}
+	if audio == "" && slices.Contains(f.AudioTargets(), s.cfg.MergeAudioCodec) {
+		audio = s.cfg.MergeAudioCodec
+	}
+	return ffmpegx.MergeOptions{
+		Format:       f.Name,
+		VideoCodec:   video,
+		AudioCodec:   audio,
+		VideoBitrate: firstNonEmpty(req.VideoBitrate, s.cfg.MergeVideoBitrate),
+		AudioBitrate: firstNonEmpty(req.AudioBitrate, s.cfg.MergeAudioBitrate),
+		Preset:       s.cfg.MergeVideoPreset,
+		Mismatch:     strings.ToLower(firstNonEmpty(sync.Mismatch, s.cfg.MergeMismatchPolicy)),
+		Tolerance:    s.mismatchTolerance(sync.MismatchToleranceMs),
+		AudioOffset:  time.Duration(sync.AudioOffsetMs) * time.Millisecond,
+	}
+}
+
+func (s *Service) mismatchTolerance(ms *int64) time.Duration {
+	if ms != nil {
+		return time.Duration(*ms) * time.Millisecond
+	}
+	return s.cfg.MergeMismatchTolerance
+}
+
+// needsSeek reports whether the merge inputs must not be piped: looping
+// rewinds the audio, and fail has to probe both lengths first.
+func needsSeek(policy string) bool {
+	return policy == ffmpegx.MismatchLoop || policy == ffmpegx.MismatchFail
+}
+
+// fastStart is on unless the request turns it off, for formats played in
+// browsers; others only get it when asked for.
+func fastStart(f ffmpegx.OutputFormat, requested *bool) bool {
+	if requested != nil {
+		return *requested
+	}
+	return f.Web
+}
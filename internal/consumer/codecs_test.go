@@ -0,0 +1,175 @@
+package consumer
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/ffmpegx"
+)
+
+func TestTranscodeOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        TranscodeRequest
+		wantFields []string
+	}{
+		{"defaults", TranscodeRequest{}, nil},
+		{"codecs and encoders", TranscodeRequest{VideoCodec: "libx264", AudioCodec: "AAC"}, nil},
+		{"full", TranscodeRequest{VideoCodec: "hevc", VideoBitrate: "4M", AudioBitrate: "128k", Preset: "fast", Width: 1280}, nil},
+		{"unknown codecs", TranscodeRequest{VideoCodec: "vp8", AudioCodec: "vorbis"}, []string{"video_codec", "audio_codec"}},
+		{"bad bitrates", TranscodeRequest{VideoBitrate: "fast", AudioBitrate: "-1k"}, []string{"video_bitrate", "audio_bitrate"}},
+		{"bad preset", TranscodeRequest{Preset: "ludicrous"}, []string{"preset"}},
+		{"negative size", TranscodeRequest{Width: -2, Height: -1}, []string{"width", "height"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator
+			v.transcodeOptions(tt.req)
+			var got []string
+			for _, f := range v.fields {
+				got = append(got, f.Field)
+			}
+			if !slices.Equal(got, tt.wantFields) {
+				t.Errorf("invalid fields = %v, want %v", got, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestMergeCodecsValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		codecs MergeCodecs
+		want   []string
+	}{
+		{"defaults", "", MergeCodecs{}, nil},
+		{"any known codec without a format", "", MergeCodecs{VideoCodec: "VP8", AudioCodec: "vorbis"}, nil},
+		{"fits mp4", "mp4", MergeCodecs{VideoCodec: "hevc", AudioCodec: "mp3", VideoBitrate: "2.5M", AudioBitrate: "128k"}, nil},
+		{"does not fit mp4", "mp4", MergeCodecs{VideoCodec: "vp8", AudioCodec: "vorbis"}, []string{"video_codec", "audio_codec"}},
+		{"does not fit webm", "webm", MergeCodecs{VideoCodec: "h264"}, []string{"video_codec"}},
+		{"unknown codec", "", MergeCodecs{VideoCodec: "prores"}, []string{"video_codec"}},
+		{"bad bitrates", "", MergeCodecs{VideoBitrate: "0", AudioBitrate: "128 kbps"}, []string{"video_bitrate", "audio_bitrate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator
+			v.mergeCodecs(tt.format, tt.codecs)
+			if got := invalidFields(t, v.err()); !slices.Equal(got, tt.want) {
+				t.Errorf("invalid fields %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeOptionsCodecs(t *testing.T) {
+	s := &Service{}
+	s.cfg.MergeVideoCodec, s.cfg.MergeAudioCodec = "h264", "aac"
+	s.cfg.MergeVideoBitrate = "4M"
+	tests := []struct {
+		name                 string
+		format               string
+		req                  MergeCodecs
+		wantVideo, wantAudio string
+		wantVideoBitrate     string
+	}{
+		{"service codecs", "mp4", MergeCodecs{}, "h264", "aac", "4M"},
+		{"request codecs", "mp4", MergeCodecs{VideoCodec: "hevc", AudioCodec: "mp3", VideoBitrate: "8M"}, "hevc", "mp3", "8M"},
+		{"service codecs the format cannot hold", "webm", MergeCodecs{}, "", "", "4M"},
+	}
+	for _, tt := range tests {
+		f, err := ffmpegx.LookupOutputFormat(tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := s.mergeOptions(f, tt.req, MergeSync{})
+		if opts.VideoCodec != tt.wantVideo || opts.AudioCodec != tt.wantAudio || opts.VideoBitrate != tt.wantVideoBitrate {
+			t.Errorf("%s: codecs %q/%q bitrate %q, want %q/%q %q", tt.name, opts.VideoCodec, opts.AudioCodec, opts.VideoBitrate, tt.wantVideo, tt.wantAudio, tt.wantVideoBitrate)
+		}
+	}
+}
+
+func TestMergeOutputFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       MergeRequest
+		wantLoc   string
+		wantValid bool
+		wantFast  bool
+	}{
+		{"default", MergeRequest{VideoBucket: "media-in", VideoKey: "v/clip.mov"}, "s3://media-in/v/clip_merged.mp4", true, true},
+		{"webm", MergeRequest{VideoBucket: "media-in", VideoKey: "v/clip.mov", OutputFormat: "webm"}, "s3://media-in/v/clip_merged.webm", true, true},
+		{"mkv", MergeRequest{VideoBucket: "media-in", VideoKey: "v/clip.mov", OutputFormat: "MKV"}, "s3://media-in/v/clip_merged.mkv", true, false},
+		{"explicit key", MergeRequest{VideoBucket: "media-in", VideoKey: "v/clip.mov", OutputKey: "out/x.bin", OutputFormat: "mov"}, "s3://media-in/out/x.bin", true, false},
+		{"unknown", MergeRequest{VideoBucket: "media-in", VideoKey: "v/clip.mov", OutputFormat: "avi"}, "s3://media-in/v/clip_merged.mp4", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.outputLocation().String(); got != tt.wantLoc {
+				t.Errorf("output %s, want %s", got, tt.wantLoc)
+			}
+			var v validator
+			v.outputFormat(tt.req.OutputFormat)
+			if valid := len(v.fields) == 0; valid != tt.wantValid {
+				t.Errorf("valid = %v, want %v", valid, tt.wantValid)
+			}
+			f, _ := ffmpegx.LookupOutputFormat(tt.req.OutputFormat)
+			if got := fastStart(f, nil); tt.wantValid && got != tt.wantFast {
+				t.Errorf("faststart default = %v, want %v", got, tt.wantFast)
+			}
+		})
+	}
+	off := false
+	if fastStart(ffmpegx.OutputFormat{Web: true}, &off) {
+		t.Error("a request turning faststart off was ignored")
+	}
+}
+
+func TestMergeSyncValidation(t *testing.T) {
+	negative, zero := int64(-1), int64(0)
+	tests := []struct {
+		name string
+		sync MergeSync
+		want []string
+	}{
+		{"defaults", MergeSync{}, nil},
+		{"full", MergeSync{Mismatch: "PAD", MismatchToleranceMs: &zero, AudioOffsetMs: -60000}, nil},
+		{"unknown policy", MergeSync{Mismatch: "stretch"}, []string{"mismatch"}},
+		{"negative tolerance", MergeSync{MismatchToleranceMs: &negative}, []string{"mismatch_tolerance_ms"}},
+		{"offset too large", MergeSync{AudioOffsetMs: 60001}, []string{"audio_offset_ms"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator
+			v.mergeSync(tt.sync)
+			if got := invalidFields(t, v.err()); !slices.Equal(got, tt.want) {
+				t.Errorf("invalid fields %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeOptionsSync(t *testing.T) {
+	s := &Service{}
+	s.cfg.MergeMismatchPolicy = ffmpegx.MismatchShortest
+	s.cfg.MergeMismatchTolerance = time.Second
+	f, _ := ffmpegx.LookupOutputFormat("")
+
+	opts := s.mergeOptions(f, MergeCodecs{}, MergeSync{})
+	if opts.Mismatch != ffmpegx.MismatchShortest || opts.Tolerance != time.Second || opts.AudioOffset != 0 {
+		t.Errorf("defaults: %s/%s/%s", opts.Mismatch, opts.Tolerance, opts.AudioOffset)
+	}
+	zero := int64(0)
+	opts = s.mergeOptions(f, MergeCodecs{}, MergeSync{Mismatch: "Fail", MismatchToleranceMs: &zero, AudioOffsetMs: -250})
+	if opts.Mismatch != ffmpegx.MismatchFail || opts.Tolerance != 0 || opts.AudioOffset != -250*time.Millisecond {
+		t.Errorf("requested: %s/%s/%s", opts.Mismatch, opts.Tolerance, opts.AudioOffset)
+	}
+	for policy, want := range map[string]bool{
+		ffmpegx.MismatchShortest: false, ffmpegx.MismatchPad: false, ffmpegx.MismatchLoop: true, ffmpegx.MismatchFail: true,
+	} {
+		if got := needsSeek(policy); got != want {
+			t.Errorf("needsSeek(%s) = %v, want %v", policy, got, want)
+		}
+	}
+}
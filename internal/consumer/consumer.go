@@ -3,11 +3,13 @@ package consumer
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 	"github.com/yangjie500/media_extractor_ffmpeg/pkg/config"
 	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
 )
 
 func Start(ctx context.Context, cfg config.Config) error {
@@ -18,12 +20,47 @@ func Start(ctx context.Context, cfg config.Config) error {
 		}
 	}()
 
-	svc := NewService(cfg)
+	svc, err := NewService(cfg)
+	if err != nil {
+		return err
+	}
 	defer svc.Close()
 
+	workers := cfg.WorkerConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	logger.Infof("worker pool size=%d", workers)
+
+	tracker := newOffsetTracker()
+	slots := make(chan struct{}, workers)
+	completed := make(chan kafka.Message, workers)
+	commitDone := make(chan struct{})
+	go func() {
+		defer close(commitDone)
+		commitLoop(r, tracker, completed)
+	}()
+	go sweepWorkDirs(ctx, staleWorkDirInterval)
+
+	var wg sync.WaitGroup
+	defer func() {
+		// Let in-flight jobs wind down and flush their commits before the
+		// reader is closed.
+		wg.Wait()
+		close(completed)
+		<-commitDone
+	}()
+
 	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
 		msg, err := r.FetchMessage(ctx)
 		if err != nil {
+			<-slots
 			if errors.Is(err, context.Canceled) {
 				return err
 			}
@@ -32,34 +69,94 @@ func Start(ctx context.Context, cfg config.Config) error {
 			continue
 		}
 
-		logger.Debugf("Fetched partition=%d offet=%d key%q", msg.Partition, msg.Offset, string(msg.Key))
+		logger.Debugf("Fetched partition=%d offset=%d key=%q", msg.Partition, msg.Offset, string(msg.Key))
 
-		var hErr error
-		for attempt := 1; attempt <= 3; attempt++ {
-			hErr = svc.HandleMessage(ctx, msg.Key, msg.Value)
-			if hErr == nil {
-				break
+		tracker.track(msg)
+		wg.Add(1)
+		go func(msg kafka.Message) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if processMessage(ctx, cfg, svc, msg) {
+				completed <- msg
 			}
-			logger.Warnf("Handle failed attempt=%d offset=%d err=%v", attempt, msg.Offset, hErr)
-			select {
-			case <-time.After(time.Duration(attempt) * 300 * time.Millisecond):
-			case <-ctx.Done():
-				return ctx.Err()
+		}(msg)
+	}
+}
+
+// processMessage runs the handler with retries. It reports false when the
+// job was interrupted by shutdown, in which case the offset must not be
+// committed so the message is redelivered.
+func processMessage(ctx context.Context, cfg config.Config, svc *Service, msg kafka.Message) bool {
+	var (
+		hErr     error
+		attempts int
+	)
+	ctx = withSourceTopic(ctx, msg.Topic)
+	for attempts < cfg.MaxAttempts {
+		attempts++
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if cfg.JobTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, cfg.JobTimeout)
+		}
+		if attempts == cfg.MaxAttempts {
+			attemptCtx = withFinalAttempt(attemptCtx)
+		}
+		hErr = svc.HandleMessage(attemptCtx, msg.Key, msg.Value)
+		cancel()
+		if hErr == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		if retryx.IsPermanent(hErr) {
+			logger.Warnf("Handle failed permanently attempt=%d partition=%d offset=%d err=%v", attempts, msg.Partition, msg.Offset, hErr)
+			break
+		}
+		logger.Warnf("Handle failed attempt=%d partition=%d offset=%d err=%v", attempts, msg.Partition, msg.Offset, hErr)
+		if attempts == cfg.MaxAttempts {
+			break
+		}
+		select {
+		case <-time.After(retryx.Backoff(attempts, cfg.RetryBaseDelay, cfg.RetryMaxDelay)):
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if hErr != nil {
+		svc.emitFailure(ctx, msg, hErr, attempts)
+		if svc.deadLetter(ctx, msg, hErr, attempts) {
+			logger.Errorf("dead-lettered message partition=%d offset=%d err=%v", msg.Partition, msg.Offset, hErr)
+		} else {
+			if ctx.Err() != nil {
+				return false
 			}
+			logger.Errorf("dropping message after retries partition=%d offset=%d err=%v", msg.Partition, msg.Offset, hErr)
 		}
+		// (we still commit to avoid blocking the partition)
+	}
+	return true
+}
 
-		if hErr != nil {
-			// Consider producing to a DLQ here
-			logger.Errorf("dropping message after retries offset=%d err=%v", msg.Offset, hErr)
-			// (we still commit to avoid blocking the partition)
+// commitLoop is the only goroutine that commits, so commits for a partition
+// are always issued in increasing offset order.
+func commitLoop(r *kafka.Reader, tracker *offsetTracker, completed <-chan kafka.Message) {
+	for msg := range completed {
+		commit, ok := tracker.complete(msg)
+		if !ok {
+			continue
 		}
 
-		if err := r.CommitMessages(ctx, msg); err != nil {
-			logger.Warnf("commit failed offset=%d err=%v", msg.Offset, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := r.CommitMessages(ctx, commit)
+		cancel()
+		if err != nil {
+			logger.Warnf("commit failed partition=%d offset=%d err=%v", commit.Partition, commit.Offset, err)
 		} else {
-			logger.Debugf("committed offset=%d", msg.Offset)
+			logger.Debugf("committed partition=%d offset=%d", commit.Partition, commit.Offset)
 		}
-
 	}
 }
 
@@ -0,0 +1,99 @@
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// Built-in job types.
+const (
+	JobMerge        = "merge"
+	JobTranscode    = "transcode"
+	JobThumbnail    = "thumbnail"
+	JobExtractAudio = "extract-audio"
+	JobProbe        = "probe"
+)
+
+// Envelope is the versioned wrapper every job message is sent in. Messages
+// without a type are legacy bare MergeRequests.
+type Envelope struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+var ErrUnknownJobType = errors.New("unknown job type")
+
+// JobHandler runs one job type. The payload is the envelope's payload, not
+// the whole message.
+type JobHandler interface {
+	Handle(ctx context.Context, payload json.RawMessage) error
+}
+
+type JobHandlerFunc func(ctx context.Context, payload json.RawMessage) error
+
+func (f JobHandlerFunc) Handle(ctx context.Context, payload json.RawMessage) error {
+	return f(ctx, payload)
+}
+
+// Registry maps (type, version) to the handler for it.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[registryKey]JobHandler
+}
+
+type registryKey struct {
+	jobType string
+	version int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[registryKey]JobHandler)}
+}
+
+// Register adds h for jobType at version (0 is treated as 1), replacing any
+// earlier registration.
+func (r *Registry) Register(jobType string, version int, h JobHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[registryKey{jobType, normVersion(version)}] = h
+}
+
+func (r *Registry) Lookup(jobType string, version int) (JobHandler, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[registryKey{jobType, normVersion(version)}]
+	if !ok {
+		return nil, fmt.Errorf("%w %q version %d", ErrUnknownJobType, jobType, normVersion(version))
+	}
+	return h, nil
+}
+
+func normVersion(v int) int {
+	if v <= 0 {
+		return 1
+	}
+	return v
+}
+
+// decodeEnvelope unwraps a message. A value without a "type" field is a
+// legacy MergeRequest and is dispatched as merge v1.
+func decodeEnvelope(value []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(value, &env); err != nil {
+		return env, retryx.Permanent(fmt.Errorf("parse job envelope: %w", err))
+	}
+	if env.Type == "" {
+		return Envelope{Type: JobMerge, Version: 1, Payload: value}, nil
+	}
+	if len(bytes.TrimSpace(env.Payload)) == 0 {
+		return env, retryx.Permanent(fmt.Errorf("job %q has no payload", env.Type))
+	}
+	return env, nil
+}
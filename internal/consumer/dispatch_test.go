@@ -0,0 +1,116 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		wantType    string
+		wantVersion int
+		wantPayload string
+		wantErr     bool
+	}{
+		{
+			name:        "envelope",
+			value:       `{"type":"thumbnail","version":2,"payload":{"bucket":"b"}}`,
+			wantType:    JobThumbnail,
+			wantVersion: 2,
+			wantPayload: `{"bucket":"b"}`,
+		},
+		{
+			name:        "legacy merge request",
+			value:       `{"video_bucket":"b","video_key":"v.mp4"}`,
+			wantType:    JobMerge,
+			wantVersion: 1,
+			wantPayload: `{"video_bucket":"b","video_key":"v.mp4"}`,
+		},
+		{name: "missing payload", value: `{"type":"probe"}`, wantErr: true},
+		{name: "not json", value: `merge please`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := decodeEnvelope([]byte(tt.value))
+			if tt.wantErr {
+				if err == nil || !retryx.IsPermanent(err) {
+					t.Fatalf("decodeEnvelope error = %v, want a permanent error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.Type != tt.wantType || env.Version != tt.wantVersion || string(env.Payload) != tt.wantPayload {
+				t.Errorf("decodeEnvelope = %s v%d %s, want %s v%d %s", env.Type, env.Version, env.Payload, tt.wantType, tt.wantVersion, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	handler := func(name string) JobHandler {
+		return JobHandlerFunc(func(context.Context, json.RawMessage) error { return errors.New(name) })
+	}
+	r := NewRegistry()
+	r.Register(JobMerge, 0, handler("merge v1"))
+	r.Register(JobMerge, 2, handler("merge v2"))
+	r.Register(JobProbe, 1, handler("old probe"))
+	r.Register(JobProbe, 1, handler("probe")) // replaces
+
+	tests := []struct {
+		jobType string
+		version int
+		want    string
+	}{
+		{JobMerge, 0, "merge v1"},
+		{JobMerge, 1, "merge v1"},
+		{JobMerge, 2, "merge v2"},
+		{JobProbe, 0, "probe"},
+		{JobMerge, 3, ""},
+		{"resize", 1, ""},
+	}
+	for _, tt := range tests {
+		h, err := r.Lookup(tt.jobType, tt.version)
+		if tt.want == "" {
+			if !errors.Is(err, ErrUnknownJobType) {
+				t.Errorf("Lookup(%s, %d) error = %v, want ErrUnknownJobType", tt.jobType, tt.version, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Lookup(%s, %d): %v", tt.jobType, tt.version, err)
+			continue
+		}
+		if got := h.Handle(context.Background(), nil).Error(); got != tt.want {
+			t.Errorf("Lookup(%s, %d) = %s handler, want %s", tt.jobType, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestHandleMessageDispatch(t *testing.T) {
+	var got json.RawMessage
+	s := &Service{registry: NewRegistry()}
+	s.Register("echo", 1, JobHandlerFunc(func(_ context.Context, payload json.RawMessage) error {
+		got = payload
+		return nil
+	}))
+
+	if err := s.HandleMessage(context.Background(), nil, []byte(`{"type":"echo","payload":{"n":1}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"n":1}` {
+		t.Errorf("handler got payload %s", got)
+	}
+
+	err := s.HandleMessage(context.Background(), nil, []byte(`{"type":"resize","payload":{}}`))
+	if !errors.Is(err, ErrUnknownJobType) || !retryx.IsPermanent(err) || failedStage(err) != StageDecode {
+		t.Errorf("unknown type error = %v (stage %q), want a permanent decode error", err, failedStage(err))
+	}
+}
@@ -0,0 +1,87 @@
+package consumer
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// Headers attached to every dead-lettered message. The original key, value
+// and headers are kept as-is so the message can be replayed unchanged.
+const (
+	DLQHeaderPrefix    = "x-dlq-"
+	DLQHeaderError     = DLQHeaderPrefix + "error"
+	DLQHeaderAttempts  = DLQHeaderPrefix + "attempts"
+	DLQHeaderPermanent = DLQHeaderPrefix + "permanent"
+	DLQHeaderTopic     = DLQHeaderPrefix + "source-topic"
+	DLQHeaderPartition = DLQHeaderPrefix + "source-partition"
+	DLQHeaderOffset    = DLQHeaderPrefix + "source-offset"
+	DLQHeaderFailedAt  = DLQHeaderPrefix + "failed-at"
+)
+
+type DLQWriter struct {
+	w *kafka.Writer
+}
+
+func NewDLQWriter(brokers []string, topic string) *DLQWriter {
+	return &DLQWriter{w: kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  brokers,
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
+	})}
+}
+
+// Publish dead-letters msg together with the error that exhausted its
+// retries.
+func (d *DLQWriter) Publish(ctx context.Context, msg kafka.Message, cause error, attempts int) error {
+	now := time.Now().UTC()
+
+	headers := StripDLQHeaders(msg.Headers)
+	headers = append(headers,
+		kafka.Header{Key: DLQHeaderError, Value: []byte(cause.Error())},
+		kafka.Header{Key: DLQHeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: DLQHeaderPermanent, Value: []byte(strconv.FormatBool(retryx.IsPermanent(cause)))},
+		kafka.Header{Key: DLQHeaderTopic, Value: []byte(msg.Topic)},
+		kafka.Header{Key: DLQHeaderPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: DLQHeaderOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafka.Header{Key: DLQHeaderFailedAt, Value: []byte(now.Format(time.RFC3339Nano))},
+	)
+
+	return d.w.WriteMessages(ctx, kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    now,
+	})
+}
+
+func (d *DLQWriter) Close() error {
+	return d.w.Close()
+}
+
+// StripDLQHeaders returns headers without any dead-letter bookkeeping, so a
+// message that fails again after a replay does not carry stale values.
+func StripDLQHeaders(headers []kafka.Header) []kafka.Header {
+	out := make([]kafka.Header, 0, len(headers))
+	for _, h := range headers {
+		if strings.HasPrefix(h.Key, DLQHeaderPrefix) {
+			continue
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+// HeaderValue returns the value of a header, or "" if it is missing.
+func HeaderValue(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
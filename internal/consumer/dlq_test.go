@@ -0,0 +1,55 @@
+package consumer
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestStripDLQHeaders(t *testing.T) {
+	h := func(key, value string) kafka.Header { return kafka.Header{Key: key, Value: []byte(value)} }
+	tests := []struct {
+		name string
+		in   []kafka.Header
+		want []string
+	}{
+		{"none", nil, nil},
+		{"no dlq headers", []kafka.Header{h("trace-id", "1")}, []string{"trace-id"}},
+		{
+			name: "stale dlq headers dropped",
+			in:   []kafka.Header{h(DLQHeaderError, "boom"), h("trace-id", "1"), h(DLQHeaderAttempts, "3")},
+			want: []string{"trace-id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, h := range StripDLQHeaders(tt.in) {
+				got = append(got, h.Key)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("StripDLQHeaders = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderValue(t *testing.T) {
+	msg := kafka.Message{Headers: []kafka.Header{
+		{Key: DLQHeaderAttempts, Value: []byte("3")},
+		{Key: "empty"},
+	}}
+	tests := []struct {
+		key, want string
+	}{
+		{DLQHeaderAttempts, "3"},
+		{"empty", ""},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		if got := HeaderValue(msg, tt.key); got != tt.want {
+			t.Errorf("HeaderValue(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/ffmpegx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
+)
+
+// Stages of a merge job, reported as MergeResult.FailedStage.
+const (
+	StageDecode   = "decode"
+	StageValidate = "validate"
+	StagePolicy   = "policy"
+	StagePrepare  = "prepare"
+	StageDownload = "download"
+	StageProbe    = "probe"
+	StageMerge    = "merge"
+	StageProcess  = "process"
+	StageUpload   = "upload"
+)
+
+// Error categories, reported as MergeResult.ErrorCategory.
+const (
+	CategoryInvalidRequest = "invalid_request"
+	CategoryPolicy         = "policy_violation"
+	CategoryStorage        = "storage"
+	CategoryMedia          = "media"
+	CategoryTimeout        = "timeout"
+	CategoryInternal       = "internal"
+)
+
+// StageError tags an error with the job stage it happened in.
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string { return e.Err.Error() }
+func (e *StageError) Unwrap() error { return e.Err }
+
+func atStage(stage string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StageError{Stage: stage, Err: err}
+}
+
+// failedStage reports the stage an error came from. ffmpeg errors carry their
+// own step (probe vs merge), which is more precise than the caller's stage.
+func failedStage(err error) string {
+	var opErr *ffmpegx.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op
+	}
+	var stErr *StageError
+	if errors.As(err, &stErr) {
+		return stErr.Stage
+	}
+	return ""
+}
+
+func errorCategory(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return CategoryTimeout
+	}
+	var opErr *ffmpegx.OpError
+	if errors.As(err, &opErr) {
+		return CategoryMedia
+	}
+	switch failedStage(err) {
+	case StageDecode, StageValidate:
+		return CategoryInvalidRequest
+	case StagePolicy:
+		return CategoryPolicy
+	case StageDownload, StageUpload:
+		return CategoryStorage
+	case StageProbe, StageMerge, StageProcess:
+		return CategoryMedia
+	default:
+		return CategoryInternal
+	}
+}
+
+// emitFailure publishes the terminal "failed" result for a message whose
+// retries are exhausted, so downstream can close out the job.
+func (s *Service) emitFailure(ctx context.Context, msg kafka.Message, cause error, attempts int) {
+	// Best effort: the message may be the very thing that failed to decode.
+	env, _ := decodeEnvelope(msg.Value)
+
+	var violations []FieldError
+	var vErr *ValidationError
+	if errors.As(cause, &vErr) {
+		violations = vErr.Fields
+	}
+
+	var err error
+	if env.Type == JobMerge {
+		var req MergeRequest
+		_ = json.Unmarshal(env.Payload, &req)
+
+		res := MergeResult{
+			Status:        "failed",
+			VideoID:       req.VideoID,
+			CorrelationID: req.CorrelationID,
+			Error:         cause.Error(),
+			ErrorCategory: errorCategory(cause),
+			FailedStage:   failedStage(cause),
+			Attempts:      attempts,
+			Permanent:     retryx.IsPermanent(cause),
+			Violations:    violations,
+		}
+		setFailedOutput(&res, req)
+		err = s.emitResult(ctx, res)
+	} else {
+		var req MediaRequest
+		_ = json.Unmarshal(env.Payload, &req)
+
+		err = s.emitJobResult(ctx, JobResult{
+			Type:          env.Type,
+			Status:        "failed",
+			MediaID:       req.MediaKey,
+			OutputBucket:  req.OutputBucket,
+			OutputKey:     req.OutputKey,
+			OutputURI:     req.OutputURI,
+			CorrelationID: req.CorrelationID,
+			Error:         cause.Error(),
+			ErrorCategory: errorCategory(cause),
+			FailedStage:   failedStage(cause),
+			Attempts:      attempts,
+			Permanent:     retryx.IsPermanent(cause),
+			Violations:    violations,
+		})
+	}
+
+	if err != nil {
+		logger.Warnf("emit failure result failed offset=%d: %v", msg.Offset, err)
+	}
+}
+
+// setFailedOutput reports the output the merge was going to write, derived
+// the same way as for a successful merge. A request too broken to name a
+// bucket or key reports none.
+func setFailedOutput(res *MergeResult, req MergeRequest) {
+	out := req.outputLocation()
+	if out.Key == "" || out.Scheme == storage.SchemeS3 && out.Bucket == "" {
+		return
+	}
+	res.OutputURI = out.String()
+	if out.Scheme == storage.SchemeS3 {
+		res.OutputBucket, res.OutputKey = out.Bucket, out.Key
+	}
+}
@@ -0,0 +1,81 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/ffmpegx"
+)
+
+func TestFailedStageAndCategory(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name         string
+		err          error
+		wantStage    string
+		wantCategory string
+	}{
+		{"untagged", boom, "", CategoryInternal},
+		{"decode", atStage(StageDecode, boom), StageDecode, CategoryInvalidRequest},
+		{"validate", atStage(StageValidate, boom), StageValidate, CategoryInvalidRequest},
+		{"policy", atStage(StagePolicy, boom), StagePolicy, CategoryPolicy},
+		{"download", atStage(StageDownload, boom), StageDownload, CategoryStorage},
+		{"upload wrapped", fmt.Errorf("job: %w", atStage(StageUpload, boom)), StageUpload, CategoryStorage},
+		{"process", atStage(StageProcess, boom), StageProcess, CategoryMedia},
+		{"prepare", atStage(StagePrepare, boom), StagePrepare, CategoryInternal},
+		{
+			name:         "ffmpeg step wins over the caller's stage",
+			err:          atStage(StageMerge, &ffmpegx.OpError{Op: ffmpegx.OpProbe, Err: boom}),
+			wantStage:    ffmpegx.OpProbe,
+			wantCategory: CategoryMedia,
+		},
+		{"timeout", atStage(StageDownload, context.DeadlineExceeded), StageDownload, CategoryTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := failedStage(tt.err); got != tt.wantStage {
+				t.Errorf("failedStage = %q, want %q", got, tt.wantStage)
+			}
+			if got := errorCategory(tt.err); got != tt.wantCategory {
+				t.Errorf("errorCategory = %q, want %q", got, tt.wantCategory)
+			}
+		})
+	}
+}
+
+func TestAtStageNil(t *testing.T) {
+	if err := atStage(StageMerge, nil); err != nil {
+		t.Errorf("atStage(nil) = %v, want nil", err)
+	}
+}
+
+func TestSetFailedOutput(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        MergeRequest
+		wantURI    string
+		wantBucket string
+		wantKey    string
+	}{
+		{"undecoded", MergeRequest{}, "", "", ""},
+		{"derived", MergeRequest{VideoBucket: "media-in", VideoKey: "v/clip.mov"},
+			"s3://media-in/v/clip_merged.mp4", "media-in", "v/clip_merged.mp4"},
+		{"output bucket", MergeRequest{VideoBucket: "media-in", VideoKey: "v/clip.mov", OutputBucket: "media-out"},
+			"s3://media-out/v/clip_merged.mp4", "media-out", "v/clip_merged.mp4"},
+		{"output uri", MergeRequest{VideoURI: "s3://media-in/v.mp4", OutputURI: "s3://media-out/o.mp4"},
+			"s3://media-out/o.mp4", "media-out", "o.mp4"},
+		{"local output", MergeRequest{VideoURI: "file:///data/v.mp4"}, "file:///data/v_merged.mp4", "", ""},
+		{"bad output uri", MergeRequest{VideoURI: "s3://media-in/v.mp4", OutputURI: "ftp://host/o.mp4"}, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res MergeResult
+			setFailedOutput(&res, tt.req)
+			if res.OutputURI != tt.wantURI || res.OutputBucket != tt.wantBucket || res.OutputKey != tt.wantKey {
+				t.Errorf("output %q (%q, %q), want %q (%q, %q)", res.OutputURI, res.OutputBucket, res.OutputKey, tt.wantURI, tt.wantBucket, tt.wantKey)
+			}
+		})
+	}
+}
@@ -2,10 +2,13 @@ package consumer
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"os"
+	"io"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,7 +16,9 @@ import (
 	"github.com/yangjie500/media_extractor_ffmpeg/pkg/config"
 	"github.com/yangjie500/media_extractor_ffmpeg/pkg/ffmpegx"
 	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
 	"github.com/yangjie500/media_extractor_ffmpeg/pkg/s3x"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
 )
 
 type MergeRequest struct {
@@ -30,26 +35,89 @@ type MergeRequest struct {
 	CorrelationID string `json:"correlation_id,omitempty"`
 	OutputBucket  string `json:"output_bucket,omitempty"` // default: VideoBucket
 	OutputKey     string `json:"output_key,omitempty"`    // default: derived from VideoKey
+	OutputFormat  string `json:"output_format,omitempty"` // mp4, fmp4, mkv, webm, mov; default MERGE_OUTPUT_FORMAT
+
+	// Locations as URIs (s3://, file://, http(s)://), instead of the
+	// bucket/key pairs above
+	VideoURI  string `json:"video_uri,omitempty"`
+	AudioURI  string `json:"audio_uri,omitempty"`
+	OutputURI string `json:"output_uri,omitempty"`
+
+	// AudioTracks merges several audio inputs, each with its own language
+	// and title, instead of the single audio fields above.
+	AudioTracks []AudioTrack `json:"audio_tracks,omitempty"`
+
+	// Streaming reads inputs and writes the output without local copies
+	// where the storage allows it; default MERGE_STREAMING.
+	Streaming *bool `json:"streaming,omitempty"`
+
+	// FastStart puts the MP4/MOV moov at the front so players can start
+	// before the download finishes; default on for web formats (mp4, fmp4).
+	FastStart *bool `json:"faststart,omitempty"`
+
+	MergeCodecs
+	MergeSync
+	OutputOptions
 }
 
 type MergeResult struct {
-	Status        string  `json:"status"`
-	VideoID       string  `json:"video_id"`
-	OutputBucket  string  `json:"output_bucket,omitempty"`
-	OutputKey     string  `json:"output_key,omitempty"`
-	ETag          string  `json:"etag,omitempty"`
-	SizeBytes     int64   `json:"size_bytes,omitempty"`
-	DurationSec   float64 `json:"duration_sec,omitempty"`
-	CorrelationID string  `json:"correlation_id,omitempty"`
-	Error         string  `json:"err,omitempty"`
+	Status         string       `json:"status"`
+	VideoID        string       `json:"video_id"`
+	OutputBucket   string       `json:"output_bucket,omitempty"`
+	OutputKey      string       `json:"output_key,omitempty"`
+	OutputURI      string       `json:"output_uri,omitempty"`
+	ETag           string       `json:"etag,omitempty"`
+	ChecksumSHA256 string       `json:"checksum_sha256,omitempty"`
+	SizeBytes      int64        `json:"size_bytes,omitempty"`
+	DurationSec    float64      `json:"duration_sec,omitempty"`
+	CorrelationID  string       `json:"correlation_id,omitempty"`
+	Error          string       `json:"err,omitempty"`
+	ErrorCategory  string       `json:"error_category,omitempty"`
+	FailedStage    string       `json:"failed_stage,omitempty"`
+	Attempts       int          `json:"attempts,omitempty"`
+	Permanent      bool         `json:"permanent,omitempty"`
+	Violations     []FieldError `json:"violations,omitempty"`
+
+	// Codecs says which streams were copied and which transcoded
+	Codecs *ffmpegx.MergePlan `json:"codecs,omitempty"`
+	// Mismatch is the measured audio/video length difference, when known
+	Mismatch *ffmpegx.Mismatch `json:"mismatch,omitempty"`
+	// FastStart is set when the output's moov was placed at the front
+	FastStart bool `json:"faststart,omitempty"`
+
+	DownloadLink
 }
 
 type Service struct {
 	cfg          config.Config
 	resultWriter *kafka.Writer
+	dlqWriter    *DLQWriter
+	progress     *kafka.Writer // nil unless KAFKA_TOPIC_PROGRESS is set
+	jobs         JobStore
+	registry     *Registry
+	policy       *Policy
+	workLocks    keyedMutex
+	s3           *s3x.Pool
+	files        *storage.FileBackend // nil unless STORAGE_FILE_ROOT is set
+	web          *storage.HTTPBackend // nil unless STORAGE_HTTP_ALLOWED_HOSTS is set
+
+	ffmpegVersion string // recorded on every output
 }
 
-func NewService(cfg config.Config) *Service {
+func NewService(cfg config.Config) (*Service, error) {
+	var v validator
+	v.outputFormat(cfg.MergeOutputFormat)
+	v.mergeCodecs("", MergeCodecs{
+		VideoCodec:   cfg.MergeVideoCodec,
+		AudioCodec:   cfg.MergeAudioCodec,
+		VideoBitrate: cfg.MergeVideoBitrate,
+		AudioBitrate: cfg.MergeAudioBitrate,
+	})
+	v.mergeSync(MergeSync{Mismatch: cfg.MergeMismatchPolicy})
+	if err := v.err(); err != nil {
+		return nil, fmt.Errorf("MERGE_* settings: %w", err)
+	}
+
 	var w *kafka.Writer
 
 	if cfg.KafkaProducerTopic != "" {
@@ -60,119 +128,485 @@ func NewService(cfg config.Config) *Service {
 		})
 	}
 
-	return &Service{cfg: cfg, resultWriter: w}
+	var dlq *DLQWriter
+	if cfg.KafkaDLQTopic != "" {
+		dlq = NewDLQWriter(cfg.KafkaProducerBroker, cfg.KafkaDLQTopic)
+	}
+
+	var progress *kafka.Writer
+	if cfg.KafkaProgressTopic != "" {
+		progress = kafka.NewWriter(kafka.WriterConfig{
+			Brokers:  cfg.KafkaProducerBroker,
+			Topic:    cfg.KafkaProgressTopic,
+			Balancer: &kafka.Hash{}, // a job's events stay in order
+		})
+	}
+
+	svc := &Service{cfg: cfg, resultWriter: w, dlqWriter: dlq, progress: progress, registry: NewRegistry(), s3: s3x.NewPool()}
+	svc.registerBuiltins()
+	sweepStaleWorkDirs()
+
+	vctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	version, err := ffmpegx.Version(vctx)
+	cancel()
+	if err != nil {
+		logger.Warnf("ffmpeg version unknown: %v", err)
+	}
+	svc.ffmpegVersion = version
+
+	if cfg.StorageFileRoot != "" {
+		files, err := storage.NewFile(cfg.StorageFileRoot)
+		if err != nil {
+			svc.Close()
+			return nil, err
+		}
+		svc.files = files
+	}
+	if len(cfg.StorageHTTPAllowedHosts) > 0 {
+		svc.web = storage.NewHTTP(cfg.StorageHTTPAllowedHosts)
+	}
+
+	if cfg.PolicyFile != "" {
+		policy, err := LoadPolicy(cfg.PolicyFile)
+		if err != nil {
+			svc.Close()
+			return nil, err
+		}
+		// Every message would be rejected, so fail at startup instead.
+		if _, _, ok := policy.tenantFor(cfg.KafkaTopic); !ok {
+			svc.Close()
+			return nil, fmt.Errorf("policy %s: topic %q does not belong to any tenant", cfg.PolicyFile, cfg.KafkaTopic)
+		}
+		svc.policy = policy
+	}
+
+	if cfg.JobStorePath != "" {
+		jobs, err := OpenBoltJobStore(cfg.JobStorePath, cfg.JobStoreTTL)
+		if err != nil {
+			svc.Close()
+			return nil, err
+		}
+		svc.jobs = jobs
+	}
+
+	return svc, nil
 }
 
 func (s *Service) Close() {
 	if s.resultWriter != nil {
 		_ = s.resultWriter.Close()
 	}
+	if s.dlqWriter != nil {
+		_ = s.dlqWriter.Close()
+	}
+	if s.progress != nil {
+		_ = s.progress.Close()
+	}
+	if s.jobs != nil {
+		_ = s.jobs.Close()
+	}
+}
+
+// Register adds a handler for a job type, so new operations can be plugged
+// in without touching the Kafka loop.
+func (s *Service) Register(jobType string, version int, h JobHandler) {
+	s.registry.Register(jobType, version, h)
 }
 
+// HandleMessage unwraps the job envelope and dispatches it to the handler
+// registered for its type and version.
 func (s *Service) HandleMessage(ctx context.Context, key, value []byte) error {
-	var req MergeRequest
-	if err := json.Unmarshal(value, &req); err != nil {
-		return fmt.Errorf("parse merge request: %w", err)
+	env, err := decodeEnvelope(value)
+	if err != nil {
+		return atStage(StageDecode, err)
 	}
 
-	region := req.Region
-	if region == "" {
-		region = s.cfg.Region
+	h, err := s.registry.Lookup(env.Type, env.Version)
+	if err != nil {
+		return atStage(StageDecode, retryx.Permanent(err))
 	}
-	if region == "" {
-		return fmt.Errorf("missing AWS region (event.Region empty and AWS_REGION not configured)")
+
+	return h.Handle(ctx, env.Payload)
+}
+
+// validator checks requests against this service's S3 setup: a custom
+// endpoint may use any region name.
+func (s *Service) validator() validator {
+	return validator{anyRegion: s.cfg.S3Endpoint != ""}
+}
+
+func (s *Service) handleMerge(ctx context.Context, payload json.RawMessage) (err error) {
+	var req MergeRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return atStage(StageDecode, retryx.Permanent(fmt.Errorf("parse merge request: %w", err)))
 	}
 
-	// Derive output location if missing
-	outBucket := req.OutputBucket
-	if outBucket == "" {
-		outBucket = req.VideoBucket
+	if req.Region == "" {
+		req.Region = s.cfg.Region
+	}
+	if req.OutputFormat == "" {
+		req.OutputFormat = s.cfg.MergeOutputFormat
+	}
+	v := s.validator()
+	req.validate(&v, s.cfg.AllowedRegions)
+	if err := v.err(); err != nil {
+		return atStage(StageValidate, err)
 	}
-	outKey := req.OutputKey
-	if outKey == "" {
-		outKey = deriveOutputKey(req.VideoKey)
+	format, _ := ffmpegx.LookupOutputFormat(req.OutputFormat)
+
+	videoLoc, outLoc := req.videoLocation(), req.outputLocation()
+	tracks := req.tracks()
+	inputs := []location{policyLocation(fieldFor("video_bucket", "video_uri", req.VideoURI), videoLoc)}
+	for i, t := range tracks {
+		field := req.trackField(i, fieldFor("audio_bucket", "audio_uri", t.AudioURI))
+		inputs = append(inputs, policyLocation(field, t.location()))
+	}
+	if err := s.checkPolicy(ctx, inputs, []location{
+		policyLocation(fieldFor("output_bucket", "output_uri", req.OutputURI), outLoc),
+	}); err != nil {
+		return err
+	}
+
+	presignTTL := s.presignTTL(req.PresignTTL)
+	refreshLink := func(res *MergeResult) {
+		res.DownloadLink = s.downloadLink(ctx, outLoc, req.Region, presignTTL)
 	}
 
-	// Work dir (unique per job)
-	jobDir, err := os.MkdirTemp("./tmp", "merge-*")
+	// Work dir (stable per job, so a retry resumes partial downloads). It
+	// also locks the job key, so the store is checked under the lock: a
+	// second delivery waiting here sees the first one's result.
+	jobKey := req.JobKey()
+	jobDir, release, err := s.acquireWorkDir(ctx, "merge", jobKey)
 	if err != nil {
-		return fmt.Errorf("mktemp: %w", err)
+		return atStage(StagePrepare, err)
+	}
+	defer func() { release(err) }()
+
+	if done, err := s.replayCompleted(ctx, jobKey, refreshLink); err != nil {
+		logger.Warnf("job store lookup failed key=%s: %v", jobKey, err)
+	} else if done {
+		return nil
 	}
-	defer os.RemoveAll(jobDir)
 
 	videoPath := filepath.Join(jobDir, "video_in.mp4")
-	audioPath := filepath.Join(jobDir, "audio_in.m4a")
-	mergedPath := filepath.Join(jobDir, "merged_out.mp4")
+	mergedPath := filepath.Join(jobDir, "merged_out"+format.Ext)
 
-	s3c, err := s3x.New(ctx, region)
+	mergeOpts := s.mergeOptions(format, req.MergeCodecs, req.MergeSync)
+	mergeOpts.FastStart = fastStart(format, req.FastStart)
+
+	// Inputs: streamed when asked and the source allows it, else downloaded
+	stream, seek := s.streaming(req.Streaming), needsSeek(mergeOpts.Mismatch)
+	video, releaseVideo, err := s.mergeInput(ctx, videoLoc, req.Region, videoPath, stream, seek)
 	if err != nil {
-		return fmt.Errorf("s3 init: %w", err)
+		return fmt.Errorf("download video: %w", err)
+	}
+	defer releaseVideo()
+	audio := make([]ffmpegx.AudioTrack, len(tracks))
+	var audioIDs, audioSources []string
+	for i, t := range tracks {
+		in, releaseAudio, err := s.mergeInput(ctx, t.location(), req.Region, audioInPath(jobDir, i), stream, seek)
+		if err != nil {
+			return fmt.Errorf("download %s: %w", ffmpegx.TrackName(i, len(tracks)), err)
+		}
+		defer releaseAudio()
+		audio[i] = ffmpegx.AudioTrack{
+			Input:    in,
+			Language: t.Language,
+			Title:    t.Title,
+			Default:  t.Default,
+			Offset:   time.Duration(t.OffsetMs) * time.Millisecond,
+		}
+		audioIDs, audioSources = append(audioIDs, t.AudioID), append(audioSources, t.location().String())
 	}
 
-	// Download inputs
-	logger.Infof("downloading s3://%s/%s", req.VideoBucket, req.VideoKey)
-	vf, err := os.Create(videoPath)
+	outStore, err := s.backend(ctx, outLoc, req.Region)
 	if err != nil {
-		return fmt.Errorf("create video file: %w", err)
+		return atStage(StagePrepare, err)
 	}
-	if err := s3c.GetObjectToWriter(ctx, req.VideoBucket, req.VideoKey, vf); err != nil {
-		vf.Close()
-		return fmt.Errorf("download video: %w", err)
+	policy := s.overwritePolicy(req.OverwritePolicy)
+	uploadOpts := func(duration float64) storage.PutOptions {
+		trace := s.trace(JobMerge, req.CorrelationID, duration)
+		trace["media-id"] = req.MediaKey
+		trace["video-id"] = req.VideoID
+		trace["audio-id"] = strings.Join(audioIDs, ",")
+		trace["video-source"] = videoLoc.String()
+		trace["audio-source"] = strings.Join(audioSources, ",")
+		return s.uploadOptions(format.ContentType, req.OutputOptions, trace)
 	}
-	vf.Close()
-
-	logger.Infof("downloading s3://%s/%s", req.AudioBucket, req.AudioKey)
-	af, err := os.Create(audioPath)
+	report, stopProgress := s.progressReporter(ctx, jobKey, ProgressEvent{
+		Type:          JobMerge,
+		MediaID:       req.MediaKey,
+		VideoID:       req.VideoID,
+		CorrelationID: req.CorrelationID,
+	})
+	defer stopProgress()
+	mergeOpts.OnProgress = report
+
+	var (
+		up       storage.PutResult
+		skipped  bool
+		duration float64
+		plan     ffmpegx.MergePlan
+
+		fastStarted bool // streamed output is fragmented, never faststart
+	)
+	merge, err := ffmpegx.PrepareMerge(ctx, video, audio, mergeOpts)
 	if err != nil {
-		return fmt.Errorf("create audio file: %w", err)
+		return atStage(StageMerge, err)
 	}
-	if err := s3c.GetObjectToWriter(ctx, req.AudioBucket, req.AudioKey, af); err != nil {
-		af.Close()
-		return fmt.Errorf("download audio: %w", err)
+	plan = merge.Plan
+	if sp, ok := outStore.(storage.StreamPutter); ok && stream && policy != OverwriteIfDifferent {
+		// Merge straight into the upload
+		duration = merge.OutputSec
+		logger.Infof("merging -> %s (streaming)", outLoc)
+		up, skipped, err = streamMerged(ctx, outStore, sp, outLoc, policy, uploadOpts(duration), func(w io.Writer) error {
+			return merge.Run(ctx, ffmpegx.Output{Writer: w})
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		// Merge with ffmpeg
+		logger.Infof("merging -> %s", mergedPath)
+		if err := merge.Run(ctx, ffmpegx.FileOutput(mergedPath)); err != nil {
+			// The failure result is emitted once retries are exhausted
+			return atStage(StageMerge, err)
+		}
+		// Run checked the moov position, so report it
+		fastStarted = mergeOpts.FastStart && format.FastStartApplies()
+
+		// Probe output for duration (optional)
+		si, _ := ffmpegx.Probe(ctx, mergedPath)
+		duration = si.Duration
+
+		// Upload merged
+		logger.Infof("uploading %s", outLoc)
+		up, skipped, err = uploadOutput(ctx, outStore, outLoc, mergedPath, policy, uploadOpts(duration))
+		if err != nil {
+			return atStage(StageUpload, fmt.Errorf("upload merged: %w", err))
+		}
 	}
-	af.Close()
 
-	// Merge with ffmpeg
-	logger.Infof("merging -> %s", mergedPath)
-	if err := ffmpegx.MergeAV(ctx, videoPath, audioPath, mergedPath, "aac"); err != nil {
-		// Emit failure result
-		return err
+	status := "merged"
+	if skipped {
+		status = StatusSkippedExists
 	}
+	res := MergeResult{
+		Status:         status,
+		VideoID:        req.VideoID,
+		OutputURI:      outLoc.String(),
+		ETag:           up.ETag,
+		ChecksumSHA256: up.ChecksumSHA256,
+		SizeBytes:      up.Size,
+		DurationSec:    duration,
+		CorrelationID:  req.CorrelationID,
+		Codecs:         &plan,
+		FastStart:      fastStarted && !skipped, // an existing object was not checked
+		Mismatch:       plan.Mismatch,
+	}
+	if outLoc.Scheme == storage.SchemeS3 {
+		res.OutputBucket, res.OutputKey = outLoc.Bucket, outLoc.Key
+	}
+	refreshLink(&res)
+
+	if err := s.emitResult(ctx, res); err != nil {
+		logger.Warnf("emit result failed: %v", err)
+	}
+	s.recordCompleted(ctx, jobKey, res)
 
-	// Probe output for duration (optional)
-	si, _ := ffmpegx.Probe(ctx, mergedPath)
+	logger.Infof("merge %s: %s (duration=%.2fs)", status, outLoc, duration)
+	return nil
 
-	// Upload merged
-	logger.Infof("uploading s3://%s/%s", outBucket, outKey)
-	if err := s3c.PutObjectFromFile(ctx, outBucket, outKey, mergedPath, "video/mp4"); err != nil {
-		return fmt.Errorf("upload merged: %w", err)
+}
+
+func (r MergeRequest) videoLocation() storage.Location {
+	return uriOrS3(r.VideoURI, r.VideoBucket, r.VideoKey)
+}
+
+// outputLocation derives the output location when the request leaves it
+// out: next to the video, in the same bucket or directory. A video served
+// over HTTP needs an explicit output (Validate checks this).
+func (r MergeRequest) outputLocation() storage.Location {
+	if r.OutputURI != "" {
+		loc, _ := storage.Parse(r.OutputURI)
+		return loc
+	}
+	video := r.videoLocation()
+	ext := ".mp4"
+	if f, err := ffmpegx.LookupOutputFormat(r.OutputFormat); err == nil {
+		ext = f.Ext
+	}
+	if video.Scheme == storage.SchemeFile && r.OutputBucket == "" {
+		return storage.Location{Scheme: storage.SchemeFile, Key: deriveOutputKey(video.Key, ext)}
 	}
 
-	res := MergeResult{
-		Status:        "merged",
-		VideoID:       req.VideoID,
-		OutputBucket:  outBucket,
-		OutputKey:     outKey,
-		DurationSec:   si.Duration,
-		CorrelationID: req.CorrelationID,
+	bucket, key := r.OutputBucket, r.OutputKey
+	if bucket == "" {
+		bucket = video.Bucket
+	}
+	if key == "" {
+		key = deriveOutputKey(strings.TrimPrefix(video.Path(), "/"), ext)
 	}
+	return storage.S3(bucket, key)
+}
 
-	if err := s.emitResult(ctx, res); err != nil {
+// uriOrS3 parses uri, or falls back to the legacy bucket/key pair. Requests
+// are validated first, so a bad URI never gets here.
+func uriOrS3(uri, bucket, key string) storage.Location {
+	if uri == "" {
+		return storage.S3(bucket, key)
+	}
+	loc, _ := storage.Parse(uri)
+	return loc
+}
+
+// fieldFor names the request field a location came from, for violations.
+func fieldFor(pairField, uriField, uri string) string {
+	if uri != "" {
+		return uriField
+	}
+	return pairField
+}
+
+// JobKey identifies a job for deduplication: the correlation ID when the
+// producer set one, otherwise a hash of the inputs and output location.
+func (r MergeRequest) JobKey() string {
+	if r.CorrelationID != "" {
+		return "cid:" + r.CorrelationID
+	}
+	h := sha256.New()
+	for _, f := range []string{
+		r.VideoBucket, r.VideoKey,
+		r.AudioBucket, r.AudioKey,
+		r.OutputBucket, r.OutputKey,
+		r.Region,
+	} {
+		h.Write([]byte(f))
+		h.Write([]byte{0})
+	}
+	// Only hashed when used, so keys of bucket/key requests stay the same.
+	if r.VideoURI != "" || r.AudioURI != "" || r.OutputURI != "" {
+		for _, f := range []string{r.VideoURI, r.AudioURI, r.OutputURI} {
+			h.Write([]byte(f))
+			h.Write([]byte{0})
+		}
+	}
+	if f := strings.ToLower(r.OutputFormat); f != "" && f != ffmpegx.DefaultOutputFormat {
+		h.Write([]byte("format=" + f))
+		h.Write([]byte{0})
+	}
+	// Tracks are hashed in full, labels included: they change the output.
+	for _, t := range r.AudioTracks {
+		fmt.Fprintf(h, "track=%s|%s|%s|%t|%d", t.location(), strings.ToLower(t.Language), t.Title, t.Default, t.OffsetMs)
+		h.Write([]byte{0})
+	}
+	if r.Mismatch != "" || r.AudioOffsetMs != 0 {
+		fmt.Fprintf(h, "sync=%s/%d", strings.ToLower(r.Mismatch), r.AudioOffsetMs)
+		h.Write([]byte{0})
+	}
+	// The remaining settings that shape the output, likewise only when set.
+	for _, opt := range []struct{ name, value string }{
+		{"video_codec", strings.ToLower(r.VideoCodec)},
+		{"audio_codec", strings.ToLower(r.AudioCodec)},
+		{"video_bitrate", r.VideoBitrate},
+		{"audio_bitrate", r.AudioBitrate},
+		{"mismatch_tolerance_ms", optionalInt(r.MismatchToleranceMs)},
+		{"faststart", optionalBool(r.FastStart)},
+		{"streaming", optionalBool(r.Streaming)},
+		{"overwrite_policy", strings.ToLower(r.OverwritePolicy)},
+	} {
+		if opt.value != "" {
+			h.Write([]byte(opt.name + "=" + opt.value))
+			h.Write([]byte{0})
+		}
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+func optionalBool(b *bool) string {
+	if b == nil {
+		return ""
+	}
+	return strconv.FormatBool(*b)
+}
+
+func optionalInt(n *int64) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.FormatInt(*n, 10)
+}
+
+// replayCompleted re-emits the stored result when the job already
+// completed, reporting true if the message needs no further work. refresh
+// updates what goes stale in storage, like the download link.
+func (s *Service) replayCompleted(ctx context.Context, key string, refresh func(*MergeResult)) (bool, error) {
+	return replayStored(ctx, s, key, refresh, s.emitResult)
+}
+
+// replayCompletedJob is replayCompleted for the single-input jobs.
+func (s *Service) replayCompletedJob(ctx context.Context, key string, refresh func(*JobResult)) (bool, error) {
+	return replayStored(ctx, s, key, refresh, s.emitJobResult)
+}
+
+func replayStored[R any](ctx context.Context, s *Service, key string, refresh func(*R), emit func(context.Context, R) error) (bool, error) {
+	if s.jobs == nil {
+		return false, nil
+	}
+	rec, ok, err := s.jobs.Get(ctx, key)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	var res R
+	if err := json.Unmarshal(rec.Result, &res); err != nil {
+		return false, fmt.Errorf("decode stored result: %w", err)
+	}
+
+	refresh(&res)
+
+	logger.Infof("job already completed key=%s at=%s; re-emitting result", key, rec.CompletedAt.Format(time.RFC3339))
+	if err := emit(ctx, res); err != nil {
 		logger.Warnf("emit result failed: %v", err)
 	}
+	return true, nil
+}
 
-	logger.Infof("merge completed: s3://%s/%s (duration=%.2fs)", outBucket, outKey, si.Duration)
-	return nil
+func (s *Service) recordCompleted(ctx context.Context, key string, res MergeResult) {
+	res.DownloadLink = DownloadLink{} // expires; replays sign a fresh one
+	s.storeResult(ctx, key, res)
+}
 
+func (s *Service) recordCompletedJob(ctx context.Context, key string, res JobResult) {
+	res.DownloadLink = DownloadLink{}
+	s.storeResult(ctx, key, res)
+}
+
+func (s *Service) storeResult(ctx context.Context, key string, res any) {
+	if s.jobs == nil {
+		return
+	}
+	val, _ := json.Marshal(res)
+	rec := JobRecord{Key: key, Result: val, CompletedAt: time.Now().UTC()}
+	if err := s.jobs.Put(ctx, rec); err != nil {
+		logger.Warnf("job store put failed key=%s: %v", key, err)
+	}
 }
 
 func (s *Service) emitResult(ctx context.Context, res MergeResult) error {
+	return s.publishResult(ctx, []byte(res.VideoID), res)
+}
+
+func (s *Service) emitJobResult(ctx context.Context, res JobResult) error {
+	return s.publishResult(ctx, []byte(res.MediaID), res)
+}
+
+func (s *Service) publishResult(ctx context.Context, key []byte, res any) error {
 	if s.resultWriter == nil {
 		return nil // No output topic configured; it's OK to skip emitting
 	}
 
 	val, _ := json.Marshal(res)
-	key := []byte(res.VideoID)
 	return s.resultWriter.WriteMessages(ctx, kafka.Message{
 		Key:   key,
 		Value: val,
@@ -181,15 +615,48 @@ func (s *Service) emitResult(ctx context.Context, res MergeResult) error {
 
 }
 
-func deriveOutputKey(videoKey string) string {
+// s3Client returns the shared client for the configured store (endpoint,
+// credentials, transfer settings) in the given region.
+func (s *Service) s3Client(ctx context.Context, region string) (*s3x.Client, error) {
+	return s.s3.Get(ctx, s3x.ConfigFrom(s.cfg, region))
+}
+
+// deadLetter publishes a message that exhausted its retries. It reports
+// false when no DLQ is configured or the publish failed.
+func (s *Service) deadLetter(ctx context.Context, msg kafka.Message, cause error, attempts int) bool {
+	if s.dlqWriter == nil {
+		return false
+	}
+
+	var err error
+	for i := 1; i <= 3; i++ {
+		if err = s.dlqWriter.Publish(ctx, msg, cause, attempts); err == nil {
+			return true
+		}
+		logger.Warnf("dlq publish failed attempt=%d offset=%d err=%v", i, msg.Offset, err)
+		select {
+		case <-time.After(time.Duration(i) * 300 * time.Millisecond):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return false
+}
+
+func deriveOutputKey(videoKey, ext string) string {
 	if videoKey == "" {
-		return "video_merged.mp4"
+		return "video_merged" + ext
 	}
+	return deriveKey(videoKey, "_merged"+ext)
+}
 
-	dir := filepath.Dir(videoKey)
-	base := filepath.Base(videoKey)
+// deriveKey replaces the extension of key with suffix,
+// e.g. a/b.mov + "_thumb.jpg" -> a/b_thumb.jpg.
+func deriveKey(key, suffix string) string {
+	dir := filepath.Dir(key)
+	base := filepath.Base(key)
 	ext := filepath.Ext(base)
 	name := strings.TrimSuffix(base, ext)
 
-	return filepath.Join(dir, name+"_merged.mp4")
+	return filepath.Join(dir, name+suffix)
 }
@@ -0,0 +1,350 @@
+package consumer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/ffmpegx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
+)
+
+// MediaRequest is the common payload of the single-input operations.
+type MediaRequest struct {
+	MediaKey string `json:"media_id"`
+
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Region string `json:"region"`
+
+	CorrelationID string `json:"correlation_id,omitempty"`
+	OutputBucket  string `json:"output_bucket,omitempty"` // default: Bucket
+	OutputKey     string `json:"output_key,omitempty"`    // default: derived from Key
+
+	// Locations as URIs (s3://, file://, http(s)://), instead of the
+	// bucket/key pairs above
+	URI       string `json:"uri,omitempty"`
+	OutputURI string `json:"output_uri,omitempty"`
+
+	OutputOptions
+}
+
+type TranscodeRequest struct {
+	MediaRequest
+	VideoCodec   string `json:"video_codec,omitempty"` // default: h264; encoder names such as libx264 work too
+	AudioCodec   string `json:"audio_codec,omitempty"` // default: aac
+	VideoBitrate string `json:"video_bitrate,omitempty"`
+	AudioBitrate string `json:"audio_bitrate,omitempty"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	Preset       string `json:"preset,omitempty"`
+	FastStart    *bool  `json:"faststart,omitempty"` // moov at the front; default on
+}
+
+type ThumbnailRequest struct {
+	MediaRequest
+	AtSec float64 `json:"at_sec,omitempty"`
+	Width int     `json:"width,omitempty"`
+}
+
+type ExtractAudioRequest struct {
+	MediaRequest
+	Codec   string `json:"codec,omitempty"` // aac (default), mp3, opus, flac, wav
+	Bitrate string `json:"bitrate,omitempty"`
+}
+
+// JobResult is emitted for every job type other than merge, which keeps
+// its own MergeResult for existing consumers.
+type JobResult struct {
+	Type           string              `json:"type"`
+	Status         string              `json:"status"`
+	MediaID        string              `json:"media_id,omitempty"`
+	OutputBucket   string              `json:"output_bucket,omitempty"`
+	OutputKey      string              `json:"output_key,omitempty"`
+	OutputURI      string              `json:"output_uri,omitempty"`
+	ETag           string              `json:"etag,omitempty"`
+	ChecksumSHA256 string              `json:"checksum_sha256,omitempty"`
+	SizeBytes      int64               `json:"size_bytes,omitempty"`
+	DurationSec    float64             `json:"duration_sec,omitempty"`
+	Probe          *ffmpegx.StreamInfo `json:"probe,omitempty"`
+	CorrelationID  string              `json:"correlation_id,omitempty"`
+	Error          string              `json:"err,omitempty"`
+	ErrorCategory  string              `json:"error_category,omitempty"`
+	FailedStage    string              `json:"failed_stage,omitempty"`
+	Attempts       int                 `json:"attempts,omitempty"`
+	Permanent      bool                `json:"permanent,omitempty"`
+	Violations     []FieldError        `json:"violations,omitempty"`
+
+	DownloadLink
+}
+
+func (s *Service) registerBuiltins() {
+	s.Register(JobMerge, 1, JobHandlerFunc(s.handleMerge))
+	s.Register(JobTranscode, 1, JobHandlerFunc(s.handleTranscode))
+	s.Register(JobThumbnail, 1, JobHandlerFunc(s.handleThumbnail))
+	s.Register(JobExtractAudio, 1, JobHandlerFunc(s.handleExtractAudio))
+	s.Register(JobProbe, 1, JobHandlerFunc(s.handleProbe))
+}
+
+func (s *Service) handleTranscode(ctx context.Context, payload json.RawMessage) error {
+	var req TranscodeRequest
+	if err := decodePayload(payload, &req); err != nil {
+		return err
+	}
+	job := mediaJob{typ: JobTranscode, req: req.MediaRequest, options: req, outSuffix: "_transcoded.mp4", contentType: "video/mp4"}
+	job.check = func(v *validator) { v.transcodeOptions(req) }
+	return s.runMediaJob(ctx, job, func(ctx context.Context, inPath, outPath string) error {
+		report, stop := s.progressReporter(ctx, firstNonEmpty(req.CorrelationID, req.MediaKey, req.Key), ProgressEvent{
+			Type:          JobTranscode,
+			MediaID:       req.MediaKey,
+			CorrelationID: req.CorrelationID,
+		})
+		defer stop()
+		return ffmpegx.Transcode(ctx, inPath, outPath, ffmpegx.TranscodeOptions{
+			VideoCodec:   req.VideoCodec,
+			AudioCodec:   req.AudioCodec,
+			VideoBitrate: req.VideoBitrate,
+			AudioBitrate: req.AudioBitrate,
+			Width:        req.Width,
+			Height:       req.Height,
+			Preset:       req.Preset,
+			FastStart:    req.FastStart == nil || *req.FastStart,
+			OnProgress:   report,
+		})
+	})
+}
+
+func (s *Service) handleThumbnail(ctx context.Context, payload json.RawMessage) error {
+	var req ThumbnailRequest
+	if err := decodePayload(payload, &req); err != nil {
+		return err
+	}
+	job := mediaJob{typ: JobThumbnail, req: req.MediaRequest, options: req, outSuffix: "_thumb.jpg", contentType: "image/jpeg"}
+	job.check = func(v *validator) { v.thumbnailOptions(req) }
+	return s.runMediaJob(ctx, job, func(ctx context.Context, inPath, outPath string) error {
+		return ffmpegx.Thumbnail(ctx, inPath, outPath, req.AtSec, req.Width)
+	})
+}
+
+func (s *Service) handleExtractAudio(ctx context.Context, payload json.RawMessage) error {
+	var req ExtractAudioRequest
+	if err := decodePayload(payload, &req); err != nil {
+		return err
+	}
+	format, err := ffmpegx.LookupAudioFormat(req.Codec)
+	if err != nil {
+		return atStage(StageValidate, retryx.Permanent(err))
+	}
+	job := mediaJob{typ: JobExtractAudio, req: req.MediaRequest, options: req, outSuffix: "_audio" + format.Ext, contentType: format.ContentType}
+	return s.runMediaJob(ctx, job, func(ctx context.Context, inPath, outPath string) error {
+		return ffmpegx.ExtractAudio(ctx, inPath, outPath, req.Codec, req.Bitrate)
+	})
+}
+
+func (s *Service) handleProbe(ctx context.Context, payload json.RawMessage) error {
+	var req MediaRequest
+	if err := decodePayload(payload, &req); err != nil {
+		return err
+	}
+
+	job := mediaJob{typ: JobProbe, req: req, options: req}
+	return s.withInput(ctx, job, func(jobDir, inPath string) (JobResult, error) {
+		si, err := ffmpegx.Probe(ctx, inPath)
+		if err != nil {
+			return JobResult{}, err
+		}
+		logger.Infof("probe completed: %s (format=%s duration=%.2fs)", req.inputLocation(), si.Format, si.Duration)
+		return JobResult{
+			Type:          JobProbe,
+			Status:        "probed",
+			MediaID:       req.MediaKey,
+			DurationSec:   si.Duration,
+			Probe:         &si,
+			CorrelationID: req.CorrelationID,
+		}, nil
+	})
+}
+
+// mediaJob is a single-input job as withInput runs it.
+type mediaJob struct {
+	typ     string
+	req     MediaRequest
+	options any                // the whole typed request; it is hashed into the job key
+	check   func(v *validator) // validates the job's own options; may be nil
+
+	outSuffix   string // names the output; "" for jobs that write nothing
+	contentType string
+}
+
+// key identifies the job for deduplication, like MergeRequest.JobKey: the
+// correlation ID when set, otherwise a hash of the whole request.
+func (j mediaJob) key() string {
+	if j.req.CorrelationID != "" {
+		return j.typ + ":cid:" + j.req.CorrelationID
+	}
+	raw, _ := json.Marshal(j.options)
+	sum := sha256.Sum256(append([]byte(j.typ+"\x00"), raw...))
+	return j.typ + ":sha256:" + hex.EncodeToString(sum[:])
+}
+
+// runMediaJob downloads the input, runs op into a local output file and
+// uploads it, then emits a JobResult.
+func (s *Service) runMediaJob(ctx context.Context, job mediaJob, op func(ctx context.Context, inPath, outPath string) error) error {
+	req := job.req
+	outLoc := req.outputLocation(job.outSuffix)
+
+	return s.withInput(ctx, job, func(jobDir, inPath string) (JobResult, error) {
+		outPath := filepath.Join(jobDir, "out"+filepath.Ext(job.outSuffix))
+
+		logger.Infof("%s -> %s", job.typ, outPath)
+		if err := op(ctx, inPath, outPath); err != nil {
+			return JobResult{}, atStage(StageProcess, err)
+		}
+
+		si, _ := ffmpegx.Probe(ctx, outPath)
+
+		outStore, err := s.backend(ctx, outLoc, req.Region)
+		if err != nil {
+			return JobResult{}, atStage(StagePrepare, err)
+		}
+		logger.Infof("uploading %s", outLoc)
+		trace := s.trace(job.typ, req.CorrelationID, si.Duration)
+		trace["media-id"] = req.MediaKey
+		trace["source"] = req.inputLocation().String()
+		opts := s.uploadOptions(job.contentType, req.OutputOptions, trace)
+		up, skipped, err := uploadOutput(ctx, outStore, outLoc, outPath, s.overwritePolicy(req.OverwritePolicy), opts)
+		if err != nil {
+			return JobResult{}, atStage(StageUpload, fmt.Errorf("upload %s: %w", job.typ, err))
+		}
+
+		status := "completed"
+		if skipped {
+			status = StatusSkippedExists
+		}
+		logger.Infof("%s %s: %s", job.typ, status, outLoc)
+		res := JobResult{
+			Type:           job.typ,
+			Status:         status,
+			MediaID:        req.MediaKey,
+			OutputURI:      outLoc.String(),
+			ETag:           up.ETag,
+			ChecksumSHA256: up.ChecksumSHA256,
+			SizeBytes:      up.Size,
+			DurationSec:    si.Duration,
+			CorrelationID:  req.CorrelationID,
+		}
+		if outLoc.Scheme == storage.SchemeS3 {
+			res.OutputBucket, res.OutputKey = outLoc.Bucket, outLoc.Key
+		}
+		return res, nil
+	})
+}
+
+// withInput downloads the request's input into the job's work dir, hands it
+// to fn and emits the result fn returns. Inputs and outputs are read and
+// written through the storage backend for their location, as for merges.
+//
+// As with merges, a job that already completed replays its stored result,
+// and the work dir stays the same across retries so a partial download is
+// resumed. The output is checked against the bucket policy before any
+// download.
+func (s *Service) withInput(ctx context.Context, job mediaJob, fn func(jobDir, inPath string) (JobResult, error)) (err error) {
+	req := job.req
+	if req.Region == "" {
+		req.Region = s.cfg.Region
+	}
+	v := s.validator()
+	req.validate(&v, s.cfg.AllowedRegions)
+	if job.check != nil {
+		job.check(&v)
+	}
+	if err := v.err(); err != nil {
+		return atStage(StageValidate, err)
+	}
+
+	inLoc, outLoc := req.inputLocation(), req.outputLocation(job.outSuffix)
+	var outputs []location
+	if job.outSuffix != "" {
+		outputs = append(outputs, policyLocation(fieldFor("output_bucket", "output_uri", req.OutputURI), outLoc))
+	}
+	if err := s.checkPolicy(ctx, []location{
+		policyLocation(fieldFor("bucket", "uri", req.URI), inLoc),
+	}, outputs); err != nil {
+		return err
+	}
+
+	refreshLink := func(res *JobResult) {
+		if job.outSuffix != "" {
+			res.DownloadLink = s.downloadLink(ctx, outLoc, req.Region, s.presignTTL(req.PresignTTL))
+		}
+	}
+
+	jobKey := job.key()
+	jobDir, release, err := s.acquireWorkDir(ctx, job.typ, jobKey)
+	if err != nil {
+		return atStage(StagePrepare, err)
+	}
+	defer func() { release(err) }()
+
+	if done, err := s.replayCompletedJob(ctx, jobKey, refreshLink); err != nil {
+		logger.Warnf("job store lookup failed key=%s: %v", jobKey, err)
+	} else if done {
+		return nil
+	}
+
+	inPath := filepath.Join(jobDir, "in"+inLoc.Ext())
+	if err := s.fetch(ctx, inLoc, req.Region, inPath); err != nil {
+		return fmt.Errorf("download input: %w", err)
+	}
+
+	res, err := fn(jobDir, inPath)
+	if err != nil {
+		return err
+	}
+	refreshLink(&res)
+	if err := s.emitJobResult(ctx, res); err != nil {
+		logger.Warnf("emit result failed: %v", err)
+	}
+	s.recordCompletedJob(ctx, jobKey, res)
+	return nil
+}
+
+func (r MediaRequest) inputLocation() storage.Location {
+	return uriOrS3(r.URI, r.Bucket, r.Key)
+}
+
+// outputLocation derives the output location when the request leaves it
+// out: next to the input, in the same bucket or directory. An input served
+// over HTTP needs an explicit output (validate checks this).
+func (r MediaRequest) outputLocation(suffix string) storage.Location {
+	if r.OutputURI != "" {
+		loc, _ := storage.Parse(r.OutputURI)
+		return loc
+	}
+	in := r.inputLocation()
+	if in.Scheme == storage.SchemeFile && r.OutputBucket == "" {
+		return storage.Location{Scheme: storage.SchemeFile, Key: deriveKey(in.Key, suffix)}
+	}
+
+	bucket, key := r.OutputBucket, r.OutputKey
+	if bucket == "" {
+		bucket = in.Bucket
+	}
+	if key == "" {
+		key = deriveKey(strings.TrimPrefix(in.Path(), "/"), suffix)
+	}
+	return storage.S3(bucket, key)
+}
+
+func decodePayload(payload json.RawMessage, v any) error {
+	if err := json.Unmarshal(payload, v); err != nil {
+		return atStage(StageDecode, retryx.Permanent(fmt.Errorf("parse job payload: %w", err)))
+	}
+	return nil
+}
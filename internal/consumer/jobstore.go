@@ -0,0 +1,130 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// JobRecord is what the dedup store remembers about a completed job.
+type JobRecord struct {
+	Key         string          `json:"key"`
+	Result      json.RawMessage `json:"result"`
+	CompletedAt time.Time       `json:"completed_at"`
+}
+
+// JobStore remembers completed jobs so redelivered messages are not merged
+// and uploaded a second time.
+type JobStore interface {
+	// Get returns the record for key; ok is false if the job is unknown or
+	// its record has expired.
+	Get(ctx context.Context, key string) (rec JobRecord, ok bool, err error)
+	Put(ctx context.Context, rec JobRecord) error
+	Close() error
+}
+
+var jobsBucket = []byte("jobs")
+
+// boltJobStore is a JobStore backed by a local bbolt file.
+type boltJobStore struct {
+	db  *bolt.DB
+	ttl time.Duration
+}
+
+// OpenBoltJobStore opens (or creates) the store at path. Records older than
+// ttl are treated as unknown and pruned on open; ttl <= 0 keeps them forever.
+func OpenBoltJobStore(path string, ttl time.Duration) (JobStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("job store dir: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open job store %s: %w", path, err)
+	}
+
+	s := &boltJobStore{db: db, ttl: ttl}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init job store: %w", err)
+	}
+
+	if err := s.prune(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("prune job store: %w", err)
+	}
+	return s, nil
+}
+
+func (s *boltJobStore) Get(_ context.Context, key string) (JobRecord, bool, error) {
+	var rec JobRecord
+	var found bool
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(jobsBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return fmt.Errorf("decode job %q: %w", key, err)
+		}
+		found = !s.expired(rec)
+		return nil
+	})
+	return rec, found, err
+}
+
+func (s *boltJobStore) Put(_ context.Context, rec JobRecord) error {
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode job %q: %w", rec.Key, err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(rec.Key), val)
+	})
+}
+
+func (s *boltJobStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *boltJobStore) expired(rec JobRecord) bool {
+	return s.ttl > 0 && time.Since(rec.CompletedAt) > s.ttl
+}
+
+func (s *boltJobStore) prune() error {
+	if s.ttl <= 0 {
+		return nil
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(jobsBucket)
+
+		// Deleting while iterating a bbolt cursor skips entries, so collect first.
+		var stale [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			var rec JobRecord
+			if err := json.Unmarshal(v, &rec); err != nil || s.expired(rec) {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,111 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMergeRequestJobKey(t *testing.T) {
+	base := MergeRequest{
+		VideoBucket: "in", VideoKey: "v.mp4",
+		AudioBucket: "in", AudioKey: "a.m4a",
+		Region: "us-east-1",
+	}
+	on := true
+	tolerance := int64(500)
+	tests := []struct {
+		name   string
+		change func(r *MergeRequest)
+		same   bool
+	}{
+		{"identical", func(r *MergeRequest) {}, true},
+		{"media id is not an output setting", func(r *MergeRequest) { r.MediaKey = "m1" }, true},
+		{"default format", func(r *MergeRequest) { r.OutputFormat = "MP4" }, true},
+		{"video key", func(r *MergeRequest) { r.VideoKey = "v2.mp4" }, false},
+		{"output key", func(r *MergeRequest) { r.OutputKey = "out.mp4" }, false},
+		{"format", func(r *MergeRequest) { r.OutputFormat = "mkv" }, false},
+		{"uri", func(r *MergeRequest) { r.OutputURI = "s3://out/o.mp4" }, false},
+		{"tracks", func(r *MergeRequest) {
+			r.AudioTracks = []AudioTrack{{AudioBucket: "in", AudioKey: "a.m4a", Language: "en"}}
+		}, false},
+		{"sync", func(r *MergeRequest) { r.AudioOffsetMs = 40 }, false},
+		{"video codec", func(r *MergeRequest) { r.VideoCodec = "hevc" }, false},
+		{"audio bitrate", func(r *MergeRequest) { r.AudioBitrate = "128k" }, false},
+		{"tolerance", func(r *MergeRequest) { r.MismatchToleranceMs = &tolerance }, false},
+		{"faststart", func(r *MergeRequest) { r.FastStart = &on }, false},
+		{"streaming", func(r *MergeRequest) { r.Streaming = &on }, false},
+		{"overwrite policy", func(r *MergeRequest) { r.OverwritePolicy = OverwriteNever }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := base
+			tt.change(&r)
+			if got := r.JobKey() == base.JobKey(); got != tt.same {
+				t.Errorf("same key = %v, want %v", got, tt.same)
+			}
+		})
+	}
+
+	withCID := base
+	withCID.CorrelationID = "c-1"
+	if got := withCID.JobKey(); got != "cid:c-1" {
+		t.Errorf("JobKey with correlation id = %q", got)
+	}
+}
+
+func TestBoltJobStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "jobs", "jobs.db")
+	store, err := OpenBoltJobStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []JobRecord{
+		{Key: "fresh", Result: json.RawMessage(`{"status":"merged"}`), CompletedAt: time.Now()},
+		{Key: "stale", Result: json.RawMessage(`{}`), CompletedAt: time.Now().Add(-2 * time.Hour)},
+	}
+	for _, rec := range records {
+		if err := store.Put(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(store JobStore) {
+		t.Helper()
+		tests := []struct {
+			key    string
+			wantOK bool
+		}{
+			{"fresh", true},
+			{"stale", false},
+			{"unknown", false},
+		}
+		for _, tt := range tests {
+			rec, ok, err := store.Get(ctx, tt.key)
+			if err != nil {
+				t.Fatalf("Get(%q): %v", tt.key, err)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("Get(%q) ok = %v, want %v", tt.key, ok, tt.wantOK)
+			}
+			if ok && string(rec.Result) != `{"status":"merged"}` {
+				t.Errorf("Get(%q) result = %s", tt.key, rec.Result)
+			}
+		}
+	}
+	check(store)
+
+	// Reopening prunes the stale record and keeps the fresh one.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = OpenBoltJobStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	check(store)
+}
@@ -0,0 +1,65 @@
+package consumer
+
+import (
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// offsetTracker keeps the in-flight offsets of every partition in fetch
+// order so that a commit never moves past a message that is still being
+// processed. Offset X is only committed once every offset below it is done.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int][]*pendingOffset
+}
+
+type pendingOffset struct {
+	msg  kafka.Message
+	done bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[int][]*pendingOffset)}
+}
+
+// track registers a freshly fetched message. Offsets must be tracked in the
+// order they were fetched.
+func (t *offsetTracker) track(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending := t.partitions[msg.Partition]
+	if n := len(pending); n > 0 && msg.Offset <= pending[n-1].msg.Offset {
+		// The partition was rewound (rebalance or reassignment), so whatever
+		// we were waiting on will be redelivered; start over from here.
+		pending = nil
+	}
+	t.partitions[msg.Partition] = append(pending, &pendingOffset{msg: msg})
+}
+
+// complete marks msg as processed and returns the highest message of its
+// partition that is now safe to commit, if any.
+func (t *offsetTracker) complete(msg kafka.Message) (kafka.Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending := t.partitions[msg.Partition]
+	for _, p := range pending {
+		if p.msg.Offset == msg.Offset {
+			p.done = true
+			break
+		}
+	}
+
+	var (
+		commit kafka.Message
+		ok     bool
+	)
+	for len(pending) > 0 && pending[0].done {
+		commit, ok = pending[0].msg, true
+		pending = pending[1:]
+	}
+	t.partitions[msg.Partition] = pending
+	return commit, ok
+}
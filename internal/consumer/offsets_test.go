@@ -0,0 +1,72 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	msg := func(partition int, offset int64) kafka.Message {
+		return kafka.Message{Partition: partition, Offset: offset}
+	}
+	type step struct {
+		track  *kafka.Message // tracked before completing done
+		done   kafka.Message
+		commit int64 // -1 for nothing to commit
+	}
+	tests := []struct {
+		name    string
+		fetched []kafka.Message
+		steps   []step
+	}{
+		{
+			name:    "in order",
+			fetched: []kafka.Message{msg(0, 1), msg(0, 2)},
+			steps:   []step{{done: msg(0, 1), commit: 1}, {done: msg(0, 2), commit: 2}},
+		},
+		{
+			name:    "out of order waits for the lowest",
+			fetched: []kafka.Message{msg(0, 1), msg(0, 2), msg(0, 3)},
+			steps:   []step{{done: msg(0, 3), commit: -1}, {done: msg(0, 2), commit: -1}, {done: msg(0, 1), commit: 3}},
+		},
+		{
+			name:    "partitions are independent",
+			fetched: []kafka.Message{msg(0, 1), msg(1, 7)},
+			steps:   []step{{done: msg(1, 7), commit: 7}, {done: msg(0, 1), commit: 1}},
+		},
+		{
+			name:    "rewind drops what was pending",
+			fetched: []kafka.Message{msg(0, 5), msg(0, 6)},
+			steps: []step{
+				{track: &kafka.Message{Partition: 0, Offset: 3}, done: msg(0, 3), commit: 3},
+				{done: msg(0, 5), commit: -1},
+			},
+		},
+		{
+			name:    "unknown offset",
+			fetched: []kafka.Message{msg(0, 1)},
+			steps:   []step{{done: msg(0, 9), commit: -1}, {done: msg(0, 1), commit: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newOffsetTracker()
+			for _, m := range tt.fetched {
+				tr.track(m)
+			}
+			for i, s := range tt.steps {
+				if s.track != nil {
+					tr.track(*s.track)
+				}
+				got, ok := tr.complete(s.done)
+				switch {
+				case s.commit < 0 && ok:
+					t.Errorf("step %d: committed offset %d, want nothing", i, got.Offset)
+				case s.commit >= 0 && (!ok || got.Offset != s.commit):
+					t.Errorf("step %d: commit = %d, %v; want %d", i, got.Offset, ok, s.commit)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package consumer
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/s3x"
+)
+
+// OutputOptions are the per-request settings for the uploaded object. Unset
+// fields fall back to the OUTPUT_* defaults.
+type OutputOptions struct {
+	OverwritePolicy string            `json:"overwrite_policy,omitempty"` // default: OUTPUT_OVERWRITE_POLICY
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	StorageClass    string            `json:"storage_class,omitempty"`
+	SSE             string            `json:"sse,omitempty"` // AES256 or aws:kms
+	SSEKMSKeyID     string            `json:"sse_kms_key_id,omitempty"`
+	CacheControl    string            `json:"cache_control,omitempty"`
+	PresignTTL      string            `json:"presign_ttl,omitempty"` // e.g. "1h"; default: PRESIGN_TTL
+}
+
+// Tag keys reserved for tracing an object back to its job. S3 allows 10
+// tags per object, so requests get what is left.
+var traceTagKeys = []string{"job", "media-id", "video-id", "correlation-id"}
+
+const maxRequestTags = 10 - 4
+
+func (v *validator) outputOptions(o OutputOptions) {
+	v.overwritePolicy(o.OverwritePolicy)
+	v.presignTTL(o.PresignTTL)
+
+	if len(o.Tags) > maxRequestTags {
+		v.add("tags", fmt.Sprintf("at most %d tags (the rest are reserved)", maxRequestTags))
+	}
+	for k := range o.Tags {
+		if slices.Contains(traceTagKeys, k) {
+			v.add("tags", fmt.Sprintf("tag %q is reserved", k))
+		}
+	}
+	if o.StorageClass != "" && !slices.Contains(types.StorageClass("").Values(), types.StorageClass(o.StorageClass)) {
+		v.add("storage_class", fmt.Sprintf("unknown storage class %q", o.StorageClass))
+	}
+	switch types.ServerSideEncryption(o.SSE) {
+	case "", types.ServerSideEncryptionAes256, types.ServerSideEncryptionAwsKms:
+	default:
+		v.add("sse", "must be AES256 or aws:kms")
+	}
+	if o.SSEKMSKeyID != "" && o.SSE != "" && o.SSE != string(types.ServerSideEncryptionAwsKms) {
+		v.add("sse_kms_key_id", "requires sse aws:kms")
+	}
+}
+
+// uploadOptions layers the service defaults, the request's settings and the
+// job's trace fields, in that order: a request can change how its output is
+// stored but not where it claims to come from.
+func (s *Service) uploadOptions(contentType string, req OutputOptions, trace map[string]string) s3x.UploadOptions {
+	opts := s3x.UploadOptions{
+		ContentType:  contentType,
+		CacheControl: firstNonEmpty(req.CacheControl, s.cfg.OutputCacheControl),
+		StorageClass: firstNonEmpty(req.StorageClass, s.cfg.OutputStorageClass),
+		SSE:          firstNonEmpty(req.SSE, s.cfg.OutputSSE),
+		SSEKMSKeyID:  firstNonEmpty(req.SSEKMSKeyID, s.cfg.OutputSSEKMSKeyID),
+		Metadata:     map[string]string{},
+		Tags:         map[string]string{},
+	}
+	if req.SSEKMSKeyID != "" && req.SSE == "" {
+		opts.SSE = string(types.ServerSideEncryptionAwsKms)
+	}
+
+	maps.Copy(opts.Metadata, s.cfg.OutputMetadata)
+	maps.Copy(opts.Metadata, req.Metadata)
+	maps.Copy(opts.Tags, s.cfg.OutputTags)
+	maps.Copy(opts.Tags, req.Tags)
+
+	for k, v := range trace {
+		if v == "" {
+			continue
+		}
+		opts.Metadata[k] = v
+		if slices.Contains(traceTagKeys, k) {
+			opts.Tags[k] = v
+		}
+	}
+	return opts
+}
+
+// trace returns the fields every output carries back to its job.
+func (s *Service) trace(jobType, correlationID string, durationSec float64) map[string]string {
+	t := map[string]string{
+		"job":            jobType,
+		"correlation-id": correlationID,
+		"ffmpeg-version": s.ffmpegVersion,
+	}
+	if durationSec > 0 {
+		t["duration-sec"] = strconv.FormatFloat(durationSec, 'f', 3, 64)
+	}
+	return t
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package consumer
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestOutputOptionsValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts OutputOptions
+		want []string
+	}{
+		{"zero", OutputOptions{}, nil},
+		{"full", OutputOptions{
+			Metadata: map[string]string{"source": "upload"}, Tags: map[string]string{"team": "media"},
+			StorageClass: "STANDARD_IA", SSE: "aws:kms", SSEKMSKeyID: "key", CacheControl: "max-age=60",
+		}, nil},
+		{"too many tags", OutputOptions{Tags: map[string]string{"a": "", "b": "", "c": "", "d": "", "e": "", "f": "", "g": ""}}, []string{"tags"}},
+		{"reserved tag", OutputOptions{Tags: map[string]string{"job": "transcode"}}, []string{"tags"}},
+		{"unknown storage class", OutputOptions{StorageClass: "COLD"}, []string{"storage_class"}},
+		{"unknown sse", OutputOptions{SSE: "rot13"}, []string{"sse"}},
+		{"kms key with AES256", OutputOptions{SSE: "AES256", SSEKMSKeyID: "key"}, []string{"sse_kms_key_id"}},
+		{"kms key alone", OutputOptions{SSEKMSKeyID: "key"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator
+			v.outputOptions(tt.opts)
+			if got := invalidFields(t, v.err()); !slices.Equal(got, tt.want) {
+				t.Errorf("invalid fields %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadOptionsLayering(t *testing.T) {
+	s := &Service{ffmpegVersion: "7.0"}
+	s.cfg.OutputCacheControl = "max-age=3600"
+	s.cfg.OutputStorageClass = "STANDARD"
+	s.cfg.OutputSSE = "AES256"
+	s.cfg.OutputMetadata = map[string]string{"owner": "media", "team": "default"}
+	s.cfg.OutputTags = map[string]string{"env": "prod"}
+
+	req := OutputOptions{
+		Metadata:     map[string]string{"team": "video", "job": "spoofed"},
+		Tags:         map[string]string{"project": "x"},
+		StorageClass: "STANDARD_IA",
+		SSEKMSKeyID:  "key",
+	}
+	trace := s.trace(JobMerge, "corr-1", 12.5)
+	opts := s.uploadOptions("video/mp4", req, trace)
+
+	if opts.ContentType != "video/mp4" || opts.CacheControl != "max-age=3600" || opts.StorageClass != "STANDARD_IA" {
+		t.Errorf("options %+v", opts)
+	}
+	if opts.SSE != "aws:kms" || opts.SSEKMSKeyID != "key" {
+		t.Errorf("a request KMS key must switch SSE to aws:kms, got %q/%q", opts.SSE, opts.SSEKMSKeyID)
+	}
+	wantMeta := map[string]string{
+		"owner": "media", "team": "video",
+		"job": JobMerge, "correlation-id": "corr-1", "ffmpeg-version": "7.0", "duration-sec": "12.500",
+	}
+	if !maps.Equal(opts.Metadata, wantMeta) {
+		t.Errorf("metadata %v, want %v", opts.Metadata, wantMeta)
+	}
+	wantTags := map[string]string{"env": "prod", "project": "x", "job": JobMerge, "correlation-id": "corr-1"}
+	if !maps.Equal(opts.Tags, wantTags) {
+		t.Errorf("tags %v, want %v", opts.Tags, wantTags)
+	}
+	if s.cfg.OutputMetadata["team"] != "default" {
+		t.Error("uploadOptions modified the service defaults")
+	}
+}
+
+func TestTraceDuration(t *testing.T) {
+	s := &Service{}
+	if _, ok := s.trace(JobMerge, "", 0)["duration-sec"]; ok {
+		t.Error("unknown duration recorded")
+	}
+	opts := s.uploadOptions("", OutputOptions{}, s.trace(JobMerge, "", 0))
+	if _, ok := opts.Metadata["correlation-id"]; ok {
+		t.Error("empty trace field recorded")
+	}
+}
@@ -0,0 +1,98 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
+)
+
+// Overwrite policies, chosen per request with "overwrite_policy" and
+// defaulting to OUTPUT_OVERWRITE_POLICY.
+const (
+	OverwriteAlways      = "always"                // replace whatever is there
+	OverwriteNever       = "never"                 // keep an existing object
+	OverwriteIfDifferent = "if-different-checksum" // replace only different content
+)
+
+var overwritePolicies = []string{OverwriteAlways, OverwriteNever, OverwriteIfDifferent}
+
+// StatusSkippedExists is reported instead of the completed status when the
+// output key already held an object that the policy says to keep.
+const StatusSkippedExists = "skipped_exists"
+
+func (v *validator) overwritePolicy(policy string) {
+	if policy != "" && !slices.Contains(overwritePolicies, policy) {
+		v.add("overwrite_policy", fmt.Sprintf("must be one of %v", overwritePolicies))
+	}
+}
+
+func (s *Service) overwritePolicy(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	return s.cfg.OutputOverwritePolicy
+}
+
+// uploadOutput stores a job's output under the overwrite policy. The writes
+// are conditional, so two deliveries racing for the same key cannot both
+// win. When the existing object is kept, skipped is true and res describes
+// that object.
+func uploadOutput(ctx context.Context, b storage.Backend, loc storage.Location, path, policy string, opts storage.PutOptions) (res storage.PutResult, skipped bool, err error) {
+	switch policy {
+	case OverwriteNever:
+		opts.Condition = storage.Condition{IfNoneMatch: true}
+		res, err = b.Put(ctx, loc, path, opts)
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			logger.Infof("%s already exists; keeping it (overwrite_policy=%s)", loc, policy)
+			return existingOutput(ctx, b, loc)
+		}
+		return res, false, err
+
+	case OverwriteIfDifferent:
+		info, err := b.Stat(ctx, loc)
+		found := !errors.Is(err, storage.ErrNotFound)
+		if err != nil && found {
+			return res, false, err
+		}
+		opts.Condition = storage.Condition{IfNoneMatch: true}
+		if found {
+			same, err := storage.SameContent(ctx, b, loc, info, path)
+			if err != nil {
+				return res, false, err
+			}
+			if same {
+				logger.Infof("%s already has this content; skipping upload", loc)
+				return putResultFrom(info), true, nil
+			}
+			opts.Condition = storage.Condition{IfMatch: info.ETag}
+		}
+		res, err = b.Put(ctx, loc, path, opts)
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			// Someone wrote the key between our check and our write; the
+			// next attempt compares against what is there now.
+			return res, false, retryx.Transient(fmt.Errorf("%s changed during upload: %v", loc, err))
+		}
+		return res, false, err
+
+	default:
+		res, err = b.Put(ctx, loc, path, opts)
+		return res, false, err
+	}
+}
+
+func existingOutput(ctx context.Context, b storage.Backend, loc storage.Location) (storage.PutResult, bool, error) {
+	info, err := b.Stat(ctx, loc)
+	if err != nil {
+		return storage.PutResult{}, false, err
+	}
+	return putResultFrom(info), true, nil
+}
+
+func putResultFrom(info storage.Info) storage.PutResult {
+	return storage.PutResult{ETag: info.ETag, Size: info.Size, ChecksumSHA256: info.ChecksumSHA256}
+}
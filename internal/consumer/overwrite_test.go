@@ -0,0 +1,183 @@
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
+)
+
+// memBackend is an in-memory storage.Backend that honours conditional
+// writes the way S3 does.
+type memBackend struct {
+	mu      sync.Mutex
+	objects map[storage.Location][]byte
+	etags   map[storage.Location]string
+	puts    []storage.PutOptions
+	version int
+
+	// beforePut runs ahead of each Put, to simulate a concurrent writer.
+	beforePut func(b *memBackend)
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{objects: make(map[storage.Location][]byte), etags: make(map[storage.Location]string)}
+}
+
+// store writes an object unconditionally; the caller holds no lock.
+func (b *memBackend) store(loc storage.Location, data []byte) {
+	b.version++
+	b.objects[loc] = data
+	b.etags[loc] = fmt.Sprintf(`"v%d"`, b.version)
+}
+
+func (b *memBackend) Open(_ context.Context, loc storage.Location) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.objects[loc]
+	if !ok {
+		return nil, retryx.Permanent(storage.ErrNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *memBackend) Stat(_ context.Context, loc storage.Location) (storage.Info, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.objects[loc]
+	if !ok {
+		return storage.Info{}, retryx.Permanent(storage.ErrNotFound)
+	}
+	sum := sha256.Sum256(data)
+	return storage.Info{Size: int64(len(data)), ETag: b.etags[loc], ChecksumSHA256: base64.StdEncoding.EncodeToString(sum[:])}, nil
+}
+
+func (b *memBackend) Put(_ context.Context, loc storage.Location, localPath string, opts storage.PutOptions) (storage.PutResult, error) {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return storage.PutResult{}, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.beforePut != nil {
+		b.beforePut(b)
+	}
+	b.puts = append(b.puts, opts)
+	_, exists := b.objects[loc]
+	if opts.Condition.IfNoneMatch && exists || opts.Condition.IfMatch != "" && opts.Condition.IfMatch != b.etags[loc] {
+		return storage.PutResult{}, retryx.Permanent(storage.ErrPreconditionFailed)
+	}
+	b.store(loc, data)
+	return storage.PutResult{ETag: b.etags[loc], Size: int64(len(data))}, nil
+}
+
+func (b *memBackend) Delete(_ context.Context, loc storage.Location) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, loc)
+	delete(b.etags, loc)
+	return nil
+}
+
+func TestUploadOutput(t *testing.T) {
+	loc := storage.S3("media", "merged/out.mp4")
+	path := filepath.Join(t.TempDir(), "out.mp4")
+	if err := os.WriteFile(path, []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	race := func(b *memBackend) {
+		b.store(loc, []byte("racer"))
+		b.beforePut = nil
+	}
+
+	tests := []struct {
+		name      string
+		policy    string
+		existing  string // "" for no object
+		beforePut func(b *memBackend)
+
+		wantSkipped   bool
+		wantRetryable bool // error expected
+		wantStored    string
+		wantCondition storage.Condition
+		wantPuts      int
+	}{
+		{name: "default writes", policy: "", wantStored: "new", wantPuts: 1},
+		{name: "always replaces", policy: OverwriteAlways, existing: "old", wantStored: "new", wantPuts: 1},
+		{name: "never creates", policy: OverwriteNever, wantStored: "new", wantCondition: storage.Condition{IfNoneMatch: true}, wantPuts: 1},
+		{
+			name: "never keeps an existing object", policy: OverwriteNever, existing: "old",
+			wantSkipped: true, wantStored: "old", wantCondition: storage.Condition{IfNoneMatch: true}, wantPuts: 1,
+		},
+		{
+			name: "never loses a race", policy: OverwriteNever, beforePut: race,
+			wantSkipped: true, wantStored: "racer", wantCondition: storage.Condition{IfNoneMatch: true}, wantPuts: 1,
+		},
+		{name: "if-different creates", policy: OverwriteIfDifferent, wantStored: "new", wantCondition: storage.Condition{IfNoneMatch: true}, wantPuts: 1},
+		{name: "if-different skips the same content", policy: OverwriteIfDifferent, existing: "new", wantSkipped: true, wantStored: "new"},
+		{
+			name: "if-different replaces other content", policy: OverwriteIfDifferent, existing: "old",
+			wantStored: "new", wantCondition: storage.Condition{IfMatch: `"v1"`}, wantPuts: 1,
+		},
+		{
+			name: "if-different retries after a race", policy: OverwriteIfDifferent, existing: "old", beforePut: race,
+			wantRetryable: true, wantStored: "racer", wantCondition: storage.Condition{IfMatch: `"v1"`}, wantPuts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newMemBackend()
+			if tt.existing != "" {
+				b.store(loc, []byte(tt.existing))
+			}
+			b.beforePut = tt.beforePut
+
+			res, skipped, err := uploadOutput(context.Background(), b, loc, path, tt.policy, storage.PutOptions{})
+			if tt.wantRetryable {
+				if err == nil || !retryx.IsRetryable(err) {
+					t.Fatalf("uploadOutput error = %v, want a retryable error", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if skipped != tt.wantSkipped {
+				t.Errorf("skipped = %v, want %v", skipped, tt.wantSkipped)
+			}
+			if got := string(b.objects[loc]); got != tt.wantStored {
+				t.Errorf("stored %q, want %q", got, tt.wantStored)
+			}
+			if err == nil && res.ETag != b.etags[loc] {
+				t.Errorf("result ETag %s, want the stored object's %s", res.ETag, b.etags[loc])
+			}
+			if len(b.puts) != tt.wantPuts {
+				t.Fatalf("%d puts, want %d", len(b.puts), tt.wantPuts)
+			}
+			if tt.wantPuts > 0 && b.puts[0].Condition != tt.wantCondition {
+				t.Errorf("put condition %+v, want %+v", b.puts[0].Condition, tt.wantCondition)
+			}
+		})
+	}
+}
+
+func TestOverwritePolicyValidation(t *testing.T) {
+	for _, policy := range append(slices.Clone(overwritePolicies), "") {
+		var v validator
+		if v.overwritePolicy(policy); len(v.fields) > 0 {
+			t.Errorf("policy %q rejected: %v", policy, v.fields)
+		}
+	}
+	var v validator
+	if v.overwritePolicy("sometimes"); len(v.fields) != 1 || v.fields[0].Field != "overwrite_policy" {
+		t.Errorf("unknown policy: %v", v.fields)
+	}
+}
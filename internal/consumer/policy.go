@@ -0,0 +1,146 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Policy limits where each tenant may read from and write to. It is loaded
+// from POLICY_FILE; without one every bucket the IAM role can reach is
+// allowed, as before.
+//
+// The tenant is never taken from the message body: it is the tenant whose
+// topics include the topic the message was read from, and messages from a
+// topic no tenant claims are rejected.
+//
+//	{
+//	  "tenants": {
+//	    "acme": {"topics": ["acme.media.merge.requests"], "input_buckets": ["acme-uploads"], "output_buckets": ["acme-media"], "output_prefixes": ["acme/"]}
+//	  }
+//	}
+type Policy struct {
+	Tenants map[string]TenantPolicy `json:"tenants"`
+}
+
+// TenantPolicy is the set of rules for one tenant. An empty list means
+// "nothing allowed", so every list has to be spelled out.
+type TenantPolicy struct {
+	Topics         []string `json:"topics"`
+	InputBuckets   []string `json:"input_buckets"`
+	OutputBuckets  []string `json:"output_buckets"`
+	OutputPrefixes []string `json:"output_prefixes"`
+}
+
+// LoadPolicy reads a policy file.
+func LoadPolicy(path string) (*Policy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy %s: %w", path, err)
+	}
+	var p Policy
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", path, err)
+	}
+	owner := map[string]string{}
+	for tenant, rules := range p.Tenants {
+		for _, topic := range rules.Topics {
+			if other, ok := owner[topic]; ok {
+				return nil, fmt.Errorf("parse policy %s: topic %q belongs to both %q and %q", path, topic, min(tenant, other), max(tenant, other))
+			}
+			owner[topic] = tenant
+		}
+	}
+	return &p, nil
+}
+
+// tenantFor returns the tenant that owns topic.
+func (p *Policy) tenantFor(topic string) (string, TenantPolicy, bool) {
+	for tenant, rules := range p.Tenants {
+		if slices.Contains(rules.Topics, topic) {
+			return tenant, rules, true
+		}
+	}
+	return "", TenantPolicy{}, false
+}
+
+type location struct {
+	field  string // request field the bucket came from
+	bucket string
+	key    string
+}
+
+// keyField names the request field the key came from: the bucket's _key
+// sibling, or the URI field itself.
+func (l location) keyField() string {
+	if base, ok := strings.CutSuffix(l.field, "_bucket"); ok {
+		return base + "_key"
+	}
+	if l.field == "bucket" {
+		return "key"
+	}
+	return l.field
+}
+
+// Check enforces the rules of the tenant that owns topic on every input and
+// output. All violations are reported together as a permanent error.
+func (p *Policy) Check(topic string, inputs, outputs []location) error {
+	var v validator
+
+	tenant, rules, ok := p.tenantFor(topic)
+	if !ok {
+		v.add("topic", fmt.Sprintf("%q does not belong to any tenant", topic))
+		return v.err()
+	}
+
+	for _, in := range inputs {
+		if !slices.Contains(rules.InputBuckets, in.bucket) {
+			v.add(in.field, fmt.Sprintf("bucket %q is not an allowed input for tenant %q", in.bucket, tenant))
+		}
+	}
+	for _, out := range outputs {
+		if !slices.Contains(rules.OutputBuckets, out.bucket) {
+			v.add(out.field, fmt.Sprintf("bucket %q is not an allowed output for tenant %q", out.bucket, tenant))
+		}
+		if !hasAnyPrefix(out.key, rules.OutputPrefixes) {
+			v.add(out.keyField(), fmt.Sprintf("key %q must start with one of %s", out.key, strings.Join(rules.OutputPrefixes, ", ")))
+		}
+	}
+
+	return v.err()
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+type sourceTopicKey struct{}
+
+// withSourceTopic records the topic the message being handled was read from.
+func withSourceTopic(ctx context.Context, topic string) context.Context {
+	return context.WithValue(ctx, sourceTopicKey{}, topic)
+}
+
+func sourceTopic(ctx context.Context) string {
+	topic, _ := ctx.Value(sourceTopicKey{}).(string)
+	return topic
+}
+
+// checkPolicy is a no-op when no policy is configured.
+func (s *Service) checkPolicy(ctx context.Context, inputs, outputs []location) error {
+	if s.policy == nil {
+		return nil
+	}
+	if err := s.policy.Check(sourceTopic(ctx), inputs, outputs); err != nil {
+		return atStage(StagePolicy, err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package consumer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := &Policy{
+		Tenants: map[string]TenantPolicy{
+			"main": {Topics: []string{"requests"}, InputBuckets: []string{"uploads"}, OutputBuckets: []string{"media"}, OutputPrefixes: []string{"merged/"}},
+			"acme": {Topics: []string{"acme.requests", "acme.retries"}, InputBuckets: []string{"acme-uploads"}, OutputBuckets: []string{"acme-media"}, OutputPrefixes: []string{"acme/", "shared/acme/"}},
+			"none": {Topics: []string{"none.requests"}},
+		},
+	}
+	in := func(field, bucket string) location { return location{field: field, bucket: bucket, key: "in.mp4"} }
+	out := func(field, bucket, key string) location { return location{field: field, bucket: bucket, key: key} }
+
+	tests := []struct {
+		name    string
+		topic   string
+		inputs  []location
+		outputs []location
+		want    []string
+	}{
+		{
+			name:    "tenant allowed",
+			topic:   "acme.requests",
+			inputs:  []location{in("video_bucket", "acme-uploads")},
+			outputs: []location{out("output_bucket", "acme-media", "shared/acme/o.mp4")},
+		},
+		{
+			name:   "second topic of a tenant",
+			topic:  "acme.retries",
+			inputs: []location{in("video_bucket", "acme-uploads")},
+		},
+		{
+			name:    "main tenant",
+			topic:   "requests",
+			inputs:  []location{in("bucket", "uploads")},
+			outputs: []location{out("output_bucket", "media", "merged/o.mp4")},
+		},
+		{
+			name:    "other tenant's buckets",
+			topic:   "acme.requests",
+			inputs:  []location{in("video_bucket", "uploads"), in("audio_tracks[1].audio_bucket", "acme-uploads")},
+			outputs: []location{out("output_bucket", "media", "acme/o.mp4")},
+			want:    []string{"video_bucket", "output_bucket"},
+		},
+		{
+			name:    "prefix reported on the key field",
+			topic:   "acme.requests",
+			outputs: []location{out("output_bucket", "acme-media", "other/o.mp4")},
+			want:    []string{"output_key"},
+		},
+		{
+			name:    "prefix reported on the uri field",
+			topic:   "acme.requests",
+			outputs: []location{out("output_uri", "acme-media", "other/o.mp4")},
+			want:    []string{"output_uri"},
+		},
+		{
+			name:    "prefix of a single-input job",
+			topic:   "acme.requests",
+			outputs: []location{out("bucket", "acme-media", "other/o.mp4")},
+			want:    []string{"key"},
+		},
+		{
+			name:    "empty rules allow nothing",
+			topic:   "none.requests",
+			inputs:  []location{in("video_bucket", "uploads")},
+			outputs: []location{out("output_bucket", "media", "merged/o.mp4")},
+			want:    []string{"video_bucket", "output_bucket", "output_key"},
+		},
+		{
+			name:    "unmapped topic",
+			topic:   "globex.requests",
+			inputs:  []location{in("video_bucket", "uploads")},
+			outputs: []location{out("output_bucket", "media", "merged/o.mp4")},
+			want:    []string{"topic"},
+		},
+		{
+			name: "no topic",
+			want: []string{"topic"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := invalidFields(t, policy.Check(tt.topic, tt.inputs, tt.outputs)); !slices.Equal(got, tt.want) {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPolicyUsesSourceTopic(t *testing.T) {
+	s := &Service{policy: &Policy{Tenants: map[string]TenantPolicy{
+		"acme": {Topics: []string{"acme.requests"}, InputBuckets: []string{"acme-uploads"}},
+	}}}
+	inputs := []location{{field: "bucket", bucket: "acme-uploads", key: "in.mp4"}}
+
+	if err := s.checkPolicy(withSourceTopic(context.Background(), "acme.requests"), inputs, nil); err != nil {
+		t.Errorf("checkPolicy from the tenant's topic = %v", err)
+	}
+	err := s.checkPolicy(withSourceTopic(context.Background(), "other.requests"), inputs, nil)
+	if failedStage(err) != StagePolicy || !slices.Equal(invalidFields(t, err), []string{"topic"}) {
+		t.Errorf("checkPolicy from an unmapped topic = %v (stage %q)", err, failedStage(err))
+	}
+	if err := (&Service{}).checkPolicy(context.Background(), inputs, nil); err != nil {
+		t.Errorf("checkPolicy without a policy = %v", err)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "policy.json")
+	if err := os.WriteFile(good, []byte(`{"tenants":{"acme":{"topics":["acme.requests"],"input_buckets":["a"],"output_buckets":["b"],"output_prefixes":["p/"]}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"tenants":[]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	shared := filepath.Join(dir, "shared.json")
+	if err := os.WriteFile(shared, []byte(`{"tenants":{"acme":{"topics":["t"]},"globex":{"topics":["t"]}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadPolicy(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Tenants["acme"].OutputPrefixes; !slices.Equal(got, []string{"p/"}) {
+		t.Errorf("LoadPolicy = %+v", p)
+	}
+	if tenant, _, ok := p.tenantFor("acme.requests"); !ok || tenant != "acme" {
+		t.Errorf("tenantFor(acme.requests) = %q, %v", tenant, ok)
+	}
+	for _, path := range []string{bad, shared, filepath.Join(dir, "missing.json")} {
+		if _, err := LoadPolicy(path); err == nil {
+			t.Errorf("LoadPolicy(%s) succeeded", path)
+		}
+	}
+}
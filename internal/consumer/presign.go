@@ -0,0 +1,65 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/s3x"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
+)
+
+// DownloadLink is a presigned URL for the output, so result consumers
+// without S3 credentials can fetch it. It is only filled in when the
+// request sets presign_ttl or PRESIGN_TTL is configured.
+type DownloadLink struct {
+	DownloadURL string `json:"download_url,omitempty"`
+	ExpiresAt   string `json:"download_url_expires_at,omitempty"` // RFC 3339
+}
+
+func (v *validator) presignTTL(ttl string) {
+	if ttl == "" {
+		return
+	}
+	d, err := time.ParseDuration(ttl)
+	switch {
+	case err != nil:
+		v.add("presign_ttl", `not a duration (e.g. "15m", "24h")`)
+	case d <= 0 || d > s3x.MaxPresignTTL:
+		v.add("presign_ttl", fmt.Sprintf("must be between 1s and %s", s3x.MaxPresignTTL))
+	}
+}
+
+func (s *Service) presignTTL(requested string) time.Duration {
+	if requested != "" {
+		d, _ := time.ParseDuration(requested) // validated
+		return d
+	}
+	return s.cfg.PresignTTL
+}
+
+// downloadLink presigns a GET for the output when ttl is set. It is best
+// effort: the output is already stored, so a signing failure is logged and
+// the result goes out without a link.
+func (s *Service) downloadLink(ctx context.Context, loc storage.Location, region string, ttl time.Duration) DownloadLink {
+	if ttl <= 0 {
+		return DownloadLink{}
+	}
+	b, err := s.backend(ctx, loc, region)
+	if err != nil {
+		logger.Warnf("presign %s: %v", loc, err)
+		return DownloadLink{}
+	}
+	p, ok := b.(storage.Presigner)
+	if !ok {
+		logger.Warnf("presign %s: not supported by %s storage", loc, loc.Scheme)
+		return DownloadLink{}
+	}
+	url, expires, err := p.PresignGet(ctx, loc, ttl)
+	if err != nil {
+		logger.Warnf("presign %s: %v", loc, err)
+		return DownloadLink{}
+	}
+	return DownloadLink{DownloadURL: url, ExpiresAt: expires.UTC().Format(time.RFC3339)}
+}
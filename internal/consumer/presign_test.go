@@ -0,0 +1,60 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
+)
+
+func TestPresignTTLValidation(t *testing.T) {
+	tests := []struct {
+		ttl   string
+		valid bool
+	}{
+		{"", true},
+		{"15m", true},
+		{"168h", true},
+		{"169h", false},
+		{"0s", false},
+		{"-1h", false},
+		{"a day", false},
+	}
+	for _, tt := range tests {
+		var v validator
+		v.presignTTL(tt.ttl)
+		if got := len(v.fields) == 0; got != tt.valid {
+			t.Errorf("presign_ttl %q valid = %v, want %v", tt.ttl, got, tt.valid)
+		}
+	}
+}
+
+func TestServicePresignTTL(t *testing.T) {
+	s := &Service{}
+	s.cfg.PresignTTL = time.Hour
+	if got := s.presignTTL(""); got != time.Hour {
+		t.Errorf("default ttl = %s, want 1h", got)
+	}
+	if got := s.presignTTL("15m"); got != 15*time.Minute {
+		t.Errorf("requested ttl = %s, want 15m", got)
+	}
+}
+
+func TestDownloadLinkBestEffort(t *testing.T) {
+	files, err := storage.NewFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{files: files}
+	loc := storage.Location{Scheme: storage.SchemeFile, Key: files.Root + "/out.mp4"}
+	for _, ttl := range []time.Duration{0, time.Hour} {
+		if got := s.downloadLink(context.Background(), loc, "", ttl); got != (DownloadLink{}) {
+			t.Errorf("ttl %s: link %+v for a backend that cannot presign", ttl, got)
+		}
+	}
+	s = &Service{}
+	if got := s.downloadLink(context.Background(), loc, "", time.Hour); got != (DownloadLink{}) {
+		t.Errorf("link %+v for a disabled backend", got)
+	}
+}
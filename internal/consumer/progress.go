@@ -0,0 +1,91 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/ffmpegx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+)
+
+// ProgressEvent is published to KAFKA_TOPIC_PROGRESS while a job runs
+// ffmpeg. Events are keyed by job, so one job's events stay in order.
+type ProgressEvent struct {
+	Type          string    `json:"type"`
+	MediaID       string    `json:"media_id,omitempty"`
+	VideoID       string    `json:"video_id,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	OutTimeSec    float64   `json:"out_time_sec"`
+	Percent       float64   `json:"percent,omitempty"` // 0 when the duration is unknown
+	Speed         float64   `json:"speed,omitempty"`
+	Frame         int64     `json:"frame,omitempty"`
+	BitrateKbps   float64   `json:"bitrate_kbps,omitempty"`
+	SizeBytes     int64     `json:"size_bytes,omitempty"`
+	Done          bool      `json:"done,omitempty"`
+	At            time.Time `json:"at"`
+}
+
+// progressReporter returns an ffmpeg progress callback that publishes
+// events like base, at most one per PROGRESS_INTERVAL plus the final one.
+// Publishing happens on its own goroutine and only the latest event waits,
+// so a slow broker never stalls ffmpeg. stop flushes it; the callback is
+// nil when no progress topic is configured.
+func (s *Service) progressReporter(ctx context.Context, key string, base ProgressEvent) (report ffmpegx.ProgressFunc, stop func()) {
+	if s.progress == nil {
+		return nil, nop
+	}
+
+	pending := make(chan ProgressEvent, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ev := range pending {
+			s.publishProgress(ctx, key, ev)
+		}
+	}()
+
+	var last time.Time
+	report = func(p ffmpegx.Progress) {
+		if !p.Done && time.Since(last) < s.cfg.ProgressInterval {
+			return
+		}
+		last = time.Now()
+
+		ev := base
+		ev.OutTimeSec = p.OutTime.Seconds()
+		ev.Percent = p.Percent
+		ev.Speed = p.Speed
+		ev.Frame = p.Frame
+		ev.BitrateKbps = p.BitrateKbps
+		ev.SizeBytes = p.TotalSize
+		ev.Done = p.Done
+		ev.At = last.UTC()
+
+		// Replace an event the publisher hasn't picked up yet.
+		select {
+		case <-pending:
+		default:
+		}
+		pending <- ev
+	}
+	stop = func() {
+		close(pending)
+		<-done
+	}
+	return report, stop
+}
+
+func (s *Service) publishProgress(ctx context.Context, key string, ev ProgressEvent) {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		logger.Warnf("marshal progress: %v", err)
+		return
+	}
+	pctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := s.progress.WriteMessages(pctx, kafka.Message{Key: []byte(key), Value: b, Time: ev.At}); err != nil {
+		logger.Warnf("publish progress %s: %v", key, err)
+	}
+}
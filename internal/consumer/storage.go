@@ -0,0 +1,59 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
+)
+
+// backend returns the storage for a location. S3 goes through the shared
+// client pool for the request's region; file:// and http(s) have to be
+// enabled in the config.
+func (s *Service) backend(ctx context.Context, loc storage.Location, region string) (storage.Backend, error) {
+	switch loc.Scheme {
+	case storage.SchemeS3:
+		c, err := s.s3Client(ctx, region)
+		if err != nil {
+			return nil, fmt.Errorf("s3 init: %w", err)
+		}
+		return storage.NewS3(c), nil
+	case storage.SchemeFile:
+		if s.files == nil {
+			return nil, retryx.Permanent(fmt.Errorf("%s: file storage is disabled (STORAGE_FILE_ROOT)", loc))
+		}
+		return s.files, nil
+	case storage.SchemeHTTP, storage.SchemeHTTPS:
+		if s.web == nil {
+			return nil, retryx.Permanent(fmt.Errorf("%s: http sources are disabled (STORAGE_HTTP_ALLOWED_HOSTS)", loc))
+		}
+		return s.web, nil
+	default:
+		return nil, retryx.Permanent(fmt.Errorf("%s: unsupported storage scheme", loc))
+	}
+}
+
+// fetch downloads loc into path.
+func (s *Service) fetch(ctx context.Context, loc storage.Location, region, path string) error {
+	b, err := s.backend(ctx, loc, region)
+	if err != nil {
+		return atStage(StagePrepare, err)
+	}
+	logger.Infof("downloading %s", loc)
+	if _, err := storage.DownloadToFile(ctx, b, loc, path); err != nil {
+		return atStage(StageDownload, err)
+	}
+	return nil
+}
+
+// policyLocation maps a location onto the bucket policy: HTTP sources are
+// matched by host and local files as bucket "localhost".
+func policyLocation(field string, loc storage.Location) location {
+	bucket := loc.Bucket
+	if loc.Scheme == storage.SchemeFile {
+		bucket = "localhost"
+	}
+	return location{field: field, bucket: bucket, key: loc.Key}
+}
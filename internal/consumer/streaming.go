@@ -0,0 +1,137 @@
+package consumer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/ffmpegx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
+)
+
+// streamURLTTL bounds how long ffmpeg may keep reading a presigned input.
+const streamURLTTL = time.Hour
+
+func (s *Service) streaming(requested *bool) bool {
+	if requested != nil {
+		return *requested
+	}
+	return s.cfg.MergeStreaming
+}
+
+func nop() {}
+
+// mergeInput prepares loc as an ffmpeg input. When streaming it is read
+// without a local copy if possible; otherwise it is downloaded to path.
+// With seek set it is never piped, since ffmpeg has to probe or rewind it.
+// release must be called once ffmpeg is done with the input.
+func (s *Service) mergeInput(ctx context.Context, loc storage.Location, region, path string, stream, seek bool) (in ffmpegx.Input, release func(), err error) {
+	if stream {
+		in, release, ok, err := s.streamInput(ctx, loc, region, seek)
+		if err != nil || ok {
+			return in, release, err
+		}
+		logger.Infof("%s needs seeking; downloading it instead of streaming", loc)
+	}
+	if err := s.fetch(ctx, loc, region, path); err != nil {
+		return in, nop, err
+	}
+	return ffmpegx.FileInput(path), nop, nil
+}
+
+// streamInput opens loc for ffmpeg without copying it: local files are read
+// in place, objects the backend can presign are fetched by ffmpeg itself
+// (seeking with range requests), and anything else is piped from Open when
+// its container can be read front to back and seek is not set. ok is false
+// when the input has to be downloaded after all.
+func (s *Service) streamInput(ctx context.Context, loc storage.Location, region string, seek bool) (in ffmpegx.Input, release func(), ok bool, err error) {
+	b, err := s.backend(ctx, loc, region)
+	if err != nil {
+		return in, nop, false, atStage(StagePrepare, err)
+	}
+
+	if lp, isLocal := b.(storage.LocalPather); isLocal {
+		path, err := lp.LocalPath(loc)
+		if err != nil {
+			return in, nop, false, atStage(StageDownload, err)
+		}
+		return ffmpegx.FileInput(path), nop, true, nil
+	}
+
+	if p, canSign := b.(storage.Presigner); canSign {
+		// A missing object should fail as a download, not as an ffprobe 404.
+		if _, err := b.Stat(ctx, loc); err != nil {
+			return in, nop, false, atStage(StageDownload, err)
+		}
+		url, _, err := p.PresignGet(ctx, loc, streamURLTTL)
+		if err != nil {
+			return in, nop, false, atStage(StageDownload, err)
+		}
+		logger.Infof("streaming %s", loc)
+		return ffmpegx.Input{URL: url}, nop, true, nil
+	}
+	if seek {
+		return in, nop, false, nil
+	}
+
+	body, err := b.Open(ctx, loc)
+	if err != nil {
+		return in, nop, false, atStage(StageDownload, err)
+	}
+	br := bufio.NewReaderSize(body, ffmpegx.HeaderSize)
+	head, err := br.Peek(ffmpegx.HeaderSize)
+	if err != nil && err != io.EOF {
+		body.Close()
+		return in, nop, false, atStage(StageDownload, retryx.Transient(fmt.Errorf("read %s: %w", loc, err)))
+	}
+	if !ffmpegx.Streamable(head) {
+		body.Close()
+		return in, nop, false, nil
+	}
+	logger.Infof("streaming %s through a pipe", loc)
+	return ffmpegx.Input{Reader: br}, func() { body.Close() }, true, nil
+}
+
+// streamMerged uploads what merge writes while it runs, under the overwrite
+// policy (if-different-checksum needs the finished file and is handled by
+// uploadOutput instead). Errors carry the stage that failed first: when the
+// upload breaks off, ffmpeg only sees a closed pipe, and when ffmpeg fails
+// the upload only sees the error merge returned.
+func streamMerged(ctx context.Context, b storage.Backend, sp storage.StreamPutter, loc storage.Location, policy string, opts storage.PutOptions, merge func(w io.Writer) error) (storage.PutResult, bool, error) {
+	if policy == OverwriteNever {
+		opts.Condition = storage.Condition{IfNoneMatch: true}
+	}
+
+	type upload struct {
+		res storage.PutResult
+		err error
+	}
+	done := make(chan upload, 1)
+	pr, pw := io.Pipe()
+	go func() {
+		res, err := sp.PutStream(ctx, loc, pr, opts)
+		pr.CloseWithError(err) // a stopped upload must not leave ffmpeg blocked
+		done <- upload{res, err}
+	}()
+
+	mergeErr := merge(pw)
+	pw.CloseWithError(mergeErr)
+	up := <-done
+
+	if up.err != nil && (mergeErr == nil || !errors.Is(up.err, mergeErr)) {
+		if policy == OverwriteNever && errors.Is(up.err, storage.ErrPreconditionFailed) {
+			logger.Infof("%s already exists; keeping it (overwrite_policy=%s)", loc, policy)
+			return existingOutput(ctx, b, loc)
+		}
+		return up.res, false, atStage(StageUpload, fmt.Errorf("upload merged: %w", up.err))
+	}
+	if mergeErr != nil {
+		return up.res, false, atStage(StageMerge, mergeErr)
+	}
+	return up.res, false, nil
+}
@@ -0,0 +1,185 @@
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
+)
+
+func (b *memBackend) PutStream(_ context.Context, loc storage.Location, r io.Reader, opts storage.PutOptions) (storage.PutResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return storage.PutResult{}, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.puts = append(b.puts, opts)
+	if _, exists := b.objects[loc]; opts.Condition.IfNoneMatch && exists {
+		return storage.PutResult{}, retryx.Permanent(storage.ErrPreconditionFailed)
+	}
+	b.store(loc, data)
+	return storage.PutResult{ETag: b.etags[loc], Size: int64(len(data))}, nil
+}
+
+// brokenPutter stops reading after the first chunk, like an upload whose
+// connection dropped.
+type brokenPutter struct{ err error }
+
+func (p brokenPutter) PutStream(_ context.Context, _ storage.Location, r io.Reader, _ storage.PutOptions) (storage.PutResult, error) {
+	_, _ = r.Read(make([]byte, 4))
+	return storage.PutResult{}, p.err
+}
+
+func TestStreamMerged(t *testing.T) {
+	loc := storage.S3("media-out", "merged/v.mp4")
+	mergeFailed := retryx.Permanent(errors.New("ffmpeg: invalid data"))
+	uploadFailed := retryx.Transient(errors.New("connection reset"))
+	// Like ffmpeg, writeAll fails with its own error when the pipe closes.
+	writeAll := func(w io.Writer) error {
+		for range 3 {
+			if _, err := w.Write([]byte("chunk")); err != nil {
+				return fmt.Errorf("ffmpeg merge: %w", err)
+			}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name        string
+		existing    bool
+		policy      string
+		broken      error
+		merge       func(w io.Writer) error
+		wantStage   string
+		wantSkipped bool
+		wantData    string
+	}{
+		{"stored", false, OverwriteAlways, nil, writeAll, "", false, "chunkchunkchunk"},
+		{"replaced", true, OverwriteAlways, nil, writeAll, "", false, "chunkchunkchunk"},
+		{"never creates", false, OverwriteNever, nil, writeAll, "", false, "chunkchunkchunk"},
+		{"never keeps existing", true, OverwriteNever, nil, writeAll, "", true, "old"},
+		{"merge fails", false, OverwriteAlways, nil, func(w io.Writer) error {
+			w.Write([]byte("partial"))
+			return mergeFailed
+		}, StageMerge, false, ""},
+		{"upload fails", false, OverwriteAlways, uploadFailed, writeAll, StageUpload, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newMemBackend()
+			if tt.existing {
+				b.store(loc, []byte("old"))
+			}
+			var sp storage.StreamPutter = b
+			if tt.broken != nil {
+				sp = brokenPutter{tt.broken}
+			}
+			res, skipped, err := streamMerged(context.Background(), b, sp, loc, tt.policy, storage.PutOptions{}, tt.merge)
+			if got := failedStage(err); got != tt.wantStage {
+				t.Fatalf("stage %q (err %v), want %q", got, err, tt.wantStage)
+			}
+			if err != nil && retryx.IsRetryable(err) != (tt.broken != nil) {
+				t.Errorf("%v retryable = %v; only the dropped upload is", err, retryx.IsRetryable(err))
+			}
+			if skipped != tt.wantSkipped {
+				t.Errorf("skipped = %v, want %v", skipped, tt.wantSkipped)
+			}
+			if got := string(b.objects[loc]); tt.wantStage == "" && got != tt.wantData {
+				t.Errorf("stored %q, want %q", got, tt.wantData)
+			}
+			if tt.wantStage == "" && res.ETag != b.etags[loc] {
+				t.Errorf("result etag %s, stored %s", res.ETag, b.etags[loc])
+			}
+			if tt.policy == OverwriteNever && len(b.puts) > 0 && !b.puts[0].Condition.IfNoneMatch {
+				t.Error("never policy uploaded without If-None-Match")
+			}
+		})
+	}
+}
+
+func TestStreamInput(t *testing.T) {
+	webm := append([]byte{0x1a, 0x45, 0xdf, 0xa3}, bytes.Repeat([]byte{0}, 64)...)
+	mdatFirst := []byte("\x00\x00\x00\x10mdat01234567\x00\x00\x00\x08moov")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v.webm":
+			w.Write(webm)
+		case "/v.mp4":
+			w.Write(mdatFirst)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	local := filepath.Join(root, "a.m4a")
+	if err := os.WriteFile(local, []byte("audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := storage.NewFile(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{files: files, web: storage.NewHTTP([]string{strings.TrimPrefix(srv.URL, "http://")})}
+
+	tests := []struct {
+		name      string
+		uri       string
+		seek      bool
+		wantOK    bool
+		wantPipe  bool
+		wantPath  string
+		wantStage string
+	}{
+		{"local file in place", "file://" + local, false, true, false, local, ""},
+		{"piped webm", srv.URL + "/v.webm", false, true, true, "", ""},
+		{"webm needing a seek", srv.URL + "/v.webm", true, false, false, "", ""},
+		{"mp4 with moov at the end", srv.URL + "/v.mp4", false, false, false, "", ""},
+		{"missing", srv.URL + "/gone.webm", false, false, false, "", StageDownload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := storage.Parse(tt.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			in, release, ok, err := s.streamInput(context.Background(), loc, "", tt.seek)
+			defer release()
+			if got := failedStage(err); got != tt.wantStage {
+				t.Fatalf("stage %q (err %v), want %q", got, err, tt.wantStage)
+			}
+			if ok != tt.wantOK || (in.Reader != nil) != tt.wantPipe || in.Path != tt.wantPath {
+				t.Fatalf("input %+v ok %v; want ok %v, pipe %v, path %q", in, ok, tt.wantOK, tt.wantPipe, tt.wantPath)
+			}
+			if tt.wantPipe {
+				got, err := io.ReadAll(in.Reader)
+				if err != nil || !bytes.Equal(got, webm) {
+					t.Errorf("piped %d bytes (%v), want the whole object", len(got), err)
+				}
+			}
+		})
+	}
+
+	loc, _ := storage.Parse(srv.URL + "/v.mp4")
+	path := filepath.Join(t.TempDir(), "video_in.mp4")
+	in, release, err := s.mergeInput(context.Background(), loc, "", path, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if got, _ := os.ReadFile(path); in.Path != path || !bytes.Equal(got, mdatFirst) {
+		t.Errorf("an unstreamable input was not downloaded: %+v", in)
+	}
+}
@@ -0,0 +1,87 @@
+package consumer
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/ffmpegx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
+)
+
+// maxAudioTracks bounds audio_tracks; each one is another ffmpeg input.
+const maxAudioTracks = 16
+
+// AudioTrack is one audio input of a merge, located like the single audio
+// fields of MergeRequest.
+type AudioTrack struct {
+	AudioBucket string `json:"audio_bucket,omitempty"`
+	AudioKey    string `json:"audio_key,omitempty"`
+	AudioURI    string `json:"audio_uri,omitempty"`
+	AudioID     string `json:"audio_id,omitempty"`
+
+	Language string `json:"language,omitempty"` // ISO 639 code, e.g. "en" or "eng"
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default,omitempty"`   // at most one; the first track otherwise
+	OffsetMs int64  `json:"offset_ms,omitempty"` // added to audio_offset_ms
+}
+
+func (t AudioTrack) location() storage.Location {
+	return uriOrS3(t.AudioURI, t.AudioBucket, t.AudioKey)
+}
+
+// tracks are the request's audio inputs; the single audio fields make one
+// untagged track.
+func (r MergeRequest) tracks() []AudioTrack {
+	if len(r.AudioTracks) > 0 {
+		return r.AudioTracks
+	}
+	return []AudioTrack{{AudioBucket: r.AudioBucket, AudioKey: r.AudioKey, AudioURI: r.AudioURI, AudioID: r.AudioID}}
+}
+
+// trackField names a track's field in validation errors: "audio_bucket"
+// for the single audio fields, "audio_tracks[1].audio_bucket" otherwise.
+func (r MergeRequest) trackField(i int, field string) string {
+	if len(r.AudioTracks) == 0 {
+		return field
+	}
+	return fmt.Sprintf("audio_tracks[%d].%s", i, field)
+}
+
+func (v *validator) audioTracks(r MergeRequest) {
+	if len(r.AudioTracks) == 0 {
+		v.source("audio", r.AudioURI, r.AudioBucket, r.AudioKey)
+		return
+	}
+	if r.AudioBucket != "" || r.AudioKey != "" || r.AudioURI != "" || r.AudioID != "" {
+		v.add("audio_tracks", "set either audio_tracks or the single audio fields, not both")
+	}
+	if len(r.AudioTracks) > maxAudioTracks {
+		v.add("audio_tracks", fmt.Sprintf("at most %d tracks", maxAudioTracks))
+	}
+	defaults := 0
+	for i, t := range r.AudioTracks {
+		v.source(r.trackField(i, "audio"), t.AudioURI, t.AudioBucket, t.AudioKey)
+		if _, err := ffmpegx.LanguageCode(t.Language); err != nil {
+			v.add(r.trackField(i, "language"), `must be an ISO 639 code such as "en" or "eng"`)
+		}
+		if off := time.Duration(t.OffsetMs) * time.Millisecond; off > maxAudioOffset || off < -maxAudioOffset {
+			v.add(r.trackField(i, "offset_ms"), fmt.Sprintf("must be within ±%d", maxAudioOffset.Milliseconds()))
+		}
+		if t.Default {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		v.add("audio_tracks", "at most one track can be the default")
+	}
+}
+
+// audioInPath is where track i is downloaded; the first keeps the name
+// single-audio merges always used, so their retries still resume.
+func audioInPath(dir string, i int) string {
+	if i == 0 {
+		return filepath.Join(dir, "audio_in.m4a")
+	}
+	return filepath.Join(dir, fmt.Sprintf("audio_in_%d.m4a", i))
+}
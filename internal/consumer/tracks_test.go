@@ -0,0 +1,61 @@
+package consumer
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAudioTracksValidation(t *testing.T) {
+	track := func(key, lang string) AudioTrack {
+		return AudioTrack{AudioBucket: "media-in", AudioKey: key, Language: lang}
+	}
+	many := make([]AudioTrack, maxAudioTracks+1)
+	for i := range many {
+		many[i] = track("a.m4a", "")
+	}
+	tests := []struct {
+		name string
+		req  MergeRequest
+		want []string
+	}{
+		{"single audio", MergeRequest{AudioBucket: "media-in", AudioKey: "a.m4a"}, nil},
+		{"tracks", MergeRequest{AudioTracks: []AudioTrack{track("en.m4a", "en"), track("ja.m4a", "jpn")}}, nil},
+		{"both", MergeRequest{AudioKey: "a.m4a", AudioTracks: []AudioTrack{track("en.m4a", "en")}}, []string{"audio_tracks"}},
+		{"too many", MergeRequest{AudioTracks: many}, []string{"audio_tracks"}},
+		{"bad language", MergeRequest{AudioTracks: []AudioTrack{track("en.m4a", "en"), track("x.m4a", "xx")}}, []string{"audio_tracks[1].language"}},
+		{"bad offset", MergeRequest{AudioTracks: []AudioTrack{{AudioBucket: "media-in", AudioKey: "a.m4a", OffsetMs: -60001}}}, []string{"audio_tracks[0].offset_ms"}},
+		{"two defaults", MergeRequest{AudioTracks: []AudioTrack{
+			{AudioBucket: "media-in", AudioKey: "a.m4a", Default: true},
+			{AudioBucket: "media-in", AudioKey: "b.m4a", Default: true},
+		}}, []string{"audio_tracks"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator
+			v.audioTracks(tt.req)
+			if got := invalidFields(t, v.err()); !slices.Equal(got, tt.want) {
+				t.Errorf("invalid fields %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeRequestTracks(t *testing.T) {
+	single := MergeRequest{AudioBucket: "media-in", AudioKey: "a.m4a", AudioID: "a1"}
+	if got := single.tracks(); len(got) != 1 || got[0].location().String() != "s3://media-in/a.m4a" || got[0].AudioID != "a1" {
+		t.Errorf("single audio tracks %+v", got)
+	}
+	if got := single.trackField(0, "audio_key"); got != "audio_key" {
+		t.Errorf("single audio field %q", got)
+	}
+	multi := MergeRequest{AudioTracks: []AudioTrack{{AudioURI: "s3://media-in/en.m4a"}, {AudioURI: "s3://media-in/es.m4a"}}}
+	if got := multi.tracks(); len(got) != 2 || got[1].location().String() != "s3://media-in/es.m4a" {
+		t.Errorf("tracks %+v", got)
+	}
+	if got := multi.trackField(1, "audio_uri"); got != "audio_tracks[1].audio_uri" {
+		t.Errorf("track field %q", got)
+	}
+	if a, b := audioInPath("/w", 0), audioInPath("/w", 2); a != "/w/audio_in.m4a" || b != "/w/audio_in_2.m4a" {
+		t.Errorf("audio paths %s, %s", a, b)
+	}
+}
@@ -0,0 +1,243 @@
+package consumer
+
+import (
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/ffmpegx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/storage"
+)
+
+// FieldError is one problem with one request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every problem found in a request, so the producer
+// can fix them all at once.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		parts = append(parts, f.Field+": "+f.Message)
+	}
+	return "invalid request: " + strings.Join(parts, "; ")
+}
+
+type validator struct {
+	fields []FieldError
+
+	// anyRegion skips the AWS region shape check, for S3-compatible
+	// endpoints (MinIO, local stacks) whose regions look like anything.
+	anyRegion bool
+}
+
+func (v *validator) add(field, msg string) {
+	v.fields = append(v.fields, FieldError{Field: field, Message: msg})
+}
+
+// err returns the collected problems as a permanent error, or nil.
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return retryx.Permanent(&ValidationError{Fields: v.fields})
+}
+
+var (
+	bucketRe = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	regionRe = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+)
+
+func (v *validator) bucket(field, bucket string) {
+	switch {
+	case bucket == "":
+		v.add(field, "required")
+	case !bucketRe.MatchString(bucket) || strings.Contains(bucket, ".."):
+		v.add(field, "not a valid bucket name")
+	}
+}
+
+func (v *validator) key(field, key string) {
+	switch {
+	case key == "":
+		v.add(field, "required")
+	case len(key) > 1024:
+		v.add(field, "longer than 1024 bytes")
+	case !utf8.ValidString(key):
+		v.add(field, "not valid UTF-8")
+	case strings.HasPrefix(key, "/"):
+		v.add(field, "must not start with '/'")
+	case slices.Contains(strings.Split(key, "/"), ".."):
+		v.add(field, "must not contain '..' segments")
+	case strings.ContainsFunc(key, unicode.IsControl):
+		v.add(field, "must not contain control characters")
+	}
+}
+
+func (v *validator) region(region string, allowed []string) {
+	switch {
+	case region == "":
+		v.add("region", "required (event.Region empty and AWS_REGION not configured)")
+	case !v.anyRegion && !regionRe.MatchString(region):
+		v.add("region", "not a valid AWS region")
+	case len(allowed) > 0 && !slices.Contains(allowed, region):
+		v.add("region", "not in the allowed regions "+strings.Join(allowed, ","))
+	}
+}
+
+// Validate checks the request before anything is downloaded. An empty
+// allowed list accepts any well-formed region.
+func (r MergeRequest) Validate(allowedRegions ...string) error {
+	var v validator
+	r.validate(&v, allowedRegions)
+	return v.err()
+}
+
+func (r MergeRequest) validate(v *validator, allowedRegions []string) {
+	video := v.source("video", r.VideoURI, r.VideoBucket, r.VideoKey)
+	v.audioTracks(r)
+	v.region(r.Region, allowedRegions)
+	v.outputFormat(r.OutputFormat)
+	v.mergeCodecs(r.OutputFormat, r.MergeCodecs)
+	v.mergeSync(r.MergeSync)
+	v.outputOptions(r.OutputOptions)
+
+	if r.OutputURI != "" {
+		if r.OutputBucket != "" || r.OutputKey != "" {
+			v.add("output_uri", "set either the URI or the bucket and key, not both")
+		}
+		if loc, ok := v.uri("output_uri", r.OutputURI); ok && loc.Scheme != storage.SchemeS3 && loc.Scheme != storage.SchemeFile {
+			v.add("output_uri", "must be an s3:// or file:// location")
+		}
+	} else {
+		if r.OutputBucket != "" {
+			v.bucket("output_bucket", r.OutputBucket)
+		}
+		if r.OutputKey != "" {
+			v.key("output_key", r.OutputKey)
+		}
+		switch video.Scheme {
+		case storage.SchemeHTTP, storage.SchemeHTTPS:
+			if r.OutputBucket == "" {
+				v.add("output_uri", "required when the video is read over http(s)")
+			}
+		case storage.SchemeFile:
+			if r.OutputBucket == "" && r.OutputKey != "" {
+				v.add("output_key", "use output_uri for file outputs")
+			}
+		}
+	}
+
+	if len(v.fields) == 0 {
+		out := r.outputLocation()
+		if out == r.videoLocation() {
+			v.add("output_key", "must not overwrite the video input")
+		}
+		for i, t := range r.tracks() {
+			if out == t.location() {
+				v.add("output_key", "must not overwrite the "+ffmpegx.TrackName(i, len(r.tracks()))+" input")
+			}
+		}
+	}
+}
+
+// source checks an input given either as a URI or as a bucket/key pair
+// (name_uri, or name_bucket and name_key; plain uri, bucket and key when
+// name is empty).
+func (v *validator) source(name, uri, bucket, key string) storage.Location {
+	field := func(f string) string {
+		if name == "" {
+			return f
+		}
+		return name + "_" + f
+	}
+	if uri == "" {
+		v.bucket(field("bucket"), bucket)
+		v.key(field("key"), key)
+		return storage.S3(bucket, key)
+	}
+	if bucket != "" || key != "" {
+		v.add(field("uri"), "set either the URI or the bucket and key, not both")
+	}
+	loc, _ := v.uri(field("uri"), uri)
+	return loc
+}
+
+func (v *validator) uri(field, uri string) (storage.Location, bool) {
+	loc, err := storage.Parse(uri)
+	if err != nil {
+		v.add(field, err.Error())
+		return loc, false
+	}
+	if loc.Scheme == storage.SchemeS3 {
+		n := len(v.fields)
+		v.bucket(field, loc.Bucket)
+		v.key(field, loc.Key)
+		return loc, len(v.fields) == n
+	}
+	return loc, true
+}
+
+// Validate checks a single-input job request.
+func (r MediaRequest) Validate(allowedRegions ...string) error {
+	var v validator
+	r.validate(&v, allowedRegions)
+	return v.err()
+}
+
+func (r MediaRequest) validate(v *validator, allowedRegions []string) {
+	in := v.source("", r.URI, r.Bucket, r.Key)
+	v.region(r.Region, allowedRegions)
+	v.outputOptions(r.OutputOptions)
+
+	if r.OutputURI != "" {
+		if r.OutputBucket != "" || r.OutputKey != "" {
+			v.add("output_uri", "set either the URI or the bucket and key, not both")
+		}
+		if loc, ok := v.uri("output_uri", r.OutputURI); ok && loc.Scheme != storage.SchemeS3 && loc.Scheme != storage.SchemeFile {
+			v.add("output_uri", "must be an s3:// or file:// location")
+		}
+	} else {
+		if r.OutputBucket != "" {
+			v.bucket("output_bucket", r.OutputBucket)
+		}
+		if r.OutputKey != "" {
+			v.key("output_key", r.OutputKey)
+		}
+		switch in.Scheme {
+		case storage.SchemeHTTP, storage.SchemeHTTPS:
+			if r.OutputBucket == "" {
+				v.add("output_uri", "required when the input is read over http(s)")
+			}
+		case storage.SchemeFile:
+			if r.OutputBucket == "" && r.OutputKey != "" {
+				v.add("output_key", "use output_uri for file outputs")
+			}
+		}
+	}
+
+	// A derived key always gets the job's suffix, so only an explicit
+	// output can land on the input.
+	if len(v.fields) == 0 && (r.OutputKey != "" || r.OutputURI != "") && r.outputLocation("") == in {
+		v.add("output_key", "must not overwrite the input")
+	}
+}
+
+func (v *validator) thumbnailOptions(r ThumbnailRequest) {
+	if math.IsNaN(r.AtSec) || r.AtSec < 0 {
+		v.add("at_sec", "must be a non-negative number of seconds")
+	}
+	if r.Width < 0 {
+		v.add("width", "must not be negative")
+	}
+}
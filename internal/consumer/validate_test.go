@@ -0,0 +1,183 @@
+package consumer
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// invalidFields returns the fields a validation error names, or nil.
+func invalidFields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) || !retryx.IsPermanent(err) {
+		t.Fatalf("error %v is not a permanent ValidationError", err)
+	}
+	var fields []string
+	for _, f := range verr.Fields {
+		fields = append(fields, f.Field)
+	}
+	return fields
+}
+
+func TestMergeRequestValidate(t *testing.T) {
+	valid := func() MergeRequest {
+		return MergeRequest{
+			VideoBucket: "media-in", VideoKey: "videos/v.mp4",
+			AudioBucket: "media-in", AudioKey: "audio/a.m4a",
+			Region: "eu-west-1",
+		}
+	}
+	tests := []struct {
+		name    string
+		change  func(r *MergeRequest)
+		allowed []string
+		want    []string
+	}{
+		{"valid", func(r *MergeRequest) {}, nil, nil},
+		{"empty", func(r *MergeRequest) { *r = MergeRequest{} }, nil,
+			[]string{"video_bucket", "video_key", "audio_bucket", "audio_key", "region"}},
+		{"bad bucket", func(r *MergeRequest) { r.VideoBucket = "Media_In" }, nil, []string{"video_bucket"}},
+		{"dotted bucket", func(r *MergeRequest) { r.AudioBucket = "media..in" }, nil, []string{"audio_bucket"}},
+		{"absolute key", func(r *MergeRequest) { r.VideoKey = "/etc/passwd" }, nil, []string{"video_key"}},
+		{"dot-dot key", func(r *MergeRequest) { r.AudioKey = "a/../../b" }, nil, []string{"audio_key"}},
+		{"control characters", func(r *MergeRequest) { r.VideoKey = "v\n.mp4" }, nil, []string{"video_key"}},
+		{"long key", func(r *MergeRequest) { r.VideoKey = strings.Repeat("k", 1025) }, nil, []string{"video_key"}},
+		{"bad region", func(r *MergeRequest) { r.Region = "moon-base" }, nil, []string{"region"}},
+		{"allowed region", func(r *MergeRequest) {}, []string{"eu-west-1", "us-east-1"}, nil},
+		{"disallowed region", func(r *MergeRequest) {}, []string{"us-east-1"}, []string{"region"}},
+		{"overwrites video", func(r *MergeRequest) { r.OutputKey = r.VideoKey; r.OutputBucket = r.VideoBucket }, nil, []string{"output_key"}},
+		{"overwrites audio", func(r *MergeRequest) { r.OutputKey = r.AudioKey; r.OutputBucket = r.AudioBucket }, nil, []string{"output_key"}},
+		{"uris", func(r *MergeRequest) {
+			*r = MergeRequest{VideoURI: "s3://media-in/v.mp4", AudioURI: "file:///data/a.m4a", OutputURI: "file:///data/out.mp4", Region: "eu-west-1"}
+		}, nil, nil},
+		{"uri and pair", func(r *MergeRequest) { r.VideoURI = "s3://media-in/v.mp4" }, nil, []string{"video_uri"}},
+		{"bad uri", func(r *MergeRequest) { r.VideoBucket, r.VideoKey, r.VideoURI = "", "", "ftp://host/v.mp4" }, nil, []string{"video_uri"}},
+		{"http video needs an output", func(r *MergeRequest) { r.VideoBucket, r.VideoKey, r.VideoURI = "", "", "https://cdn.example.com/v.mp4" }, nil,
+			[]string{"output_uri"}},
+		{"http output", func(r *MergeRequest) { r.OutputURI = "https://cdn.example.com/out.mp4" }, nil, []string{"output_uri"}},
+		{"unknown format", func(r *MergeRequest) { r.OutputFormat = "avi" }, nil, []string{"output_format"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.change(&r)
+			if got := invalidFields(t, r.Validate(tt.allowed...)); !slices.Equal(got, tt.want) {
+				t.Errorf("invalid fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMediaRequestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		req  MediaRequest
+		want []string
+	}{
+		{"valid", MediaRequest{Bucket: "media-in", Key: "v.mp4", Region: "us-east-1"}, nil},
+		{"output elsewhere", MediaRequest{Bucket: "media-in", Key: "v.mp4", Region: "us-east-1", OutputBucket: "media-out", OutputKey: "v.mp4"}, nil},
+		{"missing", MediaRequest{}, []string{"bucket", "key", "region"}},
+		{"overwrites input", MediaRequest{Bucket: "media-in", Key: "v.mp4", Region: "us-east-1", OutputKey: "v.mp4"}, []string{"output_key"}},
+		{"uris", MediaRequest{URI: "file:///data/v.mp4", OutputURI: "s3://media-out/v.jpg", Region: "us-east-1"}, nil},
+		{"uri and pair", MediaRequest{URI: "s3://media-in/v.mp4", Bucket: "media-in", Key: "v.mp4", Region: "us-east-1"}, []string{"uri"}},
+		{"bad uri", MediaRequest{URI: "ftp://host/v.mp4", Region: "us-east-1"}, []string{"uri"}},
+		{"http input needs an output", MediaRequest{URI: "https://cdn.example.com/v.mp4", Region: "us-east-1"}, []string{"output_uri"}},
+		{"http output", MediaRequest{Bucket: "media-in", Key: "v.mp4", Region: "us-east-1", OutputURI: "https://cdn.example.com/v.jpg"}, []string{"output_uri"}},
+		{"output uri overwrites input", MediaRequest{URI: "s3://media-in/v.mp4", OutputURI: "s3://media-in/v.mp4", Region: "us-east-1"}, []string{"output_key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := invalidFields(t, tt.req.Validate()); !slices.Equal(got, tt.want) {
+				t.Errorf("invalid fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMediaOutputLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		req  MediaRequest
+		want string
+	}{
+		{"next to the input", MediaRequest{Bucket: "media-in", Key: "v/clip.mov"}, "s3://media-in/v/clip_thumb.jpg"},
+		{"output bucket", MediaRequest{Bucket: "media-in", Key: "v/clip.mov", OutputBucket: "media-out"}, "s3://media-out/v/clip_thumb.jpg"},
+		{"output key", MediaRequest{Bucket: "media-in", Key: "v/clip.mov", OutputKey: "t/x.jpg"}, "s3://media-in/t/x.jpg"},
+		{"input uri", MediaRequest{URI: "s3://media-in/v/clip.mov"}, "s3://media-in/v/clip_thumb.jpg"},
+		{"local input", MediaRequest{URI: "file:///data/clip.mov"}, "file:///data/clip_thumb.jpg"},
+		{"output uri", MediaRequest{URI: "https://cdn.example.com/clip.mov?sig=x", OutputURI: "file:///data/t.jpg"}, "file:///data/t.jpg"},
+	}
+	for _, tt := range tests {
+		if got := tt.req.outputLocation("_thumb.jpg").String(); got != tt.want {
+			t.Errorf("%s: output %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestThumbnailOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		req  ThumbnailRequest
+		want []string
+	}{
+		{"defaults", ThumbnailRequest{}, nil},
+		{"set", ThumbnailRequest{AtSec: 12.5, Width: 320}, nil},
+		{"negative time", ThumbnailRequest{AtSec: -1}, []string{"at_sec"}},
+		{"not a number", ThumbnailRequest{AtSec: math.NaN()}, []string{"at_sec"}},
+		{"negative width", ThumbnailRequest{Width: -320}, []string{"width"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator
+			v.thumbnailOptions(tt.req)
+			if got := invalidFields(t, v.err()); !slices.Equal(got, tt.want) {
+				t.Errorf("invalid fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatorRegion(t *testing.T) {
+	tests := []struct {
+		name      string
+		region    string
+		anyRegion bool // S3_ENDPOINT set
+		allowed   []string
+		wantErr   bool
+	}{
+		{"aws region", "ap-southeast-2", false, nil, false},
+		{"gov region", "us-gov-west-1", false, nil, false},
+		{"local region on aws", "local", false, nil, true},
+		{"local region on a custom endpoint", "local", true, nil, false},
+		{"minio default", "minio", true, []string{"minio"}, false},
+		{"custom endpoint still honours the allow list", "local", true, []string{"minio"}, true},
+		{"empty", "", true, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator{anyRegion: tt.anyRegion}
+			v.region(tt.region, tt.allowed)
+			if got := len(v.fields) > 0; got != tt.wantErr {
+				t.Errorf("region(%q) errors = %v, wantErr %v", tt.region, v.fields, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServiceValidatorRegions(t *testing.T) {
+	s := &Service{}
+	s.cfg.S3Endpoint = "http://localhost:9000"
+	v := s.validator()
+	MergeRequest{VideoBucket: "media-in", VideoKey: "v.mp4", AudioBucket: "media-in", AudioKey: "a.m4a", Region: "local"}.validate(&v, nil)
+	if err := v.err(); err != nil {
+		t.Errorf("custom endpoint region rejected: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package consumer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/logger"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+const (
+	workRoot = "./tmp"
+
+	// Work dirs kept for resuming are abandoned once no retry is coming;
+	// anything this old is swept on startup and every sweep interval.
+	staleWorkDirAge      = 24 * time.Hour
+	staleWorkDirInterval = time.Hour
+)
+
+type finalAttemptKey struct{}
+
+// withFinalAttempt marks ctx as the last attempt at a message, after which
+// nothing will resume the job's work dir.
+func withFinalAttempt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, finalAttemptKey{}, true)
+}
+
+func isFinalAttempt(ctx context.Context) bool {
+	final, _ := ctx.Value(finalAttemptKey{}).(bool)
+	return final
+}
+
+// keyedMutex serialises work on the same job key, so two deliveries of one
+// job never share a work dir at the same time.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*refMutex
+}
+
+type refMutex struct {
+	sync.Mutex
+	refs int
+}
+
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*refMutex)
+	}
+	m, ok := k.locks[key]
+	if !ok {
+		m = &refMutex{}
+		k.locks[key] = m
+	}
+	m.refs++
+	k.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		k.mu.Lock()
+		if m.refs--; m.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// acquireWorkDir returns a work dir that stays the same across retries of
+// one job, so partially downloaded inputs can be resumed. The release func
+// removes the dir unless a retry is coming: the attempt failed with a
+// retryable error and was not the last, or shutdown interrupted it and the
+// message will be redelivered.
+func (s *Service) acquireWorkDir(ctx context.Context, prefix, jobKey string) (string, func(error), error) {
+	unlock := s.workLocks.lock(jobKey)
+
+	sum := sha256.Sum256([]byte(jobKey))
+	dir := filepath.Join(workRoot, prefix+"-"+hex.EncodeToString(sum[:8]))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		unlock()
+		return "", nil, fmt.Errorf("work dir: %w", err)
+	}
+	now := time.Now()
+	_ = os.Chtimes(dir, now, now)
+
+	release := func(err error) {
+		defer unlock()
+		if err != nil && (ctx.Err() != nil || retryx.IsRetryable(err) && !isFinalAttempt(ctx)) {
+			logger.Debugf("keeping work dir %s for retry", dir)
+			return
+		}
+		_ = os.RemoveAll(dir)
+	}
+	return dir, release, nil
+}
+
+// sweepWorkDirs runs sweepStaleWorkDirs every interval until ctx is done,
+// so long-running workers don't collect abandoned dirs.
+func sweepWorkDirs(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			sweepStaleWorkDirs()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sweepStaleWorkDirs removes work dirs left behind by jobs that never got
+// their retry (crash, redeploy, retries exhausted).
+func sweepStaleWorkDirs() {
+	entries, err := os.ReadDir(workRoot)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.Contains(e.Name(), "-") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < staleWorkDirAge {
+			continue
+		}
+		path := filepath.Join(workRoot, e.Name())
+		if err := os.RemoveAll(path); err != nil {
+			logger.Warnf("remove stale work dir %s: %v", path, err)
+		} else {
+			logger.Infof("removed stale work dir %s", path)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// inTempDir runs the test from a temp dir, since workRoot is relative.
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+}
+
+func TestWorkDirRelease(t *testing.T) {
+	inTempDir(t)
+	boom := errors.New("boom")
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		wantKept bool
+	}{
+		{"success", context.Background(), nil, false},
+		{"retryable", context.Background(), retryx.Transient(boom), true},
+		{"unclassified", context.Background(), boom, true},
+		{"permanent", context.Background(), retryx.Permanent(boom), false},
+		{"retryable on the final attempt", withFinalAttempt(context.Background()), retryx.Transient(boom), false},
+		{"interrupted by shutdown", withFinalAttempt(canceled), retryx.Permanent(boom), true},
+	}
+	s := &Service{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, release, err := s.acquireWorkDir(tt.ctx, "merge", "job-"+tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			release(tt.err)
+			_, err = os.Stat(dir)
+			if kept := err == nil; kept != tt.wantKept {
+				t.Errorf("work dir kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestWorkDirStableAcrossRetries(t *testing.T) {
+	inTempDir(t)
+	s := &Service{}
+	ctx := context.Background()
+
+	first, release, err := s.acquireWorkDir(ctx, "merge", "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(first, "video_in.mp4"), []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	release(retryx.Transient(errors.New("boom")))
+
+	second, release, err := s.acquireWorkDir(ctx, "merge", "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release(nil)
+	if second != first {
+		t.Fatalf("retry got work dir %s, want %s", second, first)
+	}
+	if _, err := os.Stat(filepath.Join(second, "video_in.mp4")); err != nil {
+		t.Errorf("partial download lost: %v", err)
+	}
+	if other, release, err := s.acquireWorkDir(ctx, "merge", "other-job"); err != nil || other == first {
+		t.Errorf("other job got work dir %s, %v", other, err)
+	} else {
+		release(nil)
+	}
+}
+
+func TestSweepStaleWorkDirs(t *testing.T) {
+	inTempDir(t)
+	old := time.Now().Add(-2 * staleWorkDirAge)
+	dirs := map[string]bool{ // name -> want kept
+		"merge-stale":  false,
+		"merge-recent": true,
+		"keepme":       true, // not a work dir
+	}
+	for name := range dirs {
+		path := filepath.Join(workRoot, name)
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if name != "merge-recent" {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	sweepStaleWorkDirs()
+	for name, wantKept := range dirs {
+		_, err := os.Stat(filepath.Join(workRoot, name))
+		if kept := err == nil; kept != wantKept {
+			t.Errorf("%s kept = %v, want %v", name, kept, wantKept)
+		}
+	}
+}
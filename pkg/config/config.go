@@ -3,6 +3,7 @@ package config
 import (
 	"errors"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -16,7 +17,51 @@ type Config struct {
 	Env     string
 
 	// AWS
-	Region string
+	Region         string
+	AllowedRegions []string
+
+	// S3 endpoint (MinIO/LocalStack/on-prem); empty means AWS
+	S3Endpoint           string
+	S3UsePathStyle       bool
+	S3InsecureSkipVerify bool
+	S3AccessKeyID        string
+	S3SecretAccessKey    string
+	S3SessionToken       string
+	S3RoleARN            string
+
+	// S3 transfers
+	S3PartSizeMB        int
+	S3UploadConcurrency int
+	S3PartTimeout       time.Duration
+
+	S3DownloadConcurrency int
+
+	// Outputs
+	OutputOverwritePolicy string
+	OutputStorageClass    string
+	OutputSSE             string
+	OutputSSEKMSKeyID     string
+	OutputCacheControl    string
+	OutputTags            map[string]string
+	OutputMetadata        map[string]string
+	PresignTTL            time.Duration
+
+	// Non-S3 storage; both are off unless configured
+	StorageFileRoot         string
+	StorageHTTPAllowedHosts []string
+
+	// Merge inputs and outputs are streamed instead of copied to disk
+	MergeStreaming    bool
+	MergeOutputFormat string
+	// Used for merge streams the output container can't hold as they are
+	MergeVideoCodec   string
+	MergeAudioCodec   string
+	MergeVideoBitrate string
+	MergeAudioBitrate string
+	MergeVideoPreset  string
+	// What merges do with audio and video of different lengths
+	MergeMismatchPolicy    string
+	MergeMismatchTolerance time.Duration
 
 	// Kafka
 	KafkaBrokers     []string
@@ -35,6 +80,23 @@ type Config struct {
 	KafkaProducerTopic          string
 	KafkaOutputTopicPartitions  int
 	KafkaOutputTopicReplication int
+	KafkaDLQTopic               string
+	KafkaProgressTopic          string
+	ProgressInterval            time.Duration
+
+	// Workers
+	WorkerConcurrency int
+	MaxAttempts       int
+	RetryBaseDelay    time.Duration
+	RetryMaxDelay     time.Duration
+	JobTimeout        time.Duration // per attempt; 0 means no limit
+
+	// Bucket policy
+	PolicyFile string
+
+	// Idempotency
+	JobStorePath string
+	JobStoreTTL  time.Duration
 }
 
 func LoadAll(dotenvPaths ...string) (Config, error) {
@@ -57,6 +119,61 @@ func Load() (Config, error) {
 
 	// --- AWS ---
 	cfg.Region = getenv("AWS_REGION", "ap-southeast-1")
+	// optional: requests naming any other region are rejected
+	cfg.AllowedRegions = splitAndTrim(getenv("ALLOWED_REGIONS", ""), ",")
+	cfg.S3Endpoint = getenv("S3_ENDPOINT", "")
+	cfg.S3UsePathStyle = getenv("S3_USE_PATH_STYLE", "false") == "true"
+	cfg.S3InsecureSkipVerify = getenv("S3_INSECURE_SKIP_VERIFY", "false") == "true"
+	cfg.S3AccessKeyID = getenv("S3_ACCESS_KEY_ID", "")
+	cfg.S3SecretAccessKey = getenv("S3_SECRET_ACCESS_KEY", "")
+	cfg.S3SessionToken = getenv("S3_SESSION_TOKEN", "")
+	cfg.S3RoleARN = getenv("S3_ROLE_ARN", "")
+	if (cfg.S3AccessKeyID == "") != (cfg.S3SecretAccessKey == "") {
+		errs = append(errs, "S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set together")
+	}
+	cfg.S3PartSizeMB = mustInt("S3_PART_SIZE_MB", 16, &errs)
+	if cfg.S3PartSizeMB < 5 {
+		errs = append(errs, "S3_PART_SIZE_MB must be >= 5")
+	}
+	cfg.S3UploadConcurrency = mustInt("S3_UPLOAD_CONCURRENCY", 4, &errs)
+	cfg.S3PartTimeout = mustDuration("S3_PART_TIMEOUT", 5*time.Minute, &errs)
+	cfg.S3DownloadConcurrency = mustInt("S3_DOWNLOAD_CONCURRENCY", 4, &errs)
+
+	// --- Outputs: always | never | if-different-checksum ---
+	cfg.OutputOverwritePolicy = strings.ToLower(getenv("OUTPUT_OVERWRITE_POLICY", "always"))
+	switch cfg.OutputOverwritePolicy {
+	case "always", "never", "if-different-checksum":
+	default:
+		errs = append(errs, "OUTPUT_OVERWRITE_POLICY must be always, never or if-different-checksum")
+	}
+	cfg.OutputStorageClass = getenv("OUTPUT_STORAGE_CLASS", "")
+	cfg.OutputSSE = getenv("OUTPUT_SSE", "") // AES256 | aws:kms
+	cfg.OutputSSEKMSKeyID = getenv("OUTPUT_SSE_KMS_KEY_ID", "")
+	cfg.OutputCacheControl = getenv("OUTPUT_CACHE_CONTROL", "")
+	cfg.OutputTags = mustKeyValues("OUTPUT_TAGS", &errs)
+	cfg.OutputMetadata = mustKeyValues("OUTPUT_METADATA", &errs)
+	// 0 means results carry no download URL unless the request asks
+	cfg.PresignTTL = mustDuration("PRESIGN_TTL", 0, &errs)
+	if cfg.PresignTTL < 0 || cfg.PresignTTL > 7*24*time.Hour {
+		errs = append(errs, "PRESIGN_TTL must be between 0 and 168h")
+	}
+
+	// --- Storage: file:// under one root, read-only http(s) from listed hosts ---
+	cfg.StorageFileRoot = getenv("STORAGE_FILE_ROOT", "")
+	cfg.StorageHTTPAllowedHosts = splitAndTrim(getenv("STORAGE_HTTP_ALLOWED_HOSTS", ""), ",")
+
+	cfg.MergeStreaming = getenv("MERGE_STREAMING", "false") == "true"
+	cfg.MergeOutputFormat = strings.ToLower(getenv("MERGE_OUTPUT_FORMAT", "mp4"))
+	cfg.MergeVideoCodec = strings.ToLower(getenv("MERGE_VIDEO_CODEC", "h264"))
+	cfg.MergeAudioCodec = strings.ToLower(getenv("MERGE_AUDIO_CODEC", "aac"))
+	cfg.MergeVideoBitrate = getenv("MERGE_VIDEO_BITRATE", "")
+	cfg.MergeAudioBitrate = getenv("MERGE_AUDIO_BITRATE", "")
+	cfg.MergeVideoPreset = getenv("MERGE_VIDEO_PRESET", "veryfast")
+	cfg.MergeMismatchPolicy = strings.ToLower(getenv("MERGE_MISMATCH_POLICY", "shortest"))
+	cfg.MergeMismatchTolerance = mustDuration("MERGE_MISMATCH_TOLERANCE", 500*time.Millisecond, &errs)
+	if cfg.MergeMismatchTolerance < 0 {
+		errs = append(errs, "MERGE_MISMATCH_TOLERANCE must not be negative")
+	}
 
 	// --- Kafka required ---
 	brokers := getenv("KAFKA_BROKERS", "")
@@ -97,6 +214,37 @@ func Load() (Config, error) {
 	if cfg.KafkaProducerTopic == "" {
 		errs = append(errs, "KAFKA_TOPIC_PRODUCER is required")
 	}
+	// optional: failed messages are only logged when unset
+	cfg.KafkaDLQTopic = getenv("KAFKA_TOPIC_DLQ", "")
+	// optional: no progress events when unset
+	cfg.KafkaProgressTopic = getenv("KAFKA_TOPIC_PROGRESS", "")
+	cfg.ProgressInterval = mustDuration("PROGRESS_INTERVAL", 5*time.Second, &errs)
+	if cfg.ProgressInterval <= 0 {
+		errs = append(errs, "PROGRESS_INTERVAL must be > 0")
+	}
+
+	// --- Workers ---
+	cfg.WorkerConcurrency = mustInt("WORKER_CONCURRENCY", runtime.NumCPU(), &errs)
+	if cfg.WorkerConcurrency < 1 {
+		errs = append(errs, "WORKER_CONCURRENCY must be >= 1")
+	}
+	cfg.MaxAttempts = mustInt("MAX_ATTEMPTS", 3, &errs)
+	if cfg.MaxAttempts < 1 {
+		errs = append(errs, "MAX_ATTEMPTS must be >= 1")
+	}
+	cfg.RetryBaseDelay = mustDuration("RETRY_BASE_DELAY", 500*time.Millisecond, &errs)
+	cfg.RetryMaxDelay = mustDuration("RETRY_MAX_DELAY", 30*time.Second, &errs)
+	cfg.JobTimeout = mustDuration("JOB_TIMEOUT", 0, &errs)
+	if cfg.JobTimeout < 0 {
+		errs = append(errs, "JOB_TIMEOUT must be >= 0")
+	}
+
+	// --- Bucket policy (empty disables enforcement) ---
+	cfg.PolicyFile = getenv("POLICY_FILE", "")
+
+	// --- Idempotency (empty path disables dedup) ---
+	cfg.JobStorePath = getenv("JOB_STORE_PATH", "")
+	cfg.JobStoreTTL = mustDuration("JOB_STORE_TTL", 7*24*time.Hour, &errs)
 
 	if len(errs) > 0 {
 		return cfg, errors.New(strings.Join(errs, "; "))
@@ -137,6 +285,25 @@ func mustDuration(key string, def time.Duration, errs *[]string) time.Duration {
 	return d
 }
 
+// mustKeyValues parses "k1=v1,k2=v2"; unset means nil.
+func mustKeyValues(key string, errs *[]string) map[string]string {
+	pairs := splitAndTrim(getenv(key, ""), ",")
+	if len(pairs) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		k, v, ok := strings.Cut(p, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			*errs = append(*errs, key+": invalid list, want k1=v1,k2=v2")
+			return nil
+		}
+		out[k] = strings.TrimSpace(v)
+	}
+	return out
+}
+
 func splitAndTrim(s, sep string) []string {
 	raw := strings.Split(s, sep)
 	out := make([]string, 0, len(raw))
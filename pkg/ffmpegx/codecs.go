@@ -0,0 +1,205 @@
+package ffmpegx
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// Stream actions reported in a StreamDecision.
+const (
+	ActionCopy      = "copy"
+	ActionTranscode = "transcode"
+)
+
+// Encoders used when a stream has to be transcoded, by output codec.
+var (
+	videoEncoders = map[string]string{
+		"h264": "libx264",
+		"hevc": "libx265",
+		"vp8":  "libvpx",
+		"vp9":  "libvpx-vp9",
+		"av1":  "libsvtav1",
+	}
+	audioEncoders = map[string]string{
+		"aac":    "aac",
+		"mp3":    "libmp3lame",
+		"opus":   "libopus",
+		"vorbis": "libvorbis",
+		"ac3":    "ac3",
+		"flac":   "flac",
+	}
+)
+
+// VideoCodecs and AudioCodecs are the codecs MergeAV can transcode to.
+func VideoCodecs() []string { return sortedKeys(videoEncoders) }
+func AudioCodecs() []string { return sortedKeys(audioEncoders) }
+
+// presets are the encoder presets libx264 and libx265 accept.
+var presets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow", "placebo"}
+
+// Presets lists the accepted encoder presets, fastest first.
+func Presets() []string { return slices.Clone(presets) }
+
+// TranscodeVideoEncoder and TranscodeAudioEncoder resolve a Transcode codec,
+// named as a codec ("h264") or as its encoder ("libx264"), to the encoder
+// that writes it into MP4. Anything else is a permanent error.
+func TranscodeVideoEncoder(name string) (string, error) {
+	return transcodeEncoder("video", videoEncoders, outputFormats["mp4"].VideoTargets(), name)
+}
+
+func TranscodeAudioEncoder(name string) (string, error) {
+	return transcodeEncoder("audio", audioEncoders, outputFormats["mp4"].AudioTargets(), name)
+}
+
+func transcodeEncoder(kind string, encoders map[string]string, targets []string, name string) (string, error) {
+	name = normalizeCodec(name)
+	for _, codec := range targets {
+		if name == codec || name == encoders[codec] {
+			return encoders[codec], nil
+		}
+	}
+	return "", retryx.Permanent(fmt.Errorf("%s codec %q: want one of %v", kind, name, targets))
+}
+
+// StreamDecision records what MergeAV did with one input stream.
+type StreamDecision struct {
+	InputCodec  string `json:"input_codec,omitempty"` // empty when the input was piped and not probed
+	Action      string `json:"action"`                // copy or transcode
+	OutputCodec string `json:"output_codec"`
+	Reason      string `json:"reason,omitempty"`
+	Language    string `json:"language,omitempty"` // audio tracks given one
+}
+
+// MergePlan is how MergeAV handled each stream.
+type MergePlan struct {
+	Video StreamDecision `json:"video"`
+	Audio StreamDecision `json:"audio"` // the first audio track
+
+	// AudioTracks lists every audio track, when there is more than one
+	AudioTracks []StreamDecision `json:"audio_tracks,omitempty"`
+
+	// Mismatch is nil unless both input lengths are known. Callers report
+	// it on its own, so it is left out of the plan's JSON.
+	Mismatch *Mismatch `json:"-"`
+}
+
+// decideVideo copies the input when the format accepts its codec. An
+// unprobed (piped) video is copied as before; ffmpeg fails with "Could not
+// find tag for codec" if the container can't hold it.
+func decideVideo(f OutputFormat, in *Stream, codec string) StreamDecision {
+	codec = orDefault(codec, f.DefaultVideo)
+	switch {
+	case in == nil:
+		return StreamDecision{Action: ActionCopy, Reason: "input not probed"}
+	case slices.Contains(f.VideoCodecs, in.Codec):
+		return StreamDecision{InputCodec: in.Codec, Action: ActionCopy, OutputCodec: in.Codec}
+	default:
+		return StreamDecision{InputCodec: in.Codec, Action: ActionTranscode, OutputCodec: codec,
+			Reason: fmt.Sprintf("%s is not supported in %s", in.Codec, f.Name)}
+	}
+}
+
+// decideAudio copies the input when the format accepts its codec. An
+// unprobed (piped) audio is transcoded, which works for any input.
+func decideAudio(f OutputFormat, in *Stream, codec string) StreamDecision {
+	codec = orDefault(codec, f.DefaultAudio)
+	switch {
+	case in == nil:
+		return StreamDecision{Action: ActionTranscode, OutputCodec: codec, Reason: "input not probed"}
+	case slices.Contains(f.AudioCodecs, in.Codec):
+		return StreamDecision{InputCodec: in.Codec, Action: ActionCopy, OutputCodec: in.Codec}
+	default:
+		return StreamDecision{InputCodec: in.Codec, Action: ActionTranscode, OutputCodec: codec,
+			Reason: fmt.Sprintf("%s is not supported in %s", in.Codec, f.Name)}
+	}
+}
+
+// checkTranscodeCodecs rejects target codecs the format can't hold or we
+// have no encoder for.
+func checkTranscodeCodecs(f OutputFormat, video, audio string) error {
+	if v := orDefault(video, f.DefaultVideo); !slices.Contains(f.VideoTargets(), v) {
+		return retryx.Permanent(fmt.Errorf("video codec %q: want one of %v for %s", v, f.VideoTargets(), f.Name))
+	}
+	if a := orDefault(audio, f.DefaultAudio); !slices.Contains(f.AudioTargets(), a) {
+		return retryx.Permanent(fmt.Errorf("audio codec %q: want one of %v for %s", a, f.AudioTargets(), f.Name))
+	}
+	return nil
+}
+
+// videoArgs are the codec options for the output video stream.
+func (d StreamDecision) videoArgs(f OutputFormat, opts MergeOptions) []string {
+	var args []string
+	if d.Action == ActionCopy {
+		args = []string{"-c:v", "copy"}
+	} else {
+		args = []string{"-c:v", videoEncoders[d.OutputCodec]}
+		switch d.OutputCodec {
+		case "h264", "hevc":
+			// 10-bit or 4:2:2 sources would otherwise stay that way, which
+			// most players can't decode.
+			args = append(args, "-pix_fmt", "yuv420p")
+			if opts.Preset != "" {
+				args = append(args, "-preset", opts.Preset)
+			}
+		case "vp9":
+			if opts.VideoBitrate == "" {
+				// libvpx defaults to a very low fixed bitrate
+				args = append(args, "-crf", "32", "-b:v", "0")
+			}
+		}
+		if opts.VideoBitrate != "" {
+			args = append(args, "-b:v", opts.VideoBitrate)
+		}
+	}
+	if d.OutputCodec == "hevc" && f.isoBMFF() {
+		args = append(args, "-tag:v", "hvc1") // Apple players need hvc1, not hev1
+	}
+	return args
+}
+
+// audioArgs are the codec options for output audio stream i.
+func (d StreamDecision) audioArgs(i int, opts MergeOptions) []string {
+	spec := ":a:" + strconv.Itoa(i)
+	if d.Action == ActionCopy {
+		return []string{"-c" + spec, "copy"}
+	}
+	args := []string{"-c" + spec, audioEncoders[d.OutputCodec]}
+	if opts.AudioBitrate != "" {
+		args = append(args, "-b"+spec, opts.AudioBitrate)
+	}
+	if opts.Mismatch == MismatchPad {
+		args = append(args, "-filter"+spec, "apad") // -shortest then stops it at the video's end
+	}
+	return args
+}
+
+func supported(encodable, allowed []string) []string {
+	var out []string
+	for _, c := range encodable {
+		if slices.Contains(allowed, c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return sortedStrings(keys)
+}
+
+func sortedStrings(s []string) []string {
+	slices.Sort(s)
+	return s
+}
+
+func normalizeCodec(c string) string {
+	return strings.ToLower(strings.TrimSpace(c))
+}
@@ -0,0 +1,148 @@
+package ffmpegx
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+func TestTranscodeEncoders(t *testing.T) {
+	tests := []struct {
+		name    string
+		resolve func(string) (string, error)
+		codec   string
+		want    string // "" for an error
+	}{
+		{"video codec", TranscodeVideoEncoder, "h264", "libx264"},
+		{"video encoder", TranscodeVideoEncoder, "libx265", "libx265"},
+		{"video upper case", TranscodeVideoEncoder, " LIBX264 ", "libx264"},
+		{"video not for mp4", TranscodeVideoEncoder, "vp8", ""},
+		{"video unknown", TranscodeVideoEncoder, "prores", ""},
+		{"audio codec", TranscodeAudioEncoder, "mp3", "libmp3lame"},
+		{"audio encoder", TranscodeAudioEncoder, "libmp3lame", "libmp3lame"},
+		{"opus not for mp4", TranscodeAudioEncoder, "libopus", ""},
+		{"audio not for mp4", TranscodeAudioEncoder, "vorbis", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.resolve(tt.codec)
+			if tt.want == "" {
+				if err == nil || !retryx.IsPermanent(err) {
+					t.Fatalf("got %q, %v; want a permanent error", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecideVideo(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		in     *Stream
+		codec  string
+		want   StreamDecision
+	}{
+		{"unprobed", "mp4", nil, "", StreamDecision{Action: ActionCopy, Reason: "input not probed"}},
+		{"h264 into mp4", "mp4", &Stream{Codec: "h264"}, "", StreamDecision{InputCodec: "h264", Action: ActionCopy, OutputCodec: "h264"}},
+		{"copy ignores the target", "mkv", &Stream{Codec: "vp9"}, "hevc", StreamDecision{InputCodec: "vp9", Action: ActionCopy, OutputCodec: "vp9"}},
+		{"vp9 into mp4", "mp4", &Stream{Codec: "vp9"}, "", StreamDecision{InputCodec: "vp9", Action: ActionTranscode, OutputCodec: "h264", Reason: "vp9 is not supported in mp4"}},
+		{"av1 into fmp4", "fmp4", &Stream{Codec: "av1"}, "", StreamDecision{InputCodec: "av1", Action: ActionTranscode, OutputCodec: "h264", Reason: "av1 is not supported in fmp4"}},
+		{"prores into mp4", "mp4", &Stream{Codec: "prores"}, "", StreamDecision{InputCodec: "prores", Action: ActionTranscode, OutputCodec: "h264", Reason: "prores is not supported in mp4"}},
+		{"prores into mp4 as hevc", "mp4", &Stream{Codec: "prores"}, "hevc", StreamDecision{InputCodec: "prores", Action: ActionTranscode, OutputCodec: "hevc", Reason: "prores is not supported in mp4"}},
+		{"h264 into webm", "webm", &Stream{Codec: "h264"}, "", StreamDecision{InputCodec: "h264", Action: ActionTranscode, OutputCodec: "vp9", Reason: "h264 is not supported in webm"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decideVideo(outputFormats[tt.format], tt.in, tt.codec); got != tt.want {
+				t.Errorf("decideVideo = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecideAudio(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		in     *Stream
+		codec  string
+		want   StreamDecision
+	}{
+		{"unprobed", "mp4", nil, "", StreamDecision{Action: ActionTranscode, OutputCodec: "aac", Reason: "input not probed"}},
+		{"unprobed with a target", "mp4", nil, "mp3", StreamDecision{Action: ActionTranscode, OutputCodec: "mp3", Reason: "input not probed"}},
+		{"aac into mp4", "mp4", &Stream{Codec: "aac"}, "", StreamDecision{InputCodec: "aac", Action: ActionCopy, OutputCodec: "aac"}},
+		{"opus into webm", "webm", &Stream{Codec: "opus"}, "", StreamDecision{InputCodec: "opus", Action: ActionCopy, OutputCodec: "opus"}},
+		{"mp3 into mp4", "mp4", &Stream{Codec: "mp3"}, "", StreamDecision{InputCodec: "mp3", Action: ActionCopy, OutputCodec: "mp3"}},
+		{"opus into mp4", "mp4", &Stream{Codec: "opus"}, "", StreamDecision{InputCodec: "opus", Action: ActionTranscode, OutputCodec: "aac", Reason: "opus is not supported in mp4"}},
+		{"vorbis into mp4", "mp4", &Stream{Codec: "vorbis"}, "", StreamDecision{InputCodec: "vorbis", Action: ActionTranscode, OutputCodec: "aac", Reason: "vorbis is not supported in mp4"}},
+		{"aac into webm", "webm", &Stream{Codec: "aac"}, "", StreamDecision{InputCodec: "aac", Action: ActionTranscode, OutputCodec: "opus", Reason: "aac is not supported in webm"}},
+		{"pcm into mov", "mov", &Stream{Codec: "pcm_s16le"}, "", StreamDecision{InputCodec: "pcm_s16le", Action: ActionCopy, OutputCodec: "pcm_s16le"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decideAudio(outputFormats[tt.format], tt.in, tt.codec); got != tt.want {
+				t.Errorf("decideAudio = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTranscodeCodecs(t *testing.T) {
+	tests := []struct {
+		format, video, audio string
+		wantErr              bool
+	}{
+		{"mp4", "", "", false},
+		{"mp4", "hevc", "mp3", false},
+		{"mp4", "vp8", "", true},
+		{"mp4", "", "vorbis", true},
+		{"webm", "", "", false},
+		{"webm", "h264", "", true},
+		{"mkv", "vp8", "vorbis", false},
+	}
+	for _, tt := range tests {
+		err := checkTranscodeCodecs(outputFormats[tt.format], tt.video, tt.audio)
+		if (err != nil) != tt.wantErr || err != nil && !retryx.IsPermanent(err) {
+			t.Errorf("checkTranscodeCodecs(%s, %q, %q) = %v, want error %v", tt.format, tt.video, tt.audio, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDecisionArgs(t *testing.T) {
+	copyHEVC := StreamDecision{Action: ActionCopy, OutputCodec: "hevc"}
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"copy", StreamDecision{Action: ActionCopy, OutputCodec: "h264"}.videoArgs(outputFormats["mp4"], MergeOptions{}),
+			[]string{"-c:v", "copy"}},
+		{"copied hevc in mp4 is tagged hvc1", copyHEVC.videoArgs(outputFormats["mp4"], MergeOptions{}),
+			[]string{"-c:v", "copy", "-tag:v", "hvc1"}},
+		{"copied hevc in mkv", copyHEVC.videoArgs(outputFormats["mkv"], MergeOptions{}),
+			[]string{"-c:v", "copy"}},
+		{"h264", StreamDecision{Action: ActionTranscode, OutputCodec: "h264"}.videoArgs(outputFormats["mp4"], MergeOptions{Preset: "fast", VideoBitrate: "4M"}),
+			[]string{"-c:v", "libx264", "-pix_fmt", "yuv420p", "-preset", "fast", "-b:v", "4M"}},
+		{"vp9 constant quality", StreamDecision{Action: ActionTranscode, OutputCodec: "vp9"}.videoArgs(outputFormats["webm"], MergeOptions{}),
+			[]string{"-c:v", "libvpx-vp9", "-crf", "32", "-b:v", "0"}},
+		{"vp9 with a bitrate", StreamDecision{Action: ActionTranscode, OutputCodec: "vp9"}.videoArgs(outputFormats["webm"], MergeOptions{VideoBitrate: "2M"}),
+			[]string{"-c:v", "libvpx-vp9", "-b:v", "2M"}},
+		{"audio copy", StreamDecision{Action: ActionCopy, OutputCodec: "aac"}.audioArgs(1, MergeOptions{AudioBitrate: "192k"}),
+			[]string{"-c:a:1", "copy"}},
+		{"audio transcode", StreamDecision{Action: ActionTranscode, OutputCodec: "opus"}.audioArgs(0, MergeOptions{AudioBitrate: "128k"}),
+			[]string{"-c:a:0", "libopus", "-b:a:0", "128k"}},
+		{"audio padded", StreamDecision{Action: ActionTranscode, OutputCodec: "aac"}.audioArgs(0, MergeOptions{Mismatch: MismatchPad}),
+			[]string{"-c:a:0", "aac", "-filter:a:0", "apad"}},
+	}
+	for _, tt := range tests {
+		if !slices.Equal(tt.got, tt.want) {
+			t.Errorf("%s: args %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package ffmpegx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// HeaderSize is how much of an input Streamable needs to see; an MP4 whose
+// moov doesn't start within it is treated as needing a seek.
+const HeaderSize = 64 << 10
+
+// Streamable reports whether a container starting with head can be demuxed
+// front to back from a pipe. MP4/MOV qualifies only when its moov (or, for
+// fragmented files, the first moof) comes before the media data; Matroska,
+// WebM, MPEG-TS, Ogg and raw AAC/MP3 always do. Anything unrecognised is
+// reported as not streamable, so callers fall back to a local copy.
+func Streamable(head []byte) bool {
+	switch {
+	case len(head) >= 8 && isBMFFBox(head[4:8]):
+		return moovFirst(head)
+	case bytes.HasPrefix(head, []byte{0x1a, 0x45, 0xdf, 0xa3}): // EBML: Matroska, WebM
+		return true
+	case bytes.HasPrefix(head, []byte("OggS")), bytes.HasPrefix(head, []byte("ID3")):
+		return true
+	case len(head) > 188 && head[0] == 0x47 && head[188] == 0x47: // MPEG-TS sync bytes
+		return true
+	case len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0: // ADTS or MPEG audio frame
+		return true
+	}
+	return false
+}
+
+func isBMFFBox(typ []byte) bool {
+	switch string(typ) {
+	case "ftyp", "styp", "moov", "free", "skip", "wide", "mdat":
+		return true
+	}
+	return false
+}
+
+// moovFirst walks the top-level ISO BMFF boxes in head.
+func moovFirst(head []byte) bool {
+	first, _ := boxOrder(bytes.NewReader(head), int64(len(head)))
+	return first
+}
+
+// boxOrder walks the top-level ISO BMFF boxes of r (size bytes) and reports
+// whether a moov, or for fragmented files a moof, comes before the first
+// mdat. Boxes running past size count as "not first".
+func boxOrder(r io.ReaderAt, size int64) (bool, error) {
+	var hdr [16]byte
+	for off := int64(0); off+8 <= size; {
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return false, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(hdr[:4]))
+		switch string(hdr[4:8]) {
+		case "moov", "moof":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+
+		switch boxSize {
+		case 0: // box runs to the end of the file
+			return false, nil
+		case 1: // 64-bit size follows the type
+			if off+16 > size {
+				return false, nil
+			}
+			if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+				return false, err
+			}
+			large := binary.BigEndian.Uint64(hdr[8:16])
+			if large < 16 || large > uint64(size-off) {
+				return false, nil
+			}
+			boxSize = int64(large)
+		default:
+			if boxSize < 8 {
+				return false, nil
+			}
+		}
+		if boxSize > size-off {
+			return false, nil // next box starts beyond what we have
+		}
+		off += boxSize
+	}
+	return false, nil
+}
+
+// CheckFastStart verifies that the MP4 or MOV at path has its moov before
+// the media data, so players can start before the whole file has arrived.
+func CheckFastStart(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("check faststart: %w", err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("check faststart: %w", err)
+	}
+	first, err := boxOrder(f, st.Size())
+	if err != nil {
+		return fmt.Errorf("check faststart %s: %w", path, err)
+	}
+	if !first {
+		return retryx.Permanent(fmt.Errorf("%s: moov atom is not before the media data", filepath.Base(path)))
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package ffmpegx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// box builds an ISO BMFF box with a 32-bit size.
+func box(typ string, payload int) []byte {
+	b := make([]byte, 8+payload)
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	copy(b[4:], typ)
+	return b
+}
+
+// largeBox builds a box with a 64-bit size.
+func largeBox(typ string, payload int) []byte {
+	b := make([]byte, 16+payload)
+	binary.BigEndian.PutUint32(b, 1)
+	copy(b[4:], typ)
+	binary.BigEndian.PutUint64(b[8:], uint64(len(b)))
+	return b
+}
+
+func boxes(bs ...[]byte) []byte { return bytes.Join(bs, nil) }
+
+func TestBoxOrder(t *testing.T) {
+	truncated := box("free", 32)[:20]
+	tests := []struct {
+		name string
+		file []byte
+		want bool
+	}{
+		{"faststart", boxes(box("ftyp", 16), box("moov", 64), box("mdat", 128)), true},
+		{"moov at the end", boxes(box("ftyp", 16), box("mdat", 128), box("moov", 64)), false},
+		{"fragmented", boxes(box("ftyp", 16), box("moof", 32), box("mdat", 128)), true},
+		{"free before moov", boxes(box("ftyp", 16), box("free", 8), box("moov", 64)), true},
+		{"64-bit size", boxes(box("ftyp", 16), largeBox("wide", 8), box("moov", 64)), true},
+		{"64-bit mdat", boxes(box("ftyp", 16), largeBox("mdat", 64), box("moov", 64)), false},
+		{"box to end of file", boxes(box("ftyp", 16), []byte{0, 0, 0, 0, 'f', 'r', 'e', 'e'}, box("moov", 8)), false},
+		{"box past the end", boxes(box("ftyp", 16), truncated), false},
+		{"bad size", boxes(box("ftyp", 16), []byte{0, 0, 0, 4, 'f', 'r', 'e', 'e'}, box("moov", 8)), false},
+		{"no moov", box("ftyp", 16), false},
+		{"empty", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := boxOrder(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil || got != tt.want {
+				t.Errorf("boxOrder = %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamable(t *testing.T) {
+	ts := make([]byte, 189)
+	ts[0], ts[188] = 0x47, 0x47
+	tests := []struct {
+		name string
+		head []byte
+		want bool
+	}{
+		{"faststart mp4", boxes(box("ftyp", 16), box("moov", 64), box("mdat", 128)), true},
+		{"mp4 with moov at the end", boxes(box("ftyp", 16), box("mdat", 128), box("moov", 64)), false},
+		{"moov beyond the header", boxes(box("ftyp", 16), box("free", HeaderSize), box("moov", 64))[:HeaderSize], false},
+		{"matroska", []byte{0x1a, 0x45, 0xdf, 0xa3, 0x01}, true},
+		{"ogg", []byte("OggS\x00\x02"), true},
+		{"mp3 with id3", []byte("ID3\x04\x00"), true},
+		{"adts", []byte{0xff, 0xf1, 0x50, 0x80}, true},
+		{"mpeg-ts", ts, true},
+		{"unknown", []byte("RIFF\x00\x00\x00\x00AVI "), false},
+		{"empty", nil, false},
+	}
+	for _, tt := range tests {
+		if got := Streamable(tt.head); got != tt.want {
+			t.Errorf("%s: Streamable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckFastStart(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name          string
+		file          []byte
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{"faststart", boxes(box("ftyp", 16), box("moov", 64), box("mdat", 128)), false, false},
+		{"moov at the end", boxes(box("ftyp", 16), box("mdat", 128), box("moov", 64)), true, true},
+	}
+	for _, tt := range tests {
+		p := filepath.Join(dir, tt.name+".mp4")
+		if err := os.WriteFile(p, tt.file, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err := CheckFastStart(p)
+		if (err != nil) != tt.wantErr || retryx.IsPermanent(err) != tt.wantPermanent {
+			t.Errorf("%s: CheckFastStart = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if err := CheckFastStart(filepath.Join(dir, "missing.mp4")); err == nil {
+		t.Error("CheckFastStart of a missing file succeeded")
+	}
+}
+
+func TestFastStartApplies(t *testing.T) {
+	want := map[string]bool{"mp4": true, "mov": true, "fmp4": false, "mkv": false, "webm": false}
+	for name, f := range outputFormats {
+		if got := f.FastStartApplies(); got != want[name] {
+			t.Errorf("%s: FastStartApplies = %v, want %v", name, got, want[name])
+		}
+	}
+}
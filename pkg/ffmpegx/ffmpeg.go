@@ -3,24 +3,38 @@ package ffmpegx
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
 )
 
-type StreamInfo struct {
-	HasVideo bool
-	HasAudio bool
-	Format   string
-	Duration float64
+// OpError records which step of an ffmpeg operation failed, so callers can
+// tell a bad input (probe) apart from a failed encode (merge).
+type OpError struct {
+	Op  string
+	Err error
 }
 
+func (e *OpError) Error() string { return e.Err.Error() }
+func (e *OpError) Unwrap() error { return e.Err }
+
+const (
+	OpProbe        = "probe"
+	OpMerge        = "merge"
+	OpTranscode    = "transcode"
+	OpThumbnail    = "thumbnail"
+	OpExtractAudio = "extract-audio"
+)
+
 func ffmpegPath() string {
 	if p := os.Getenv("FFMPEG_BIN"); p != "" {
 		return p
@@ -46,142 +60,354 @@ func EnsureBinariesExists() error {
 	return nil
 }
 
-func Probe(ctx context.Context, path string) (StreamInfo, error) {
-	type ffprobeOut struct {
-		Streams []struct {
-			CodecType string `json:"codec_type"`
-		} `json:"streams"`
-		Format struct {
-			FormatName string `json:"format_name"`
-			Duration   string `json:"duration"`
-		} `json:"format"`
+// Version reports the ffmpeg build in use, e.g. "6.1.1-3ubuntu5", so
+// outputs can be traced back to the binary that produced them.
+func Version(ctx context.Context) (string, error) {
+	out, stderr, err := run(ctx, ffmpegPath(), "-hide_banner", "-version")
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg -version: %w (stderr: %s)", err, tail(stderr, 500))
 	}
-
-	var si StreamInfo
-	if _, err := os.Stat(path); err != nil {
-		return si, fmt.Errorf("probe: stat %q; %w", path, err)
+	line, _, _ := strings.Cut(string(out), "\n")
+	fields := strings.Fields(line) // ffmpeg version <v> Copyright ...
+	if len(fields) < 3 || fields[1] != "version" {
+		return "", fmt.Errorf("unexpected ffmpeg -version output %q", line)
 	}
+	return fields[2], nil
+}
+
+type MergeOptions struct {
+	Format string // mp4 (default), fmp4, mkv, webm or mov; see OutputFormats
+
+	// Codecs used when an input stream can't be copied into the output;
+	// empty uses the format's default. See OutputFormat.VideoTargets.
+	VideoCodec   string
+	AudioCodec   string
+	VideoBitrate string // e.g. "4M"; empty lets the encoder decide
+	AudioBitrate string // e.g. "128k"
+	Preset       string // h264/hevc encoder preset, e.g. "veryfast"
+
+	// FastStart puts the moov at the front of MP4 and MOV files, so they
+	// play before they have fully downloaded, and checks that it is there.
+	// Fragmented and piped output already starts with one.
+	FastStart bool
+
+	// Mismatch says what to do when the audio and video lengths differ:
+	// MismatchShortest (default), MismatchPad, MismatchLoop, or MismatchFail,
+	// which allows a difference of up to Tolerance.
+	Mismatch  string
+	Tolerance time.Duration
+
+	// AudioOffset delays the audio when positive; when negative the start
+	// of the audio is skipped instead.
+	AudioOffset time.Duration
+
+	OnProgress ProgressFunc
+}
+
+// AudioTrack is one audio input of MergeTracks and how it is tagged.
+type AudioTrack struct {
+	Input    Input
+	Language string // ISO 639 code such as "en" or "eng"; see LanguageCode
+	Title    string
+	Default  bool          // at most one track; otherwise the first is the default
+	Offset   time.Duration // added to MergeOptions.AudioOffset for this track
+}
+
+// MergeAV muxes the first video stream of video with the first audio stream
+// of audio; see MergeTracks.
+func MergeAV(ctx context.Context, video, audio Input, out Output, opts MergeOptions) (MergePlan, error) {
+	return MergeTracks(ctx, video, []AudioTrack{{Input: audio}}, out, opts)
+}
 
-	args := []string{
-		"-v", "error",
-		"-print_format", "json",
-		"-show_streams",
-		"-show_format",
-		path,
+// MergeTracks muxes the first video stream of video with the first audio
+// stream of each track, in order. Each stream is copied when the output
+// container accepts its codec and transcoded otherwise; the returned plan
+// says which. Inputs may be files, URLs or pipes; piped inputs are not
+// probed, so a missing stream only shows up when ffmpeg maps it. With
+// out.Writer set, MP4 and MOV are written fragmented. The plan carries the
+// length mismatch when every input was probed, and a file output with
+// opts.FastStart is checked with CheckFastStart before MergeTracks returns.
+func MergeTracks(ctx context.Context, video Input, tracks []AudioTrack, out Output, opts MergeOptions) (MergePlan, error) {
+	m, err := PrepareMerge(ctx, video, tracks, opts)
+	if err != nil {
+		return m.Plan, err
 	}
+	return m.Plan, m.Run(ctx, out)
+}
+
+// PreparedMerge is a merge whose inputs have been probed and planned, for
+// callers that need the plan before the output is written. Run it once.
+type PreparedMerge struct {
+	Plan MergePlan
+	// OutputSec is the expected output length; 0 when a piped input may
+	// end it early.
+	OutputSec float64
+
+	progressSec float64 // best guess for progress, even with piped inputs
+	format      OutputFormat
+	opts        MergeOptions
+	video       mergeStream
+	audio       []mergeStream
+	decisions   []StreamDecision
+	tags        []trackTag
+}
 
-	stdout, stderr, err := run(ctx, ffprobePath(), args...)
+// PrepareMerge probes the inputs and plans a MergeTracks. A merge the
+// mismatch policy refuses is returned with its plan and the error.
+func PrepareMerge(ctx context.Context, video Input, tracks []AudioTrack, opts MergeOptions) (*PreparedMerge, error) {
+	m := &PreparedMerge{opts: opts}
+	format, err := LookupOutputFormat(opts.Format)
+	if err != nil {
+		return m, &OpError{Op: OpMerge, Err: err}
+	}
+	opts.VideoCodec, opts.AudioCodec = normalizeCodec(opts.VideoCodec), normalizeCodec(opts.AudioCodec)
+	if err := checkTranscodeCodecs(format, opts.VideoCodec, opts.AudioCodec); err != nil {
+		return m, &OpError{Op: OpMerge, Err: err}
+	}
+	opts.Mismatch = orDefault(opts.Mismatch, MismatchShortest)
+	if err := checkMismatchOptions(opts, video, tracks); err != nil {
+		return m, &OpError{Op: OpMerge, Err: err}
+	}
+	tags, err := trackTags(tracks)
 	if err != nil {
-		return si, fmt.Errorf("ffprobe failed: %v, stderr=%s", err, tail(stderr, 8<<10))
+		return m, &OpError{Op: OpMerge, Err: err}
+	}
+	if err := EnsureBinariesExists(); err != nil {
+		return m, err
 	}
 
-	var out ffprobeOut
-	if jerr := json.Unmarshal(stdout, &out); jerr != nil {
-		return si, fmt.Errorf("ffprobe json parse %w", jerr)
+	// Map the streams we probed by index, so cover art is never taken for
+	// the video; unprobed inputs fall back to the first of each type.
+	v := mergeStream{in: video, mapping: "0:v:0"}
+	if v.info, err = probeMergeInput(ctx, "video", video); err != nil {
+		return m, err
+	}
+	if v.info != nil {
+		s, ok := v.info.Video()
+		if !ok {
+			return m, &OpError{Op: OpProbe, Err: retryx.Permanent(errors.New("input video has no video stream"))}
+		}
+		v.stream, v.mapping = &s, fmt.Sprintf("0:%d", s.Index)
+	}
+	audio := make([]mergeStream, len(tracks))
+	for i, t := range tracks {
+		name := TrackName(i, len(tracks))
+		a := mergeStream{in: t.Input, mapping: fmt.Sprintf("%d:a:0", i+1), offset: opts.AudioOffset + t.Offset}
+		if a.info, err = probeMergeInput(ctx, name, t.Input); err != nil {
+			return m, err
+		}
+		if a.info != nil {
+			as := a.info.Audio()
+			if len(as) == 0 {
+				return m, &OpError{Op: OpProbe, Err: retryx.Permanent(fmt.Errorf("input %s has no audio stream", name))}
+			}
+			a.stream, a.mapping = &as[0], fmt.Sprintf("%d:%d", i+1, as[0].Index)
+		}
+		audio[i] = a
 	}
 
-	si.Format = out.Format.FormatName
-	for _, s := range out.Streams {
-		switch s.CodecType {
-		case "video":
-			si.HasVideo = true
-		case "audio":
-			si.HasAudio = true
+	plan := MergePlan{
+		Video:    decideVideo(format, v.stream, opts.VideoCodec),
+		Mismatch: measureMismatch(opts.Mismatch, v, audio),
+	}
+	m.Plan = plan
+	if err := plan.Mismatch.check(opts.Tolerance); err != nil {
+		return m, &OpError{Op: OpMerge, Err: err}
+	}
+	decisions := make([]StreamDecision, len(audio))
+	for i, a := range audio {
+		decisions[i] = decideAudio(format, a.stream, opts.AudioCodec)
+		if opts.Mismatch == MismatchPad {
+			decisions[i] = padAudio(format, decisions[i], opts.AudioCodec)
+		}
+		decisions[i].Language = tags[i].language
+	}
+	plan.Audio = decisions[0]
+	if len(decisions) > 1 {
+		plan.AudioTracks = decisions
+	}
+	expected := v.length()
+	if plan.Mismatch != nil {
+		expected = plan.Mismatch.OutputSec
+	} else if opts.Mismatch == MismatchShortest {
+		for _, a := range audio {
+			if l := a.length(); l > 0 && (expected == 0 || l < expected) {
+				expected = l
+			}
 		}
 	}
 
-	if d := strings.TrimSpace(out.Format.Duration); d != "" {
-		fmt.Sscanf(d, "%f", &si.Duration)
+	outputSec := expected
+	if v.info == nil || opts.Mismatch == MismatchShortest && slices.ContainsFunc(audio, func(a mergeStream) bool { return a.info == nil }) {
+		outputSec = 0
 	}
 
-	return si, nil
+	*m = PreparedMerge{
+		Plan:        plan,
+		OutputSec:   outputSec,
+		progressSec: expected,
+		format:      format,
+		opts:        opts,
+		video:       v,
+		audio:       audio,
+		decisions:   decisions,
+		tags:        tags,
+	}
+	return m, nil
 }
 
-func MergeAV(ctx context.Context, videoPath, audioPath, outPath string, audioCodec string) error {
-	if err := EnsureBinariesExists(); err != nil {
-		return err
-	}
+// Run writes the merge to out.
+func (m *PreparedMerge) Run(ctx context.Context, out Output) error {
+	format, opts, v := m.format, m.opts, m.video
+	prog := newProgress(opts.OnProgress, m.progressSec)
 
-	// Basic Validation
-	if err := mustReadable(videoPath); err != nil {
-		return fmt.Errorf("video %w", err)
+	// ffmpeg command:
+	// ffmpeg -v error -nostdin -y -i video \
+	//   { [-stream_loop -1] [-itsoffset s|-ss s] -i audio }... \
+	//   -map 0:<v> { -map N:<a> }... -c:v <copy|enc> \
+	//   { -c:a:N <copy|enc> [-filter:a:N apad] [-metadata:s:a:N ...] -disposition:a:N ... }... \
+	//   -shortest out
+	ins := []Input{v.in}
+	for _, a := range m.audio {
+		a.in.opts = audioInputOptions(opts.Mismatch, a.offset)
+		ins = append(ins, a.in)
+	}
+	args := func(target ...string) []string {
+		args := append([]string{"-v", "error", "-nostdin", "-y"}, inputArgs(ins...)...)
+		args = append(args, "-map", v.mapping)
+		for _, a := range m.audio {
+			args = append(args, "-map", a.mapping)
+		}
+		args = append(args, m.Plan.Video.videoArgs(format, opts)...)
+		for i, d := range m.decisions {
+			args = append(args, d.audioArgs(i, opts)...)
+			args = append(args, m.tags[i].args(i)...)
+		}
+		args = append(args, "-shortest")
+		return append(args, target...)
+	}
+	if out.Writer != nil {
+		return runToWriter(ctx, OpMerge, ins, out.Writer, prog, args(append(format.muxArgs(true, opts.FastStart), "pipe:1")...))
 	}
-	if err := mustReadable(audioPath); err != nil {
-		return fmt.Errorf("audio %w", err)
+	err := runToFile(ctx, OpMerge, out.Path, ins, prog, func(tmpFile string) []string {
+		return args(append(format.muxArgs(false, opts.FastStart), tmpFile)...)
+	})
+	if err == nil && opts.FastStart && format.FastStartApplies() {
+		if err := CheckFastStart(out.Path); err != nil {
+			return &OpError{Op: OpMerge, Err: err}
+		}
 	}
+	return err
+}
 
-	vInfo, err := Probe(ctx, videoPath)
-	if err != nil {
-		return err
+// mergeStream is a merge input with what probing found out about it.
+type mergeStream struct {
+	in      Input
+	info    *StreamInfo // nil when piped
+	stream  *Stream
+	mapping string
+	offset  time.Duration
+}
+
+// length prefers the stream's own duration over its container's, and
+// includes the offset; 0 when unknown.
+func (m mergeStream) length() float64 {
+	var d float64
+	switch {
+	case m.stream != nil && m.stream.Duration > 0:
+		d = m.stream.Duration
+	case m.info != nil:
+		d = m.info.Duration
 	}
-	aInfo, err := Probe(ctx, audioPath)
-	if err != nil {
-		return err
+	if d <= 0 {
+		return 0
 	}
+	return math.Max(0, d+m.offset.Seconds())
+}
 
-	if !vInfo.HasVideo {
-		return errors.New("input video has no video stream")
+// TrackName names audio track i of n in errors: "audio" when it is the only
+// one, "audio track 1" otherwise.
+func TrackName(i, n int) string {
+	if n == 1 {
+		return "audio"
 	}
-	if !aInfo.HasAudio {
-		return errors.New("input audio has no audio stream")
+	return fmt.Sprintf("audio track %d", i)
+}
+
+// probeMergeInput checks a file or URL input; piped inputs can only be read
+// once, so they are left to ffmpeg and nil is returned.
+func probeMergeInput(ctx context.Context, name string, in Input) (*StreamInfo, error) {
+	switch {
+	case in.Reader != nil:
+		return nil, nil
+	case in.URL != "":
+		si, err := ProbeURL(ctx, in.URL)
+		return &si, err
+	}
+	if err := mustReadable(in.Path); err != nil {
+		return nil, &OpError{Op: OpProbe, Err: fmt.Errorf("%s %w", name, err)}
 	}
+	si, err := Probe(ctx, in.Path)
+	return &si, err
+}
 
-	// atomic outpupt
+// ----- Helper -----
+
+// runToFile runs ffmpeg into a hidden temp file next to outPath and renames
+// it into place on success, so a failed run never leaves a partial output.
+// args must force the muxer with -f since the temp name has no extension.
+// ins lists the inputs the args refer to, for piped inputs and redaction;
+// prog, if set, receives progress reports.
+func runToFile(ctx context.Context, op, outPath string, ins []Input, prog *progress, args func(tmpFile string) []string) error {
 	tmpDir := filepath.Dir(outPath)
 	tmpFile := filepath.Join(tmpDir, "."+filepath.Base(outPath)+".tmp")
 	_ = os.Remove(tmpFile)
 
-	aCodec := "aac"
-	if strings.TrimSpace(strings.ToLower(audioCodec)) != "" {
-		aCodec = audioCodec
-	}
-
-	// ffmpeg command:
-	// ffmpeg -v error -nostdin -y -i video -i audio \
-	//   -map 0:v:0 -map 1:a:0 -c:v copy -c:a <codec> -shortest out
-	args := []string{
-		"-v", "error",
-		"-nostdin",
-		"-y",
-		"-i", videoPath,
-		"-i", audioPath,
-		"-map", "0:v:0",
-		"-map", "1:a:0",
-		"-c:v", "copy",
-		"-f", "mp4",
-		"-c:a", aCodec,
-		"-shortest",
-		tmpFile,
-	}
-
-	_, stderr, runErr := run(ctx, ffmpegPath(), args...)
+	stderr, runErr := runIO(ctx, ffmpegPath(), ins, nil, prog, args(tmpFile)...)
 	if runErr != nil {
-		return fmt.Errorf("ffmpeg merge failed: %v, stderr=%s", runErr, tail(stderr, 16<<10))
+		_ = os.Remove(tmpFile)
+		return &OpError{Op: op, Err: classifyRun(ctx, fmt.Errorf("ffmpeg %s failed: %w, stderr=%s", op, runErr, redactStderr(stderr, ins)), stderr)}
 	}
 
 	if err := os.Rename(tmpFile, outPath); err != nil {
 		_ = os.Remove(tmpFile)
-		return fmt.Errorf("rename output: %w", err)
+		return &OpError{Op: op, Err: fmt.Errorf("rename output: %w", err)}
 	}
 
 	return nil
 }
 
-// ----- Helper -----
 func run(ctx context.Context, bin string, args ...string) ([]byte, []byte, error) {
-	if _, has := ctx.Deadline(); !has {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, 10*time.Minute)
-		defer cancel()
-	}
+	var stdout bytes.Buffer
+	stderr, err := runIO(ctx, bin, nil, &stdout, nil, args...)
+	return stdout.Bytes(), stderr, err
+}
 
-	cmd := exec.CommandContext(ctx, bin, args...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// stderr fragments that mean the input itself is unusable.
+var permanentStderr = []string{
+	"Invalid data found when processing input",
+	"moov atom not found",
+	"does not contain any stream",
+	"matches no streams",
+	"Could not find tag for codec",
+	"codec not currently supported in container",
+	"Unsupported codec",
+	"Server returned 404",
+}
 
-	err := cmd.Run()
-	return stdout.Bytes(), stderr.Bytes(), err
+// classifyRun decides whether a failed ffmpeg/ffprobe run is worth retrying.
+func classifyRun(ctx context.Context, err error, stderr []byte) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// ffmpeg was killed for running out of time, not for the media;
+		// keep the context error so the failure reads as a timeout.
+		return retryx.FromContext(fmt.Errorf("%w: %w", err, ctxErr))
+	}
+	for _, s := range permanentStderr {
+		if bytes.Contains(stderr, []byte(s)) {
+			return retryx.Permanent(err)
+		}
+	}
+	return retryx.Transient(err)
 }
 
 func tail(b []byte, max int) string {
@@ -191,17 +417,19 @@ func tail(b []byte, max int) string {
 	return string(b[len(b)-max:])
 }
 
+// mustReadable checks that p is a readable file. A missing or unreadable
+// input won't appear on a retry, so the error is permanent.
 func mustReadable(p string) error {
 	st, err := os.Stat(p)
 	if err != nil {
-		return err
+		return retryx.Permanent(err)
 	}
 	if st.IsDir() {
-		return fmt.Errorf("%w: path is a directory", fs.ErrInvalid)
+		return retryx.Permanent(fmt.Errorf("%w: path is a directory", fs.ErrInvalid))
 	}
 	f, err := os.Open(p)
 	if err != nil {
-		return err
+		return retryx.Permanent(err)
 	}
 	f.Close()
 	return nil
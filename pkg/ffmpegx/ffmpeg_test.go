@@ -0,0 +1,71 @@
+package ffmpegx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+func TestClassifyRun(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		stderr        string
+		wantPermanent bool
+	}{
+		{"unknown failure", context.Background(), "Connection reset by peer", false},
+		{"bad input", context.Background(), "in.mp4: Invalid data found when processing input", true},
+		{"missing moov", context.Background(), "moov atom not found", true},
+		{"404", context.Background(), "Server returned 404 Not Found", true},
+		{"canceled beats stderr", canceled, "moov atom not found", false},
+		{"deadline beats stderr", expired, "moov atom not found", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyRun(tt.ctx, errors.New("exit status 1"), []byte(tt.stderr))
+			if got := retryx.IsPermanent(err); got != tt.wantPermanent {
+				t.Errorf("IsPermanent = %v, want %v", got, tt.wantPermanent)
+			}
+			if ctxErr := tt.ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+				t.Errorf("%v does not carry the context error %v", err, ctxErr)
+			}
+		})
+	}
+}
+
+func TestMustReadable(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "in.mp4")
+	if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"file", file, false},
+		{"missing", filepath.Join(dir, "nope.mp4"), true},
+		{"directory", dir, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mustReadable(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mustReadable = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !retryx.IsPermanent(err) {
+				t.Errorf("mustReadable error %v is not permanent", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package ffmpegx
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// OutputFormat describes a container MergeAV can write.
+type OutputFormat struct {
+	Name        string
+	Muxer       string
+	Ext         string
+	ContentType string
+	Fragmented  bool // always written as fragments, not just when piped
+	Web         bool // played in browsers, so faststart is on by default
+
+	// Codecs (as ffprobe names them) the container holds as they are, and
+	// the transcode targets used when a request names none.
+	VideoCodecs  []string
+	AudioCodecs  []string
+	DefaultVideo string
+	DefaultAudio string
+}
+
+// DefaultOutputFormat is used when none is given.
+const DefaultOutputFormat = "mp4"
+
+// fragmentFlags let an MP4 or MOV be written front to back: the moov goes
+// first, empty, and every keyframe starts a new fragment.
+const fragmentFlags = "frag_keyframe+empty_moov+default_base_moof"
+
+// The MP4 muxer accepts more than these, but browsers and most players only
+// reliably decode these from an MP4, so anything else is transcoded.
+var (
+	mp4Video = []string{"h264", "hevc"}
+	mp4Audio = []string{"aac", "mp3"}
+)
+
+var outputFormats = map[string]OutputFormat{
+	"mp4": {
+		Name: "mp4", Muxer: "mp4", Ext: ".mp4", ContentType: "video/mp4", Web: true,
+		VideoCodecs: mp4Video, AudioCodecs: mp4Audio, DefaultVideo: "h264", DefaultAudio: "aac",
+	},
+	"fmp4": {
+		Name: "fmp4", Muxer: "mp4", Ext: ".mp4", ContentType: "video/mp4", Fragmented: true, Web: true,
+		VideoCodecs: mp4Video, AudioCodecs: mp4Audio, DefaultVideo: "h264", DefaultAudio: "aac",
+	},
+	"mov": {
+		Name: "mov", Muxer: "mov", Ext: ".mov", ContentType: "video/quicktime",
+		VideoCodecs:  []string{"h264", "hevc", "prores", "mpeg4", "mjpeg"},
+		AudioCodecs:  []string{"aac", "alac", "mp3", "ac3", "pcm_s16le", "pcm_s24le"},
+		DefaultVideo: "h264", DefaultAudio: "aac",
+	},
+	"mkv": {
+		Name: "mkv", Muxer: "matroska", Ext: ".mkv", ContentType: "video/x-matroska",
+		VideoCodecs:  []string{"h264", "hevc", "av1", "vp8", "vp9", "mpeg4", "mpeg2video", "prores"},
+		AudioCodecs:  []string{"aac", "mp3", "ac3", "eac3", "dts", "truehd", "opus", "vorbis", "flac", "alac", "pcm_s16le"},
+		DefaultVideo: "h264", DefaultAudio: "aac",
+	},
+	"webm": {
+		Name: "webm", Muxer: "webm", Ext: ".webm", ContentType: "video/webm", Web: true,
+		VideoCodecs:  []string{"vp8", "vp9", "av1"},
+		AudioCodecs:  []string{"opus", "vorbis"},
+		DefaultVideo: "vp9", DefaultAudio: "opus",
+	},
+}
+
+// OutputFormats lists the supported format names.
+func OutputFormats() []string {
+	names := make([]string, 0, len(outputFormats))
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	return sortedStrings(names)
+}
+
+// LookupOutputFormat returns the format called name ("" means mp4).
+func LookupOutputFormat(name string) (OutputFormat, error) {
+	name = strings.ToLower(orDefault(name, DefaultOutputFormat))
+	f, ok := outputFormats[name]
+	if !ok {
+		return OutputFormat{}, retryx.Permanent(fmt.Errorf("unsupported output format %q", name))
+	}
+	return f, nil
+}
+
+// VideoTargets and AudioTargets are the codecs a stream can be transcoded
+// to for this format.
+func (f OutputFormat) VideoTargets() []string { return supported(VideoCodecs(), f.VideoCodecs) }
+func (f OutputFormat) AudioTargets() []string { return supported(AudioCodecs(), f.AudioCodecs) }
+
+// isoBMFF reports whether the muxer writes MP4/MOV boxes.
+func (f OutputFormat) isoBMFF() bool { return f.Muxer == "mp4" || f.Muxer == "mov" }
+
+// FastStartApplies reports whether faststart changes anything for this
+// format: only unfragmented MP4 and MOV files put their moov at the end.
+// Fragmented output always starts with one. Any output written to a pipe
+// is fragmented too.
+func (f OutputFormat) FastStartApplies() bool { return f.isoBMFF() && !f.Fragmented }
+
+// muxArgs selects the muxer. MP4 and MOV need fragments to be written to a
+// pipe; Matroska and WebM can be written front to back as they are. With
+// faststart, an unfragmented MP4 or MOV file gets its moov moved to the
+// front once ffmpeg has finished writing it.
+func (f OutputFormat) muxArgs(toPipe, faststart bool) []string {
+	args := []string{"-f", f.Muxer}
+	switch {
+	case !f.isoBMFF():
+	case f.Fragmented || toPipe:
+		args = append(args, "-movflags", fragmentFlags)
+	case faststart:
+		args = append(args, "-movflags", "+faststart")
+	}
+	return args
+}
@@ -0,0 +1,70 @@
+package ffmpegx
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+func TestLookupOutputFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		wantName  string
+		wantMuxer string
+		wantErr   bool
+	}{
+		{"", "mp4", "mp4", false},
+		{"MKV", "mkv", "matroska", false},
+		{"fmp4", "fmp4", "mp4", false},
+		{"webm", "webm", "webm", false},
+		{"avi", "", "", true},
+	}
+	for _, tt := range tests {
+		f, err := LookupOutputFormat(tt.name)
+		if (err != nil) != tt.wantErr || f.Name != tt.wantName || f.Muxer != tt.wantMuxer {
+			t.Errorf("LookupOutputFormat(%q) = %s/%s, %v; want %s/%s", tt.name, f.Name, f.Muxer, err, tt.wantName, tt.wantMuxer)
+		}
+		if err != nil && !retryx.IsPermanent(err) {
+			t.Errorf("LookupOutputFormat(%q): %v is not permanent", tt.name, err)
+		}
+	}
+	if got := OutputFormats(); !slices.Equal(got, []string{"fmp4", "mkv", "mov", "mp4", "webm"}) {
+		t.Errorf("OutputFormats() = %v", got)
+	}
+}
+
+func TestOutputFormatTargets(t *testing.T) {
+	for name, f := range outputFormats {
+		if !slices.Contains(f.VideoTargets(), f.DefaultVideo) || !slices.Contains(f.AudioTargets(), f.DefaultAudio) {
+			t.Errorf("%s: defaults %s/%s are not transcode targets %v/%v", name, f.DefaultVideo, f.DefaultAudio, f.VideoTargets(), f.AudioTargets())
+		}
+	}
+	if got := outputFormats["webm"].VideoTargets(); !slices.Equal(got, []string{"av1", "vp8", "vp9"}) {
+		t.Errorf("webm video targets %v", got)
+	}
+}
+
+func TestMuxArgs(t *testing.T) {
+	frag := []string{"-movflags", fragmentFlags}
+	tests := []struct {
+		format    string
+		toPipe    bool
+		faststart bool
+		want      []string
+	}{
+		{"mp4", false, false, []string{"-f", "mp4"}},
+		{"mp4", false, true, []string{"-f", "mp4", "-movflags", "+faststart"}},
+		{"mp4", true, true, append([]string{"-f", "mp4"}, frag...)},
+		{"fmp4", false, true, append([]string{"-f", "mp4"}, frag...)},
+		{"mov", true, false, append([]string{"-f", "mov"}, frag...)},
+		{"mov", false, true, []string{"-f", "mov", "-movflags", "+faststart"}},
+		{"mkv", true, true, []string{"-f", "matroska"}},
+		{"webm", false, true, []string{"-f", "webm"}},
+	}
+	for _, tt := range tests {
+		if got := outputFormats[tt.format].muxArgs(tt.toPipe, tt.faststart); !slices.Equal(got, tt.want) {
+			t.Errorf("%s muxArgs(pipe %v, faststart %v) = %v, want %v", tt.format, tt.toPipe, tt.faststart, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package ffmpegx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+type TranscodeOptions struct {
+	VideoCodec   string // codec or encoder name, e.g. h264 or libx264 (default)
+	AudioCodec   string // default: aac; see TranscodeAudioEncoder
+	VideoBitrate string // e.g. "2500k"; empty lets the encoder decide
+	AudioBitrate string // e.g. "128k"
+	Width        int    // 0 keeps the source size; set one side to scale by aspect
+	Height       int
+	Preset       string // encoder preset, e.g. "veryfast"
+	FastStart    bool   // moov at the front, checked afterwards; see MergeOptions
+	OnProgress   ProgressFunc
+}
+
+// Transcode re-encodes the input into an MP4 with the given codecs.
+func Transcode(ctx context.Context, inPath, outPath string, opts TranscodeOptions) error {
+	vCodec, err := TranscodeVideoEncoder(orDefault(opts.VideoCodec, "h264"))
+	if err != nil {
+		return &OpError{Op: OpTranscode, Err: err}
+	}
+	aCodec, err := TranscodeAudioEncoder(orDefault(opts.AudioCodec, "aac"))
+	if err != nil {
+		return &OpError{Op: OpTranscode, Err: err}
+	}
+	if opts.Preset != "" && !slices.Contains(presets, opts.Preset) {
+		return &OpError{Op: OpTranscode, Err: retryx.Permanent(fmt.Errorf("preset %q: want one of %v", opts.Preset, presets))}
+	}
+
+	info, err := probeInput(ctx, inPath)
+	if err != nil {
+		return err
+	}
+	if !info.HasVideo && !info.HasAudio {
+		return &OpError{Op: OpProbe, Err: retryx.Permanent(errors.New("input has no audio or video stream"))}
+	}
+
+	mp4, _ := LookupOutputFormat("mp4")
+	err = runToFile(ctx, OpTranscode, outPath, nil, newProgress(opts.OnProgress, info.Duration), func(tmpFile string) []string {
+		args := []string{"-v", "error", "-nostdin", "-y", "-i", inPath}
+		if info.HasVideo {
+			args = append(args, "-map", "0:v:0", "-c:v", vCodec)
+			if opts.VideoBitrate != "" {
+				args = append(args, "-b:v", opts.VideoBitrate)
+			}
+			if opts.Preset != "" {
+				args = append(args, "-preset", opts.Preset)
+			}
+			if opts.Width > 0 || opts.Height > 0 {
+				args = append(args, "-vf", scaleFilter(opts.Width, opts.Height))
+			}
+		}
+		if info.HasAudio {
+			args = append(args, "-map", "0:a:0", "-c:a", aCodec)
+			if opts.AudioBitrate != "" {
+				args = append(args, "-b:a", opts.AudioBitrate)
+			}
+		}
+		return append(append(args, mp4.muxArgs(false, opts.FastStart)...), tmpFile)
+	})
+	if err == nil && opts.FastStart {
+		if err := CheckFastStart(outPath); err != nil {
+			return &OpError{Op: OpTranscode, Err: err}
+		}
+	}
+	return err
+}
+
+// Thumbnail grabs a single JPEG frame at atSec (clamped to the duration).
+// width 0 keeps the source width.
+func Thumbnail(ctx context.Context, inPath, outPath string, atSec float64, width int) error {
+	info, err := probeInput(ctx, inPath)
+	if err != nil {
+		return err
+	}
+	if !info.HasVideo {
+		return &OpError{Op: OpProbe, Err: retryx.Permanent(errors.New("input has no video stream"))}
+	}
+	if atSec < 0 {
+		atSec = 0
+	}
+	if info.Duration > 0 && atSec >= info.Duration {
+		atSec = info.Duration / 2
+	}
+
+	return runToFile(ctx, OpThumbnail, outPath, nil, nil, func(tmpFile string) []string {
+		args := []string{
+			"-v", "error", "-nostdin", "-y",
+			"-ss", strconv.FormatFloat(atSec, 'f', 3, 64),
+			"-i", inPath,
+			"-frames:v", "1",
+		}
+		if width > 0 {
+			args = append(args, "-vf", scaleFilter(width, 0))
+		}
+		return append(args, "-c:v", "mjpeg", "-f", "image2", "-update", "1", tmpFile)
+	})
+}
+
+// AudioFormat describes how an extracted audio codec is packaged.
+type AudioFormat struct {
+	Encoder     string
+	Muxer       string
+	Ext         string
+	ContentType string
+}
+
+var audioFormats = map[string]AudioFormat{
+	"aac":  {Encoder: "aac", Muxer: "ipod", Ext: ".m4a", ContentType: "audio/mp4"},
+	"mp3":  {Encoder: "libmp3lame", Muxer: "mp3", Ext: ".mp3", ContentType: "audio/mpeg"},
+	"opus": {Encoder: "libopus", Muxer: "ogg", Ext: ".ogg", ContentType: "audio/ogg"},
+	"flac": {Encoder: "flac", Muxer: "flac", Ext: ".flac", ContentType: "audio/flac"},
+	"wav":  {Encoder: "pcm_s16le", Muxer: "wav", Ext: ".wav", ContentType: "audio/wav"},
+}
+
+// LookupAudioFormat returns the packaging for codec ("" means aac).
+func LookupAudioFormat(codec string) (AudioFormat, error) {
+	codec = strings.ToLower(orDefault(codec, "aac"))
+	f, ok := audioFormats[codec]
+	if !ok {
+		return AudioFormat{}, retryx.Permanent(fmt.Errorf("unsupported audio codec %q", codec))
+	}
+	return f, nil
+}
+
+// ExtractAudio writes the first audio stream of the input, re-encoded with
+// codec (aac, mp3, opus, flac or wav).
+func ExtractAudio(ctx context.Context, inPath, outPath, codec, bitrate string) error {
+	format, err := LookupAudioFormat(codec)
+	if err != nil {
+		return &OpError{Op: OpExtractAudio, Err: err}
+	}
+
+	info, err := probeInput(ctx, inPath)
+	if err != nil {
+		return err
+	}
+	if !info.HasAudio {
+		return &OpError{Op: OpProbe, Err: retryx.Permanent(errors.New("input has no audio stream"))}
+	}
+
+	return runToFile(ctx, OpExtractAudio, outPath, nil, nil, func(tmpFile string) []string {
+		args := []string{
+			"-v", "error", "-nostdin", "-y",
+			"-i", inPath,
+			"-map", "0:a:0",
+			"-vn",
+			"-c:a", format.Encoder,
+		}
+		if bitrate != "" {
+			args = append(args, "-b:a", bitrate)
+		}
+		return append(args, "-f", format.Muxer, tmpFile)
+	})
+}
+
+// probeInput checks the binaries and the input before an operation.
+func probeInput(ctx context.Context, inPath string) (StreamInfo, error) {
+	if err := EnsureBinariesExists(); err != nil {
+		return StreamInfo{}, err
+	}
+	if err := mustReadable(inPath); err != nil {
+		return StreamInfo{}, &OpError{Op: OpProbe, Err: fmt.Errorf("input %w", err)}
+	}
+	return Probe(ctx, inPath)
+}
+
+// scaleFilter keeps the aspect ratio when only one side is given.
+func scaleFilter(width, height int) string {
+	w, h := "-2", "-2"
+	if width > 0 {
+		w = strconv.Itoa(width)
+	}
+	if height > 0 {
+		h = strconv.Itoa(height)
+	}
+	return "scale=" + w + ":" + h
+}
+
+func orDefault(v, def string) string {
+	if v = strings.TrimSpace(v); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,343 @@
+package ffmpegx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// StreamInfo is what ffprobe reports about an input. The first four fields
+// summarise it and keep their original JSON names for existing consumers;
+// Container and Streams carry the full detail.
+type StreamInfo struct {
+	HasVideo bool // a video stream other than cover art
+	HasAudio bool
+	Format   string
+	Duration float64
+
+	Container Container `json:"container"`
+	Streams   []Stream  `json:"streams,omitempty"`
+}
+
+// Container describes the file as a whole.
+type Container struct {
+	FormatName     string            `json:"format_name"` // e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+	FormatLongName string            `json:"format_long_name,omitempty"`
+	Duration       float64           `json:"duration,omitempty"`   // seconds
+	StartTime      float64           `json:"start_time,omitempty"` // seconds
+	Size           int64             `json:"size,omitempty"`
+	BitRate        int64             `json:"bit_rate,omitempty"` // bits per second
+	ProbeScore     int               `json:"probe_score,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"` // keys lower-cased
+}
+
+// Stream describes one stream. Video and audio fields are zero for other
+// stream types, and any value ffprobe could not determine is zero too.
+type Stream struct {
+	Index     int     `json:"index"`
+	Type      string  `json:"type"`  // video, audio, subtitle, data or attachment
+	Codec     string  `json:"codec"` // e.g. h264, aac
+	CodecTag  string  `json:"codec_tag,omitempty"`
+	Profile   string  `json:"profile,omitempty"`
+	Level     int     `json:"level,omitempty"`
+	BitRate   int64   `json:"bit_rate,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
+	StartTime float64 `json:"start_time,omitempty"`
+	TimeBase  string  `json:"time_base,omitempty"`
+	Frames    int64   `json:"frames,omitempty"`
+
+	// Video
+	Width              int     `json:"width,omitempty"`
+	Height             int     `json:"height,omitempty"`
+	PixelFormat        string  `json:"pixel_format,omitempty"`
+	FrameRate          float64 `json:"frame_rate,omitempty"`     // r_frame_rate
+	AvgFrameRate       float64 `json:"avg_frame_rate,omitempty"` // avg_frame_rate
+	SampleAspectRatio  string  `json:"sample_aspect_ratio,omitempty"`
+	DisplayAspectRatio string  `json:"display_aspect_ratio,omitempty"`
+	FieldOrder         string  `json:"field_order,omitempty"`
+	ColorSpace         string  `json:"color_space,omitempty"`
+	ColorTransfer      string  `json:"color_transfer,omitempty"`
+	ColorPrimaries     string  `json:"color_primaries,omitempty"`
+	Rotation           int     `json:"rotation,omitempty"` // clockwise degrees to apply for display: 0, 90, 180 or 270
+
+	// Audio
+	SampleRate    int    `json:"sample_rate,omitempty"`
+	SampleFormat  string `json:"sample_format,omitempty"`
+	Channels      int    `json:"channels,omitempty"`
+	ChannelLayout string `json:"channel_layout,omitempty"`
+
+	Language    string            `json:"language,omitempty"` // ISO 639-2 from the tags, "und" if unset
+	Title       string            `json:"title,omitempty"`
+	Disposition Disposition       `json:"disposition"`
+	Tags        map[string]string `json:"tags,omitempty"` // keys lower-cased
+	SideData    []SideData        `json:"side_data,omitempty"`
+}
+
+// Disposition holds the stream flags that matter when picking streams.
+type Disposition struct {
+	Default         bool `json:"default,omitempty"`
+	Forced          bool `json:"forced,omitempty"`
+	Original        bool `json:"original,omitempty"`
+	Dub             bool `json:"dub,omitempty"`
+	Comment         bool `json:"comment,omitempty"`
+	HearingImpaired bool `json:"hearing_impaired,omitempty"`
+	VisualImpaired  bool `json:"visual_impaired,omitempty"`
+	Captions        bool `json:"captions,omitempty"`
+	Descriptions    bool `json:"descriptions,omitempty"`
+	AttachedPic     bool `json:"attached_pic,omitempty"` // cover art, not a real video stream
+}
+
+// SideData is one entry of a stream's side data, e.g. "Display Matrix".
+// Rotation is only set for display matrices, in ffprobe's counter-clockwise
+// convention.
+type SideData struct {
+	Type     string  `json:"type"`
+	Rotation float64 `json:"rotation,omitempty"`
+}
+
+// Video returns the first video stream that isn't cover art.
+func (si StreamInfo) Video() (Stream, bool) {
+	for _, s := range si.Streams {
+		if s.Type == "video" && !s.Disposition.AttachedPic {
+			return s, true
+		}
+	}
+	return Stream{}, false
+}
+
+// Audio returns the audio streams in file order.
+func (si StreamInfo) Audio() []Stream {
+	var out []Stream
+	for _, s := range si.Streams {
+		if s.Type == "audio" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func Probe(ctx context.Context, path string) (StreamInfo, error) {
+	if _, err := os.Stat(path); err != nil {
+		return StreamInfo{}, &OpError{Op: OpProbe, Err: fmt.Errorf("probe: stat %q; %w", path, err)}
+	}
+	return probe(ctx, FileInput(path))
+}
+
+// ProbeURL probes a remote input; ffprobe fetches only the ranges it needs.
+func ProbeURL(ctx context.Context, url string) (StreamInfo, error) {
+	return probe(ctx, Input{URL: url})
+}
+
+// ffprobe's JSON. Numbers that ffprobe prints as strings stay strings here
+// and are parsed leniently, since "N/A" and missing values are common.
+type ffprobeOut struct {
+	Streams []struct {
+		Index              int               `json:"index"`
+		CodecType          string            `json:"codec_type"`
+		CodecName          string            `json:"codec_name"`
+		CodecTagString     string            `json:"codec_tag_string"`
+		Profile            string            `json:"profile"`
+		Level              int               `json:"level"`
+		BitRate            string            `json:"bit_rate"`
+		Duration           string            `json:"duration"`
+		StartTime          string            `json:"start_time"`
+		TimeBase           string            `json:"time_base"`
+		NbFrames           string            `json:"nb_frames"`
+		Width              int               `json:"width"`
+		Height             int               `json:"height"`
+		PixFmt             string            `json:"pix_fmt"`
+		RFrameRate         string            `json:"r_frame_rate"`
+		AvgFrameRate       string            `json:"avg_frame_rate"`
+		SampleAspectRatio  string            `json:"sample_aspect_ratio"`
+		DisplayAspectRatio string            `json:"display_aspect_ratio"`
+		FieldOrder         string            `json:"field_order"`
+		ColorSpace         string            `json:"color_space"`
+		ColorTransfer      string            `json:"color_transfer"`
+		ColorPrimaries     string            `json:"color_primaries"`
+		SampleRate         string            `json:"sample_rate"`
+		SampleFmt          string            `json:"sample_fmt"`
+		Channels           int               `json:"channels"`
+		ChannelLayout      string            `json:"channel_layout"`
+		Disposition        map[string]int    `json:"disposition"`
+		Tags               map[string]string `json:"tags"`
+		SideDataList       []struct {
+			SideDataType string   `json:"side_data_type"`
+			Rotation     *float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		FormatName     string            `json:"format_name"`
+		FormatLongName string            `json:"format_long_name"`
+		Duration       string            `json:"duration"`
+		StartTime      string            `json:"start_time"`
+		Size           string            `json:"size"`
+		BitRate        string            `json:"bit_rate"`
+		ProbeScore     int               `json:"probe_score"`
+		Tags           map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+func probe(ctx context.Context, in Input) (StreamInfo, error) {
+	args := []string{
+		"-v", "error",
+		"-print_format", "json",
+		"-show_streams",
+		"-show_format",
+	}
+	args = append(args, inputArgs(in)...)
+
+	var stdout bytes.Buffer
+	stderr, err := runIO(ctx, ffprobePath(), []Input{in}, &stdout, nil, args...)
+	if err != nil {
+		return StreamInfo{}, &OpError{Op: OpProbe, Err: classifyRun(ctx, fmt.Errorf("ffprobe failed: %w, stderr=%s", err, redactStderr(stderr, []Input{in})), stderr)}
+	}
+
+	var out ffprobeOut
+	if jerr := json.Unmarshal(stdout.Bytes(), &out); jerr != nil {
+		return StreamInfo{}, &OpError{Op: OpProbe, Err: retryx.Permanent(fmt.Errorf("ffprobe json parse %w", jerr))}
+	}
+	return out.info(), nil
+}
+
+func (out ffprobeOut) info() StreamInfo {
+	f := out.Format
+	si := StreamInfo{
+		Container: Container{
+			FormatName:     f.FormatName,
+			FormatLongName: f.FormatLongName,
+			Duration:       parseFloat(f.Duration),
+			StartTime:      parseFloat(f.StartTime),
+			Size:           parseInt(f.Size),
+			BitRate:        parseInt(f.BitRate),
+			ProbeScore:     f.ProbeScore,
+			Tags:           lowerKeys(f.Tags),
+		},
+	}
+
+	for _, s := range out.Streams {
+		st := Stream{
+			Index:              s.Index,
+			Type:               s.CodecType,
+			Codec:              s.CodecName,
+			CodecTag:           s.CodecTagString,
+			Profile:            s.Profile,
+			Level:              max(s.Level, 0), // -99 means unknown
+			BitRate:            parseInt(s.BitRate),
+			Duration:           parseFloat(s.Duration),
+			StartTime:          parseFloat(s.StartTime),
+			TimeBase:           s.TimeBase,
+			Frames:             parseInt(s.NbFrames),
+			Width:              s.Width,
+			Height:             s.Height,
+			PixelFormat:        s.PixFmt,
+			FrameRate:          parseRational(s.RFrameRate),
+			AvgFrameRate:       parseRational(s.AvgFrameRate),
+			SampleAspectRatio:  s.SampleAspectRatio,
+			DisplayAspectRatio: s.DisplayAspectRatio,
+			FieldOrder:         s.FieldOrder,
+			ColorSpace:         s.ColorSpace,
+			ColorTransfer:      s.ColorTransfer,
+			ColorPrimaries:     s.ColorPrimaries,
+			SampleRate:         int(parseInt(s.SampleRate)),
+			SampleFormat:       s.SampleFmt,
+			Channels:           s.Channels,
+			ChannelLayout:      s.ChannelLayout,
+			Tags:               lowerKeys(s.Tags),
+			Disposition: Disposition{
+				Default:         s.Disposition["default"] == 1,
+				Forced:          s.Disposition["forced"] == 1,
+				Original:        s.Disposition["original"] == 1,
+				Dub:             s.Disposition["dub"] == 1,
+				Comment:         s.Disposition["comment"] == 1,
+				HearingImpaired: s.Disposition["hearing_impaired"] == 1,
+				VisualImpaired:  s.Disposition["visual_impaired"] == 1,
+				Captions:        s.Disposition["captions"] == 1,
+				Descriptions:    s.Disposition["descriptions"] == 1,
+				AttachedPic:     s.Disposition["attached_pic"] == 1,
+			},
+		}
+		st.Language = orDefault(st.Tags["language"], "und")
+		st.Title = st.Tags["title"]
+
+		// Rotation comes from the display matrix, or from the "rotate" tag
+		// that older muxers wrote; the two use opposite directions.
+		rotation, haveRotation := 0.0, false
+		for _, sd := range s.SideDataList {
+			entry := SideData{Type: sd.SideDataType}
+			if sd.Rotation != nil {
+				entry.Rotation = *sd.Rotation
+				rotation, haveRotation = -*sd.Rotation, true
+			}
+			st.SideData = append(st.SideData, entry)
+		}
+		if r, err := strconv.ParseFloat(st.Tags["rotate"], 64); !haveRotation && err == nil {
+			rotation = r
+		}
+		st.Rotation = normalizeRotation(rotation)
+
+		switch {
+		case st.Type == "video" && !st.Disposition.AttachedPic:
+			si.HasVideo = true
+		case st.Type == "audio":
+			si.HasAudio = true
+		}
+		si.Streams = append(si.Streams, st)
+	}
+
+	si.Format = si.Container.FormatName
+	si.Duration = si.Container.Duration
+	return si
+}
+
+// normalizeRotation rounds to a quarter turn in [0, 360).
+func normalizeRotation(deg float64) int {
+	r := int(math.Round(deg/90)) * 90 % 360
+	if r < 0 {
+		r += 360
+	}
+	return r
+}
+
+func parseFloat(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+func parseInt(s string) int64 {
+	v, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	return v
+}
+
+// parseRational reads ffprobe rates like "30000/1001"; "0/0" gives 0.
+func parseRational(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		return parseFloat(s)
+	}
+	d := parseFloat(den)
+	if d == 0 {
+		return 0
+	}
+	return parseFloat(num) / d
+}
+
+func lowerKeys(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[strings.ToLower(k)] = v
+	}
+	return out
+}
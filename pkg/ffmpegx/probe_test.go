@@ -0,0 +1,131 @@
+package ffmpegx
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const probeJSON = `{
+  "streams": [
+    {
+      "index": 0, "codec_type": "video", "codec_name": "h264", "codec_tag_string": "avc1",
+      "profile": "High", "level": 40, "bit_rate": "4000000", "duration": "10.010000",
+      "start_time": "0.000000", "time_base": "1/30000", "nb_frames": "300",
+      "width": 1920, "height": 1080, "pix_fmt": "yuv420p",
+      "r_frame_rate": "30000/1001", "avg_frame_rate": "0/0",
+      "sample_aspect_ratio": "1:1", "display_aspect_ratio": "16:9",
+      "color_space": "bt709", "disposition": {"default": 1, "attached_pic": 0},
+      "tags": {"LANGUAGE": "eng", "handler_name": "VideoHandler"},
+      "side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]
+    },
+    {
+      "index": 1, "codec_type": "audio", "codec_name": "aac", "profile": "LC", "level": -99,
+      "bit_rate": "N/A", "sample_rate": "48000", "sample_fmt": "fltp",
+      "channels": 2, "channel_layout": "stereo",
+      "disposition": {"default": 1, "dub": 1}, "tags": {"title": "Dub"}
+    },
+    {
+      "index": 2, "codec_type": "video", "codec_name": "mjpeg",
+      "disposition": {"attached_pic": 1}, "tags": {"rotate": "90"}
+    }
+  ],
+  "format": {
+    "format_name": "mov,mp4,m4a,3gp,3g2,mj2", "format_long_name": "QuickTime / MOV",
+    "duration": "10.010000", "start_time": "N/A", "size": "5242880", "bit_rate": "4190000",
+    "probe_score": 100, "tags": {"Major_Brand": "isom"}
+  }
+}`
+
+func TestFFprobeOutInfo(t *testing.T) {
+	var out ffprobeOut
+	if err := json.Unmarshal([]byte(probeJSON), &out); err != nil {
+		t.Fatal(err)
+	}
+	si := out.info()
+
+	if !si.HasVideo || !si.HasAudio || si.Format != "mov,mp4,m4a,3gp,3g2,mj2" || si.Duration != 10.01 {
+		t.Errorf("summary %v %v %q %v", si.HasVideo, si.HasAudio, si.Format, si.Duration)
+	}
+	c := si.Container
+	if c.Size != 5242880 || c.BitRate != 4190000 || c.StartTime != 0 || c.ProbeScore != 100 || c.Tags["major_brand"] != "isom" {
+		t.Errorf("container %+v", c)
+	}
+	if len(si.Streams) != 3 {
+		t.Fatalf("got %d streams, want 3", len(si.Streams))
+	}
+
+	v := si.Streams[0]
+	if v.Codec != "h264" || v.CodecTag != "avc1" || v.Level != 40 || v.BitRate != 4000000 || v.Frames != 300 ||
+		v.Width != 1920 || v.Height != 1080 || v.PixelFormat != "yuv420p" || v.ColorSpace != "bt709" {
+		t.Errorf("video stream %+v", v)
+	}
+	if v.FrameRate < 29.97 || v.FrameRate > 29.98 || v.AvgFrameRate != 0 {
+		t.Errorf("frame rates %v, %v; want 29.97 and 0", v.FrameRate, v.AvgFrameRate)
+	}
+	if v.Language != "eng" || !v.Disposition.Default || v.Rotation != 90 {
+		t.Errorf("video language %q default %v rotation %d", v.Language, v.Disposition.Default, v.Rotation)
+	}
+	if len(v.SideData) != 1 || v.SideData[0] != (SideData{Type: "Display Matrix", Rotation: -90}) {
+		t.Errorf("side data %+v", v.SideData)
+	}
+
+	a := si.Streams[1]
+	if a.Level != 0 || a.BitRate != 0 || a.SampleRate != 48000 || a.Channels != 2 || a.ChannelLayout != "stereo" {
+		t.Errorf("audio stream %+v", a)
+	}
+	if a.Language != "und" || a.Title != "Dub" || !a.Disposition.Dub {
+		t.Errorf("audio language %q title %q dub %v", a.Language, a.Title, a.Disposition.Dub)
+	}
+
+	if art := si.Streams[2]; !art.Disposition.AttachedPic || art.Rotation != 90 {
+		t.Errorf("cover art %+v", art)
+	}
+	if got, ok := si.Video(); !ok || got.Index != 0 {
+		t.Errorf("Video() = stream %d, %v; want 0", got.Index, ok)
+	}
+	if got := si.Audio(); len(got) != 1 || got[0].Index != 1 {
+		t.Errorf("Audio() = %+v", got)
+	}
+}
+
+func TestFFprobeOutInfoCoverArtOnly(t *testing.T) {
+	var out ffprobeOut
+	if err := json.Unmarshal([]byte(`{"streams":[{"codec_type":"audio"},{"codec_type":"video","disposition":{"attached_pic":1}}]}`), &out); err != nil {
+		t.Fatal(err)
+	}
+	si := out.info()
+	if si.HasVideo || !si.HasAudio {
+		t.Errorf("HasVideo %v, HasAudio %v; cover art is not video", si.HasVideo, si.HasAudio)
+	}
+	if _, ok := si.Video(); ok {
+		t.Error("Video() returned cover art")
+	}
+}
+
+func TestNormalizeRotation(t *testing.T) {
+	tests := []struct {
+		deg  float64
+		want int
+	}{
+		{0, 0}, {90, 90}, {-90, 270}, {180, 180}, {-180, 180}, {270, 270}, {360, 0}, {89.6, 90}, {-450, 270},
+	}
+	for _, tt := range tests {
+		if got := normalizeRotation(tt.deg); got != tt.want {
+			t.Errorf("normalizeRotation(%v) = %d, want %d", tt.deg, got, tt.want)
+		}
+	}
+}
+
+func TestParseRational(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+	}{
+		{"25/1", 25}, {"48000/1000", 48}, {"0/0", 0}, {"24", 24}, {"N/A", 0}, {"", 0}, {"1/N/A", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRational(tt.s); got != tt.want {
+			t.Errorf("parseRational(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package ffmpegx
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress is one report from ffmpeg's -progress output, sent about every
+// half second while an operation runs.
+type Progress struct {
+	OutTime     time.Duration // how far into the output ffmpeg has got
+	Frame       int64         // video frames written; 0 for audio-only output
+	FPS         float64
+	BitrateKbps float64 // 0 until ffmpeg can tell
+	Speed       float64 // multiple of real time; 0 until ffmpeg can tell
+	TotalSize   int64   // bytes written so far
+	Percent     float64 // of the expected duration; 0 when it is unknown
+	Done        bool    // last report of a successful run
+}
+
+// ProgressFunc receives progress reports. It is called from a goroutine
+// reading ffmpeg's progress pipe, which stalls ffmpeg while it runs, so it
+// must return quickly.
+type ProgressFunc func(Progress)
+
+// progress feeds one ffmpeg run's reports to fn. duration is the expected
+// output length in seconds, 0 if unknown.
+type progress struct {
+	fn       ProgressFunc
+	duration float64
+}
+
+// newProgress returns nil (no -progress) when fn is nil.
+func newProgress(fn ProgressFunc, duration float64) *progress {
+	if fn == nil {
+		return nil
+	}
+	return &progress{fn: fn, duration: duration}
+}
+
+// read parses key=value lines until r is closed. Each report ends with a
+// "progress=continue" or "progress=end" line.
+func (p *progress) read(r io.Reader) {
+	var cur Progress
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		key, val, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "frame":
+			cur.Frame, _ = strconv.ParseInt(val, 10, 64)
+		case "fps":
+			cur.FPS, _ = strconv.ParseFloat(val, 64)
+		case "bitrate": // "1411.2kbits/s" or "N/A"
+			cur.BitrateKbps, _ = strconv.ParseFloat(strings.TrimSuffix(val, "kbits/s"), 64)
+		case "total_size":
+			cur.TotalSize, _ = strconv.ParseInt(val, 10, 64)
+		case "out_time_us":
+			if us, err := strconv.ParseInt(val, 10, 64); err == nil && us >= 0 {
+				cur.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "speed": // "2.5x" or "N/A"
+			cur.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(val), "x"), 64)
+		case "progress":
+			cur.Done = val == "end"
+			cur.Percent = p.percent(cur)
+			p.fn(cur)
+		}
+	}
+	// Keep draining so ffmpeg never blocks on a full pipe.
+	_, _ = io.Copy(io.Discard, r)
+}
+
+func (p *progress) percent(cur Progress) float64 {
+	if p.duration <= 0 {
+		return 0
+	}
+	if cur.Done {
+		return 100
+	}
+	return min(100, cur.OutTime.Seconds()/p.duration*100)
+}
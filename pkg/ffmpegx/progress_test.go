@@ -0,0 +1,90 @@
+package ffmpegx
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressRead(t *testing.T) {
+	const out = `frame=120
+fps=48.00
+stream_0_0_q=28.0
+bitrate=1411.2kbits/s
+total_size=262192
+out_time_us=5000000
+out_time=00:00:05.000000
+dup_frames=0
+speed=2.5x
+progress=continue
+frame=240
+fps=N/A
+bitrate=N/A
+total_size=524288
+out_time_us=-9223372036854775807
+speed=N/A
+progress=continue
+not a key value line
+out_time_us=20000000
+speed= 4x
+progress=end
+`
+	tests := []struct {
+		name     string
+		duration float64
+		want     []Progress
+	}{
+		{
+			name:     "known duration",
+			duration: 10,
+			want: []Progress{
+				{OutTime: 5 * time.Second, Frame: 120, FPS: 48, BitrateKbps: 1411.2, Speed: 2.5, TotalSize: 262192, Percent: 50},
+				{OutTime: 5 * time.Second, Frame: 240, TotalSize: 524288, Percent: 50},
+				{OutTime: 20 * time.Second, Frame: 240, TotalSize: 524288, Speed: 4, Percent: 100, Done: true},
+			},
+		},
+		{
+			name: "unknown duration",
+			want: []Progress{
+				{OutTime: 5 * time.Second, Frame: 120, FPS: 48, BitrateKbps: 1411.2, Speed: 2.5, TotalSize: 262192},
+				{OutTime: 5 * time.Second, Frame: 240, TotalSize: 524288},
+				{OutTime: 20 * time.Second, Frame: 240, TotalSize: 524288, Speed: 4, Done: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Progress
+			newProgress(func(p Progress) { got = append(got, p) }, tt.duration).read(strings.NewReader(out))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d reports, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("report %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProgressPercent(t *testing.T) {
+	p := &progress{duration: 8}
+	tests := []struct {
+		cur  Progress
+		want float64
+	}{
+		{Progress{}, 0},
+		{Progress{OutTime: 2 * time.Second}, 25},
+		{Progress{OutTime: 9 * time.Second}, 100}, // shortest/padding can overshoot
+		{Progress{OutTime: time.Second, Done: true}, 100},
+	}
+	for _, tt := range tests {
+		if got := p.percent(tt.cur); got != tt.want {
+			t.Errorf("percent(%+v) = %v, want %v", tt.cur, got, tt.want)
+		}
+	}
+	if newProgress(nil, 8) != nil {
+		t.Error("newProgress(nil) is not nil")
+	}
+}
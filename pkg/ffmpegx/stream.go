@@ -0,0 +1,209 @@
+package ffmpegx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// Input is a media source for ffmpeg: a local file, a URL ffmpeg fetches
+// itself (e.g. a presigned S3 link, kept off its command line by a
+// urlProxy), or a reader fed to it over a pipe.
+// Exactly one field is set. Piped inputs cannot be seeked, so they must be
+// streamable containers (see Streamable).
+type Input struct {
+	Path   string
+	URL    string
+	Reader io.Reader
+
+	opts []string // ffmpeg options placed before this input's -i
+}
+
+// FileInput is the input read from a local file.
+func FileInput(path string) Input { return Input{Path: path} }
+
+// String names the input for errors, without any URL query string.
+func (in Input) String() string {
+	switch {
+	case in.Reader != nil:
+		return "pipe"
+	case in.URL != "":
+		return redactURL(in.URL)
+	default:
+		return in.Path
+	}
+}
+
+// Output is where ffmpeg writes: a local file, replaced atomically, or a
+// writer receiving the output as it is produced.
+type Output struct {
+	Path   string
+	Writer io.Writer
+}
+
+// FileOutput is the output written to a local file.
+func FileOutput(path string) Output { return Output{Path: path} }
+
+// URLProtocols are the only protocols ffmpeg may use for URL inputs, so a
+// crafted playlist or redirect cannot make it read local files.
+const URLProtocols = "http,https,tls,tcp"
+
+// inputArgs returns the -i arguments for ins. Piped inputs are numbered
+// pipe:3, pipe:4, ... in order, matching the ExtraFiles set up by runIO.
+func inputArgs(ins ...Input) []string {
+	var args []string
+	fd := 3
+	for _, in := range ins {
+		args = append(args, in.opts...)
+		switch {
+		case in.Reader != nil:
+			args = append(args, "-i", "pipe:"+strconv.Itoa(fd))
+			fd++
+		case in.URL != "":
+			args = append(args, "-protocol_whitelist", URLProtocols, "-i", in.URL)
+		default:
+			args = append(args, "-i", in.Path)
+		}
+	}
+	return args
+}
+
+// runIO runs bin with the Reader inputs among ins fed over extra pipes, its
+// URL inputs served through a urlProxy, and its stdout copied into stdout.
+// A failed read from an input fails the run as transient whatever ffmpeg
+// made of the truncated input. With prog set, ffmpeg also reports progress
+// over a pipe after the input ones.
+func runIO(ctx context.Context, bin string, ins []Input, stdout io.Writer, prog *progress, args ...string) ([]byte, error) {
+	args, stopProxy, err := proxyURLInputs(ins, args)
+	if err != nil {
+		return nil, err
+	}
+	defer stopProxy()
+
+	var (
+		extra   []*os.File // child ends, fd 3 onwards
+		parent  []*os.File // our ends
+		feeds   []func()
+		mu      sync.Mutex
+		readErr error
+	)
+	closeAll := func() {
+		for _, f := range append(extra, parent...) {
+			f.Close()
+		}
+	}
+	for _, in := range ins {
+		if in.Reader == nil {
+			continue
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("input pipe: %w", err)
+		}
+		extra, parent = append(extra, r), append(parent, w)
+
+		src := in.Reader
+		feeds = append(feeds, func() {
+			defer w.Close()
+			// Write errors only mean ffmpeg stopped reading (it exited, or
+			// -shortest ended the output); its exit status tells which.
+			_, _ = io.Copy(w, readerFunc(func(p []byte) (int, error) {
+				n, err := src.Read(p)
+				if err != nil && err != io.EOF {
+					mu.Lock()
+					readErr = err
+					mu.Unlock()
+				}
+				return n, err
+			}))
+		})
+	}
+	if prog != nil {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("progress pipe: %w", err)
+		}
+		extra, parent = append(extra, w), append(parent, r)
+		fd := 3 + len(extra) - 1
+		args = append([]string{"-progress", "pipe:" + strconv.Itoa(fd), "-nostats"}, args...)
+		feeds = append(feeds, func() {
+			defer r.Close()
+			prog.read(r)
+		})
+	}
+
+	cmd := exec.CommandContext(ctx, bin, args...)
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	cmd.ExtraFiles = extra
+	// Don't hang on a writer that stopped draining after ffmpeg was killed.
+	cmd.WaitDelay = 10 * time.Second
+
+	if err := cmd.Start(); err != nil {
+		closeAll()
+		return nil, err
+	}
+	// The child has its own copies of these.
+	for _, f := range extra {
+		f.Close()
+	}
+
+	var wg sync.WaitGroup
+	for _, feed := range feeds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			feed()
+		}()
+	}
+
+	err = cmd.Wait()
+	wg.Wait()
+	if readErr != nil {
+		err = retryx.Transient(fmt.Errorf("read input: %w", readErr))
+	}
+	return stderr.Bytes(), err
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// runToWriter runs ffmpeg with its stdout going to out; args must pick a
+// muxer that can write to a pipe and end with pipe:1.
+func runToWriter(ctx context.Context, op string, ins []Input, out io.Writer, prog *progress, args []string) error {
+	stderr, runErr := runIO(ctx, ffmpegPath(), ins, out, prog, args...)
+	if runErr != nil {
+		return &OpError{Op: op, Err: classifyRun(ctx, fmt.Errorf("ffmpeg %s failed: %w, stderr=%s", op, runErr, redactStderr(stderr, ins)), stderr)}
+	}
+	return nil
+}
+
+// redactStderr trims stderr for an error message, removing the query string
+// of URL inputs: presigned links carry credentials.
+func redactStderr(stderr []byte, ins []Input) string {
+	s := tail(stderr, 16<<10)
+	for _, in := range ins {
+		if in.URL != "" {
+			s = strings.ReplaceAll(s, in.URL, redactURL(in.URL))
+		}
+	}
+	return s
+}
+
+func redactURL(u string) string {
+	base, _, _ := strings.Cut(u, "?")
+	return base
+}
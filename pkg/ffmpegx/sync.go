@@ -0,0 +1,145 @@
+package ffmpegx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// Mismatch policies: what MergeAV does when the audio and video lengths
+// differ.
+const (
+	MismatchShortest = "shortest" // cut the output at the shorter input (default)
+	MismatchPad      = "pad"      // pad short audio with silence up to the video's length
+	MismatchLoop     = "loop"     // repeat short audio up to the video's length
+	MismatchFail     = "fail"     // fail when the lengths differ by more than the tolerance
+)
+
+var mismatchPolicies = []string{MismatchShortest, MismatchPad, MismatchLoop, MismatchFail}
+
+// MismatchPolicies lists the supported policies.
+func MismatchPolicies() []string { return slices.Clone(mismatchPolicies) }
+
+// Mismatch is the measured difference between the merge inputs, for the
+// audio track furthest off. Audio lengths include the offset, so a delayed
+// track ends later.
+type Mismatch struct {
+	Policy        string  `json:"policy"`
+	Track         int     `json:"track,omitempty"` // index of the reported audio track
+	VideoSec      float64 `json:"video_sec"`
+	AudioSec      float64 `json:"audio_sec"`
+	DiffSec       float64 `json:"diff_sec"` // audio minus video; negative when the audio is short
+	AudioOffsetMs int64   `json:"audio_offset_ms,omitempty"`
+	OutputSec     float64 `json:"output_sec"` // expected output length
+}
+
+func checkMismatchOptions(opts MergeOptions, video Input, tracks []AudioTrack) error {
+	if len(tracks) == 0 {
+		return retryx.Permanent(errors.New("no audio tracks"))
+	}
+	var piped, audioPiped bool
+	for _, t := range tracks {
+		audioPiped = audioPiped || t.Input.Reader != nil
+	}
+	piped = audioPiped || video.Reader != nil
+
+	switch opts.Mismatch {
+	case MismatchShortest, MismatchPad:
+	case MismatchLoop:
+		if audioPiped {
+			return retryx.Permanent(fmt.Errorf("mismatch policy %s needs seekable audio inputs, not pipes", opts.Mismatch))
+		}
+	case MismatchFail:
+		if piped {
+			return retryx.Permanent(fmt.Errorf("mismatch policy %s needs inputs that can be probed, not pipes", opts.Mismatch))
+		}
+	default:
+		return retryx.Permanent(fmt.Errorf("unknown mismatch policy %q: want one of %v", opts.Mismatch, mismatchPolicies))
+	}
+	if opts.Tolerance < 0 {
+		return retryx.Permanent(fmt.Errorf("negative mismatch tolerance %s", opts.Tolerance))
+	}
+	return nil
+}
+
+// measureMismatch compares each audio track's length with the video's and
+// reports the one furthest off. It returns nil if any length is unknown.
+func measureMismatch(policy string, video mergeStream, audio []mergeStream) *Mismatch {
+	videoSec := video.length()
+	if videoSec <= 0 {
+		return nil
+	}
+	var m *Mismatch
+	outputSec := videoSec
+	for i, a := range audio {
+		audioSec := a.length()
+		if audioSec <= 0 {
+			return nil
+		}
+		outputSec = math.Min(outputSec, audioSec)
+		if diff := audioSec - videoSec; m == nil || math.Abs(diff) > math.Abs(m.DiffSec) {
+			m = &Mismatch{
+				Policy:        policy,
+				Track:         i,
+				VideoSec:      round3(videoSec),
+				AudioSec:      round3(audioSec),
+				DiffSec:       round3(diff),
+				AudioOffsetMs: a.offset.Milliseconds(),
+			}
+		}
+	}
+	m.OutputSec = round3(outputSec)
+	if policy == MismatchPad || policy == MismatchLoop {
+		m.OutputSec = m.VideoSec
+	}
+	return m
+}
+
+// check fails a MismatchFail merge whose lengths are too far apart.
+func (m *Mismatch) check(tolerance time.Duration) error {
+	if m == nil || m.Policy != MismatchFail || math.Abs(m.DiffSec) <= tolerance.Seconds() {
+		return nil
+	}
+	side := "longer"
+	if m.DiffSec < 0 {
+		side = "shorter"
+	}
+	return retryx.Permanent(fmt.Errorf("audio track %d is %.3fs %s than video (tolerance %s)", m.Track, math.Abs(m.DiffSec), side, tolerance))
+}
+
+// audioInputOptions shift and loop an audio input. A negative offset skips
+// the start of the audio rather than giving it negative timestamps.
+func audioInputOptions(policy string, offset time.Duration) []string {
+	var args []string
+	if policy == MismatchLoop {
+		args = append(args, "-stream_loop", "-1")
+	}
+	switch {
+	case offset > 0:
+		args = append(args, "-itsoffset", seconds(offset))
+	case offset < 0:
+		args = append(args, "-ss", seconds(-offset))
+	}
+	return args
+}
+
+// padAudio re-encodes copied audio, since apad is a filter.
+func padAudio(f OutputFormat, d StreamDecision, codec string) StreamDecision {
+	if d.Action != ActionCopy {
+		return d
+	}
+	d.Action, d.OutputCodec = ActionTranscode, orDefault(codec, f.DefaultAudio)
+	d.Reason = "padding with silence needs re-encoding"
+	return d
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+func round3(f float64) float64 { return math.Round(f*1000) / 1000 }
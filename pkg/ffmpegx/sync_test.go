@@ -0,0 +1,162 @@
+package ffmpegx
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// probed is a merge input whose stream lasts sec seconds.
+func probed(sec float64, offset time.Duration) mergeStream {
+	return mergeStream{info: &StreamInfo{}, stream: &Stream{Duration: sec}, offset: offset}
+}
+
+func TestMergeStreamLength(t *testing.T) {
+	tests := []struct {
+		name string
+		m    mergeStream
+		want float64
+	}{
+		{"stream duration", mergeStream{info: &StreamInfo{Duration: 12}, stream: &Stream{Duration: 10}}, 10},
+		{"container duration", mergeStream{info: &StreamInfo{Duration: 12}, stream: &Stream{}}, 12},
+		{"piped", mergeStream{}, 0},
+		{"delayed", probed(10, 1500*time.Millisecond), 11.5},
+		{"skipped past the end", probed(1, -2*time.Second), 0},
+	}
+	for _, tt := range tests {
+		if got := tt.m.length(); got != tt.want {
+			t.Errorf("%s: length = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMeasureMismatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		video  mergeStream
+		audio  []mergeStream
+		want   *Mismatch
+	}{
+		{"video unknown", MismatchShortest, mergeStream{}, []mergeStream{probed(10, 0)}, nil},
+		{"audio unknown", MismatchShortest, probed(10, 0), []mergeStream{probed(10, 0), {}}, nil},
+		{"short audio", MismatchShortest, probed(10, 0), []mergeStream{probed(8.25, 0)},
+			&Mismatch{Policy: MismatchShortest, VideoSec: 10, AudioSec: 8.25, DiffSec: -1.75, OutputSec: 8.25}},
+		{"short audio padded", MismatchPad, probed(10, 0), []mergeStream{probed(8.25, 0)},
+			&Mismatch{Policy: MismatchPad, VideoSec: 10, AudioSec: 8.25, DiffSec: -1.75, OutputSec: 10}},
+		{"long audio looped", MismatchLoop, probed(10, 0), []mergeStream{probed(12, 0)},
+			&Mismatch{Policy: MismatchLoop, VideoSec: 10, AudioSec: 12, DiffSec: 2, OutputSec: 10}},
+		{"offset counts", MismatchFail, probed(10, 0), []mergeStream{probed(10, 500*time.Millisecond)},
+			&Mismatch{Policy: MismatchFail, VideoSec: 10, AudioSec: 10.5, DiffSec: 0.5, AudioOffsetMs: 500, OutputSec: 10}},
+		{"furthest track reported", MismatchShortest, probed(10, 0), []mergeStream{probed(9.5, 0), probed(13, 0), probed(7, 0)},
+			&Mismatch{Policy: MismatchShortest, Track: 1, VideoSec: 10, AudioSec: 13, DiffSec: 3, OutputSec: 7}},
+		{"rounded", MismatchShortest, probed(10.0004, 0), []mergeStream{probed(10.0001, 0)},
+			&Mismatch{Policy: MismatchShortest, VideoSec: 10, AudioSec: 10, DiffSec: 0, OutputSec: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := measureMismatch(tt.policy, tt.video, tt.audio)
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("measureMismatch = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMismatchCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		m         *Mismatch
+		tolerance time.Duration
+		wantErr   string
+	}{
+		{"unmeasured", nil, 0, ""},
+		{"other policy", &Mismatch{Policy: MismatchPad, DiffSec: -5}, 0, ""},
+		{"within tolerance", &Mismatch{Policy: MismatchFail, DiffSec: -0.4}, 500 * time.Millisecond, ""},
+		{"at tolerance", &Mismatch{Policy: MismatchFail, DiffSec: 0.5}, 500 * time.Millisecond, ""},
+		{"short", &Mismatch{Policy: MismatchFail, DiffSec: -1.25}, 500 * time.Millisecond, "1.250s shorter"},
+		{"long", &Mismatch{Policy: MismatchFail, Track: 2, DiffSec: 3}, 0, "audio track 2 is 3.000s longer"},
+	}
+	for _, tt := range tests {
+		err := tt.m.check(tt.tolerance)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: check = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !retryx.IsPermanent(err) {
+			t.Errorf("%s: check = %v, want a permanent error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckMismatchOptions(t *testing.T) {
+	file, pipe := FileInput("/tmp/in.mp4"), Input{Reader: strings.NewReader("")}
+	tests := []struct {
+		name    string
+		opts    MergeOptions
+		video   Input
+		audio   Input
+		noAudio bool
+		wantErr bool
+	}{
+		{"shortest with pipes", MergeOptions{Mismatch: MismatchShortest}, pipe, pipe, false, false},
+		{"pad with pipes", MergeOptions{Mismatch: MismatchPad}, pipe, pipe, false, false},
+		{"loop with piped video", MergeOptions{Mismatch: MismatchLoop}, pipe, file, false, false},
+		{"loop with piped audio", MergeOptions{Mismatch: MismatchLoop}, file, pipe, false, true},
+		{"fail with files", MergeOptions{Mismatch: MismatchFail}, file, file, false, false},
+		{"fail with piped video", MergeOptions{Mismatch: MismatchFail}, pipe, file, false, true},
+		{"unknown policy", MergeOptions{Mismatch: "stretch"}, file, file, false, true},
+		{"negative tolerance", MergeOptions{Mismatch: MismatchFail, Tolerance: -time.Second}, file, file, false, true},
+		{"no audio", MergeOptions{Mismatch: MismatchShortest}, file, file, true, true},
+	}
+	for _, tt := range tests {
+		tracks := []AudioTrack{{Input: tt.audio}}
+		if tt.noAudio {
+			tracks = nil
+		}
+		err := checkMismatchOptions(tt.opts, tt.video, tracks)
+		if (err != nil) != tt.wantErr || err != nil && !retryx.IsPermanent(err) {
+			t.Errorf("%s: checkMismatchOptions = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAudioInputOptions(t *testing.T) {
+	tests := []struct {
+		policy string
+		offset time.Duration
+		want   []string
+	}{
+		{MismatchShortest, 0, nil},
+		{MismatchShortest, 250 * time.Millisecond, []string{"-itsoffset", "0.250"}},
+		{MismatchPad, -1500 * time.Millisecond, []string{"-ss", "1.500"}},
+		{MismatchLoop, 0, []string{"-stream_loop", "-1"}},
+		{MismatchLoop, 2 * time.Second, []string{"-stream_loop", "-1", "-itsoffset", "2.000"}},
+	}
+	for _, tt := range tests {
+		if got := audioInputOptions(tt.policy, tt.offset); !slices.Equal(got, tt.want) {
+			t.Errorf("audioInputOptions(%s, %s) = %v, want %v", tt.policy, tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestPadAudio(t *testing.T) {
+	mp4 := outputFormats["mp4"]
+	copied := StreamDecision{InputCodec: "aac", Action: ActionCopy, OutputCodec: "aac"}
+	want := StreamDecision{InputCodec: "aac", Action: ActionTranscode, OutputCodec: "aac", Reason: "padding with silence needs re-encoding"}
+	if got := padAudio(mp4, copied, ""); got != want {
+		t.Errorf("padAudio(copy) = %+v, want %+v", got, want)
+	}
+	if got := padAudio(mp4, copied, "mp3"); got.OutputCodec != "mp3" {
+		t.Errorf("padAudio(copy, mp3) = %+v", got)
+	}
+	transcoded := StreamDecision{InputCodec: "vorbis", Action: ActionTranscode, OutputCodec: "aac", Reason: "vorbis is not supported in mp4"}
+	if got := padAudio(mp4, transcoded, "mp3"); got != transcoded {
+		t.Errorf("padAudio(transcode) = %+v, want it unchanged", got)
+	}
+}
@@ -0,0 +1,93 @@
+package ffmpegx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// iso639_1 maps two-letter language codes to the three-letter ISO 639-2/T
+// codes MP4 and Matroska store. The list covers the languages we deliver;
+// any three-letter code is accepted as it is.
+var iso639_1 = map[string]string{
+	"ar": "ara", "bg": "bul", "bn": "ben", "ca": "cat", "cs": "ces",
+	"cy": "cym", "da": "dan", "de": "deu", "el": "ell", "en": "eng",
+	"es": "spa", "et": "est", "eu": "eus", "fa": "fas", "fi": "fin",
+	"fr": "fra", "ga": "gle", "gl": "glg", "he": "heb",
+	"hi": "hin", "hr": "hrv", "hu": "hun", "hy": "hye", "id": "ind",
+	"is": "isl", "it": "ita", "ja": "jpn", "ka": "kat", "kk": "kaz",
+	"ko": "kor", "lt": "lit", "lv": "lav", "mk": "mkd", "ms": "msa",
+	"nb": "nob", "nl": "nld", "nn": "nno", "no": "nor", "pl": "pol",
+	"pt": "por", "ro": "ron", "ru": "rus", "sk": "slk", "sl": "slv",
+	"sq": "sqi", "sr": "srp", "sv": "swe", "sw": "swa", "ta": "tam",
+	"te": "tel", "th": "tha", "tr": "tur", "uk": "ukr", "ur": "urd",
+	"vi": "vie", "zh": "zho",
+}
+
+// LanguageCode turns a language tag such as "en", "eng" or "en-US" into the
+// three-letter code written to the output; "" stays "".
+func LanguageCode(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", nil
+	}
+	base, _, _ := strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	if code, ok := iso639_1[base]; ok {
+		return code, nil
+	}
+	if len(base) == 3 && strings.Trim(base, "abcdefghijklmnopqrstuvwxyz") == "" {
+		return base, nil
+	}
+	return "", retryx.Permanent(fmt.Errorf("unknown language %q: want an ISO 639 code such as \"en\" or \"eng\"", tag))
+}
+
+// trackTag is how an audio track is labelled in the output.
+type trackTag struct {
+	language  string
+	title     string
+	isDefault bool
+}
+
+// trackTags checks the tracks' labels. Exactly one track ends up marked as
+// the default: the one asked for, or the first.
+func trackTags(tracks []AudioTrack) ([]trackTag, error) {
+	tags := make([]trackTag, len(tracks))
+	defaults := 0
+	for i, t := range tracks {
+		lang, err := LanguageCode(t.Language)
+		if err != nil {
+			return nil, fmt.Errorf("audio track %d: %w", i, err)
+		}
+		tags[i] = trackTag{language: lang, title: t.Title, isDefault: t.Default}
+		if t.Default {
+			defaults++
+		}
+	}
+	switch {
+	case defaults > 1:
+		return nil, retryx.Permanent(fmt.Errorf("%d audio tracks are marked default; at most one can be", defaults))
+	case defaults == 0 && len(tags) > 0:
+		tags[0].isDefault = true
+	}
+	return tags, nil
+}
+
+// args set the metadata and disposition of output audio stream i. Labels
+// left empty keep what the input had.
+func (t trackTag) args(i int) []string {
+	spec := ":s:a:" + strconv.Itoa(i)
+	var args []string
+	if t.language != "" {
+		args = append(args, "-metadata"+spec, "language="+t.language)
+	}
+	if t.title != "" {
+		args = append(args, "-metadata"+spec, "title="+t.title)
+	}
+	disposition := "0"
+	if t.isDefault {
+		disposition = "default"
+	}
+	return append(args, "-disposition:a:"+strconv.Itoa(i), disposition)
+}
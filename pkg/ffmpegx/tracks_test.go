@@ -0,0 +1,84 @@
+package ffmpegx
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+func TestLanguageCode(t *testing.T) {
+	tests := []struct {
+		tag     string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"en", "eng", false},
+		{" EN ", "eng", false},
+		{"en-US", "eng", false},
+		{"pt_BR", "por", false},
+		{"zh-Hant-TW", "zho", false},
+		{"eng", "eng", false},
+		{"fil", "fil", false},
+		{"xx", "", true},
+		{"english", "", true},
+		{"e1g", "", true},
+	}
+	for _, tt := range tests {
+		got, err := LanguageCode(tt.tag)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("LanguageCode(%q) = %q, %v; want %q, error %v", tt.tag, got, err, tt.want, tt.wantErr)
+		}
+		if err != nil && !retryx.IsPermanent(err) {
+			t.Errorf("LanguageCode(%q): %v is not permanent", tt.tag, err)
+		}
+	}
+}
+
+func TestTrackTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracks  []AudioTrack
+		want    []trackTag
+		wantErr bool
+	}{
+		{"none", nil, []trackTag{}, false},
+		{"first is the default", []AudioTrack{{Language: "en"}, {Language: "es", Title: "Español"}},
+			[]trackTag{{language: "eng", isDefault: true}, {language: "spa", title: "Español"}}, false},
+		{"requested default", []AudioTrack{{Language: "en"}, {Language: "ja", Default: true}},
+			[]trackTag{{language: "eng"}, {language: "jpn", isDefault: true}}, false},
+		{"unlabelled", []AudioTrack{{}}, []trackTag{{isDefault: true}}, false},
+		{"two defaults", []AudioTrack{{Default: true}, {Default: true}}, nil, true},
+		{"bad language", []AudioTrack{{Language: "en"}, {Language: "klingon"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := trackTags(tt.tracks)
+			if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+				t.Fatalf("trackTags = %+v, %v; want %+v, error %v", got, err, tt.want, tt.wantErr)
+			}
+			if err != nil && !retryx.IsPermanent(err) {
+				t.Errorf("%v is not permanent", err)
+			}
+		})
+	}
+}
+
+func TestTrackTagArgs(t *testing.T) {
+	tests := []struct {
+		tag  trackTag
+		i    int
+		want []string
+	}{
+		{trackTag{language: "eng", title: "English", isDefault: true}, 0,
+			[]string{"-metadata:s:a:0", "language=eng", "-metadata:s:a:0", "title=English", "-disposition:a:0", "default"}},
+		{trackTag{language: "spa"}, 2, []string{"-metadata:s:a:2", "language=spa", "-disposition:a:2", "0"}},
+		{trackTag{}, 1, []string{"-disposition:a:1", "0"}},
+	}
+	for _, tt := range tests {
+		if got := tt.tag.args(tt.i); !slices.Equal(got, tt.want) {
+			t.Errorf("%+v.args(%d) = %v, want %v", tt.tag, tt.i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package ffmpegx
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// urlProxy serves URL inputs to ffmpeg from a loopback listener under
+// random paths. Presigned links carry credentials in their query string,
+// and anything on ffmpeg's command line is readable by every local user
+// through ps and /proc; only the loopback URL goes there. Range requests
+// are passed through, so ffmpeg can still seek.
+type urlProxy struct {
+	srv     *http.Server
+	client  *http.Client
+	targets map[string]string // path -> upstream URL
+}
+
+// proxiedHeaders are copied between ffmpeg and the upstream, in both
+// directions where they apply.
+var proxiedHeaders = []string{
+	"Range", "If-Range",
+	"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified",
+}
+
+// startURLProxy serves each URL input in ins and returns the local URL
+// for each upstream one. stop shuts the proxy down.
+func startURLProxy(ins []Input) (local map[string]string, stop func(), err error) {
+	p := &urlProxy{
+		client:  &http.Client{},
+		targets: make(map[string]string),
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, fmt.Errorf("input proxy: %w", err)
+	}
+	local = make(map[string]string)
+	for _, in := range ins {
+		if in.URL == "" || local[in.URL] != "" {
+			continue
+		}
+		token := make([]byte, 16)
+		if _, err := rand.Read(token); err != nil {
+			ln.Close()
+			return nil, nil, fmt.Errorf("input proxy: %w", err)
+		}
+		// Keep the file name; ffmpeg may use its extension to pick a demuxer.
+		name := "input"
+		if u, err := url.Parse(in.URL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+			name = path.Base(u.Path)
+		}
+		p.targets["/"+hex.EncodeToString(token)+"/"+name] = in.URL
+	}
+	for route, target := range p.targets {
+		local[target] = "http://" + ln.Addr().String() + route
+	}
+
+	p.srv = &http.Server{Handler: p, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = p.srv.Serve(ln) }()
+	return local, func() { _ = p.srv.Close() }, nil
+}
+
+func (p *urlProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target, ok := p.targets[r.URL.EscapedPath()]
+	if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		http.NotFound(w, r)
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, nil)
+	if err != nil {
+		http.Error(w, "bad upstream URL", http.StatusInternalServerError)
+		return
+	}
+	copyHeaders(req.Header, r.Header)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		// ffmpeg reports "Server returned 5XX", which is retried.
+		http.Error(w, "upstream unreachable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	copyHeaders(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+func copyHeaders(dst, src http.Header) {
+	for _, h := range proxiedHeaders {
+		if v := src.Get(h); v != "" {
+			dst.Set(h, v)
+		}
+	}
+}
+
+// proxyURLInputs starts a urlProxy when ins has URL inputs and swaps their
+// URLs in args for the local ones. stop is a no-op otherwise.
+func proxyURLInputs(ins []Input, args []string) (proxied []string, stop func(), err error) {
+	if !hasURLInput(ins) {
+		return args, func() {}, nil
+	}
+	local, stop, err := startURLProxy(ins)
+	if err != nil {
+		return nil, nil, err
+	}
+	proxied = make([]string, len(args))
+	for i, a := range args {
+		if l, ok := local[a]; ok {
+			a = l
+		}
+		proxied[i] = a
+	}
+	return proxied, stop, nil
+}
+
+func hasURLInput(ins []Input) bool {
+	for _, in := range ins {
+		if in.URL != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package ffmpegx
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyURLInputs(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "secret" {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "v.mp4", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer upstream.Close()
+	videoURL := upstream.URL + "/media/v.mp4?sig=secret"
+	audioURL := upstream.URL + "/?sig=secret"
+
+	ins := []Input{{URL: videoURL}, FileInput("/tmp/a.m4a"), {URL: audioURL}, {URL: videoURL}}
+	args := []string{"-i", videoURL, "-i", "/tmp/a.m4a", "-i", audioURL, "-i", videoURL, "out.mp4"}
+	proxied, stop, err := proxyURLInputs(ins, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	if strings.Contains(strings.Join(proxied, " "), "secret") {
+		t.Fatalf("signed URL left on the command line: %v", proxied)
+	}
+	if proxied[1] != proxied[7] {
+		t.Errorf("the same URL got two proxies: %s, %s", proxied[1], proxied[7])
+	}
+	if proxied[3] != "/tmp/a.m4a" || proxied[8] != "out.mp4" {
+		t.Errorf("non-URL arguments changed: %v", proxied)
+	}
+	if got := path.Base(proxied[1]); got != "v.mp4" {
+		t.Errorf("proxied video name %q, want v.mp4", got)
+	}
+	if got := path.Base(proxied[5]); got != "input" {
+		t.Errorf("proxied name for a bare host %q, want input", got)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		rangeHdr   string
+		wantStatus int
+		wantBody   string
+	}{
+		{"get", http.MethodGet, proxied[1], "", http.StatusOK, "0123456789"},
+		{"range", http.MethodGet, proxied[1], "bytes=2-4", http.StatusPartialContent, "234"},
+		{"head", http.MethodHead, proxied[1], "", http.StatusOK, ""},
+		{"post", http.MethodPost, proxied[1], "", http.StatusNotFound, ""},
+		{"unknown path", http.MethodGet, strings.TrimSuffix(proxied[1], "v.mp4") + "x.mp4", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.rangeHdr != "" {
+			req.Header.Set("Range", tt.rangeHdr)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.wantStatus)
+		}
+		if tt.wantStatus < 300 && string(body) != tt.wantBody {
+			t.Errorf("%s: body %q, want %q", tt.name, body, tt.wantBody)
+		}
+		if tt.name == "range" && resp.Header.Get("Content-Range") != "bytes 2-4/10" {
+			t.Errorf("range: Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+	}
+}
+
+func TestProxyURLInputsUpstreamDown(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	target := upstream.URL + "/v.mp4"
+	upstream.Close()
+
+	proxied, stop, err := proxyURLInputs([]Input{{URL: target}}, []string{"-i", target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	resp, err := http.Get(proxied[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status %d, want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+}
+
+func TestProxyURLInputsWithoutURLs(t *testing.T) {
+	args := []string{"-i", "/tmp/v.mp4", "-i", "pipe:3", "out.mp4"}
+	proxied, stop, err := proxyURLInputs([]Input{FileInput("/tmp/v.mp4"), {Reader: strings.NewReader("")}}, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	if !slices.Equal(proxied, args) {
+		t.Errorf("args changed without URL inputs: %v", proxied)
+	}
+}
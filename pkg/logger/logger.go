@@ -34,10 +34,8 @@ func init() {
 }
 
 func initWriters() {
-	_, err := config.LoadAll("configs/.env.production")
-	if err != nil {
-		fmt.Errorf("Error reading configuration")
-	}
+	// The .env file is optional; settings may come from the environment.
+	_, _ = config.LoadAll("configs/.env.production")
 	logFile := os.Getenv("LOG_FILE")
 	toStdout := os.Getenv("LOG_TO_STDOUT") == "true"
 	maxSizeMB := mustInt("LOG_MAX_SIZE_MB", 100) // rotate at 100MB
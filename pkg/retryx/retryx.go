@@ -0,0 +1,86 @@
+package retryx
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// ErrPermanent marks failures that can never succeed on retry (bad request,
+// missing object, unusable media). ErrTransient marks failures worth
+// retrying (timeouts, throttling, 5xx). Unclassified errors are treated as
+// transient.
+var (
+	ErrPermanent = errors.New("permanent failure")
+	ErrTransient = errors.New("transient failure")
+)
+
+type classified struct {
+	kind error
+	err  error
+}
+
+func (e *classified) Error() string   { return e.err.Error() }
+func (e *classified) Unwrap() []error { return []error{e.err, e.kind} }
+
+// Permanent marks err as permanent. An error that is already classified
+// keeps its original classification, since the innermost caller knows best.
+func Permanent(err error) error {
+	return classify(err, ErrPermanent)
+}
+
+// Transient marks err as retryable, unless it is already classified.
+func Transient(err error) error {
+	return classify(err, ErrTransient)
+}
+
+func classify(err, kind error) error {
+	if err == nil || IsClassified(err) {
+		return err
+	}
+	return &classified{kind: kind, err: err}
+}
+
+func IsClassified(err error) bool {
+	return errors.Is(err, ErrPermanent) || errors.Is(err, ErrTransient)
+}
+
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrPermanent)
+}
+
+// IsRetryable reports whether err may succeed on another attempt.
+func IsRetryable(err error) bool {
+	return err != nil && !IsPermanent(err)
+}
+
+// FromContext classifies cancellation and deadline errors as transient; the
+// work itself was fine, it just ran out of time.
+func FromContext(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return Transient(err)
+	}
+	return err
+}
+
+// Backoff returns the delay before retry number attempt (1-based): an
+// exponential step from base capped at max, with equal jitter so workers
+// that failed together do not retry together.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
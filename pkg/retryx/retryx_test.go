@@ -0,0 +1,76 @@
+package retryx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name          string
+		err           error
+		wantPermanent bool
+		wantRetryable bool
+	}{
+		{"nil", nil, false, false},
+		{"unclassified", boom, false, true},
+		{"permanent", Permanent(boom), true, false},
+		{"transient", Transient(boom), false, true},
+		{"wrapped permanent", fmt.Errorf("job: %w", Permanent(boom)), true, false},
+		{"innermost permanent wins", Transient(fmt.Errorf("job: %w", Permanent(boom))), true, false},
+		{"innermost transient wins", Permanent(Transient(boom)), false, true},
+		{"canceled", FromContext(context.Canceled), false, true},
+		{"deadline", FromContext(fmt.Errorf("op: %w", context.DeadlineExceeded)), false, true},
+		{"other errors left alone", FromContext(Permanent(boom)), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.wantPermanent {
+				t.Errorf("IsPermanent = %v, want %v", got, tt.wantPermanent)
+			}
+			if got := IsRetryable(tt.err); got != tt.wantRetryable {
+				t.Errorf("IsRetryable = %v, want %v", got, tt.wantRetryable)
+			}
+			if tt.err != nil && !errors.Is(tt.err, boom) && !errors.Is(tt.err, context.Canceled) && !errors.Is(tt.err, context.DeadlineExceeded) {
+				t.Errorf("classification lost the cause: %v", tt.err)
+			}
+		})
+	}
+}
+
+func TestClassifyKeepsMessage(t *testing.T) {
+	if got := Permanent(errors.New("bad input")).Error(); got != "bad input" {
+		t.Errorf("Error() = %q", got)
+	}
+	if IsClassified(errors.New("x")) {
+		t.Error("plain error reported as classified")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 100*time.Millisecond, time.Second
+	tests := []struct {
+		attempt  int
+		base     time.Duration
+		min, max time.Duration
+	}{
+		{0, base, 50 * time.Millisecond, 100 * time.Millisecond}, // treated as 1
+		{1, base, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, base, 100 * time.Millisecond, 200 * time.Millisecond},
+		{4, base, 400 * time.Millisecond, 800 * time.Millisecond},
+		{5, base, 500 * time.Millisecond, time.Second}, // capped
+		{50, base, 500 * time.Millisecond, time.Second},
+		{3, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		for range 100 {
+			if d := Backoff(tt.attempt, tt.base, max); d < tt.min || d > tt.max {
+				t.Fatalf("Backoff(%d, %s, %s) = %s, want within [%s, %s]", tt.attempt, tt.base, max, d, tt.min, tt.max)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package s3x
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// ErrChecksumMismatch means the bytes we hold differ from what S3 holds.
+// It is transient: the next attempt transfers the data again.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksums of a whole file, base64-encoded the way S3 reports them.
+type Checksums struct {
+	SHA256 string
+	CRC32C string
+}
+
+type checksummer struct {
+	sha hash.Hash
+	crc hash.Hash32
+	n   int64
+}
+
+func newChecksummer() *checksummer {
+	return &checksummer{sha: sha256.New(), crc: crc32.New(castagnoli)}
+}
+
+func (c *checksummer) Write(p []byte) (int, error) {
+	c.sha.Write(p)
+	c.crc.Write(p)
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func (c *checksummer) sums() Checksums {
+	return Checksums{
+		SHA256: base64.StdEncoding.EncodeToString(c.sha.Sum(nil)),
+		CRC32C: base64.StdEncoding.EncodeToString(c.crc.Sum(nil)),
+	}
+}
+
+// checksumReader hashes the first size bytes of r.
+func checksumReader(r io.ReaderAt, size int64) (Checksums, error) {
+	c := newChecksummer()
+	if _, err := io.Copy(c, io.NewSectionReader(r, 0, size)); err != nil {
+		return Checksums{}, fmt.Errorf("checksum: %w", err)
+	}
+	return c.sums(), nil
+}
+
+// compositeSHA256 is the checksum S3 reports for a multipart upload made
+// with SHA256 parts: the SHA256 of the concatenated part digests, plus the
+// part count.
+func compositeSHA256(partDigests [][]byte) string {
+	h := sha256.New()
+	for _, d := range partDigests {
+		h.Write(d)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(partDigests))
+}
+
+func isComposite(checksum string) bool {
+	return strings.Contains(checksum, "-")
+}
+
+// verify compares what we hold locally against the object's metadata. Size
+// is always checked; checksums only when S3 reports a full-object value,
+// since stores without checksum support (and composite multipart values)
+// leave nothing to compare against.
+func verify(bucket, key string, info ObjectInfo, size int64, sums Checksums) error {
+	mismatch := func(what, local, remote string) error {
+		return retryx.Transient(fmt.Errorf("%w: s3://%s/%s %s local=%s remote=%s",
+			ErrChecksumMismatch, bucket, key, what, local, remote))
+	}
+
+	if info.Size != size {
+		return mismatch("size", fmt.Sprint(size), fmt.Sprint(info.Size))
+	}
+	if info.ChecksumSHA256 != "" && !isComposite(info.ChecksumSHA256) && info.ChecksumSHA256 != sums.SHA256 {
+		return mismatch("sha256", sums.SHA256, info.ChecksumSHA256)
+	}
+	if info.ChecksumCRC32C != "" && !isComposite(info.ChecksumCRC32C) && info.ChecksumCRC32C != sums.CRC32C {
+		return mismatch("crc32c", sums.CRC32C, info.ChecksumCRC32C)
+	}
+	return nil
+}
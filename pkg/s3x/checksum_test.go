@@ -0,0 +1,135 @@
+package s3x
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+func TestChecksumReader(t *testing.T) {
+	tests := []struct {
+		data       string
+		wantSHA256 string
+		wantCRC32C string
+	}{
+		{"", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", "AAAAAA=="},
+		{"hello world", "uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=", "yZRlqg=="},
+	}
+	for _, tt := range tests {
+		sums, err := checksumReader(strings.NewReader(tt.data+"trailing bytes"), int64(len(tt.data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sums.SHA256 != tt.wantSHA256 || sums.CRC32C != tt.wantCRC32C {
+			t.Errorf("checksumReader(%q) = %+v, want %s %s", tt.data, sums, tt.wantSHA256, tt.wantCRC32C)
+		}
+	}
+}
+
+func TestCompositeSHA256(t *testing.T) {
+	digest := func(s string) []byte {
+		sum := sha256.Sum256([]byte(s))
+		return sum[:]
+	}
+	tests := []struct {
+		name  string
+		parts [][]byte
+		want  string
+	}{
+		{"one part", [][]byte{digest("part1")}, "rm0jz694+0g9hbI7JCGW5IFI7NH7jhb4hv1A+3K3y+0=-1"},
+		{"two parts", [][]byte{digest("part1"), digest("part2")}, "xheoOGWlJSrr1KooTDWywen6ft/oubM2FCHlVKEDMfM=-2"},
+		{"order matters", [][]byte{digest("part2"), digest("part1")}, "0mqaB8Ysm5hFTz3XO9R+xk1985mXyXKLysZvmb6D1sg=-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compositeSHA256(tt.parts); got != tt.want {
+				t.Errorf("compositeSHA256 = %s, want %s", got, tt.want)
+			}
+			if !isComposite(tt.want) {
+				t.Errorf("%s not recognised as composite", tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	sums := Checksums{SHA256: "uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=", CRC32C: "yZRlqg=="}
+	tests := []struct {
+		name    string
+		info    ObjectInfo
+		wantErr bool
+	}{
+		{"size only", ObjectInfo{Size: 11}, false},
+		{"matching checksums", ObjectInfo{Size: 11, ChecksumSHA256: sums.SHA256, ChecksumCRC32C: sums.CRC32C}, false},
+		{"composite values are skipped", ObjectInfo{Size: 11, ChecksumSHA256: "abc=-3", ChecksumCRC32C: "def=-3"}, false},
+		{"size differs", ObjectInfo{Size: 12}, true},
+		{"sha256 differs", ObjectInfo{Size: 11, ChecksumSHA256: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}, true},
+		{"crc32c differs", ObjectInfo{Size: 11, ChecksumCRC32C: "AAAAAA=="}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify("media", "out.mp4", tt.info, 11, sums)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verify = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && (!errors.Is(err, ErrChecksumMismatch) || !retryx.IsRetryable(err)) {
+				t.Errorf("verify error %v is not a retryable checksum mismatch", err)
+			}
+		})
+	}
+}
+
+// fakeS3 stores PUTs but reports a different size on HEAD, the way a
+// corrupted upload would look.
+type fakeS3 struct {
+	mu      sync.Mutex
+	deleted []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodHead:
+		w.Header().Set("Content-Length", "1")
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodDelete:
+		f.mu.Lock()
+		f.deleted = append(f.deleted, r.URL.Path)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestPutDiscardsUnverifiedObject(t *testing.T) {
+	fake := &fakeS3{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	c := testClient(t, Config{Region: "us-east-1", Endpoint: srv.URL, UsePathStyle: true})
+	c.PartRetries = 1
+
+	path := filepath.Join(t.TempDir(), "out.mp4")
+	if err := os.WriteFile(path, []byte("hello world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.PutObjectFromFile(context.Background(), "media", "out.mp4", path, UploadOptions{})
+	if !errors.Is(err, ErrChecksumMismatch) || !retryx.IsRetryable(err) {
+		t.Fatalf("PutObjectFromFile error = %v, want a retryable checksum mismatch", err)
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "/media/out.mp4" {
+		t.Errorf("deleted %v, want the unverified object", fake.deleted)
+	}
+}
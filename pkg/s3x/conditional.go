@@ -0,0 +1,104 @@
+package s3x
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// ErrPreconditionFailed means a conditional write found the key in another
+// state than it asked for: the key already existed (IfNoneMatch) or held a
+// different object (IfMatch). It is permanent; the caller decides what a
+// lost race means.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// Condition makes an upload depend on what is stored under the key. The
+// zero value overwrites unconditionally.
+type Condition struct {
+	IfNoneMatch bool   // only create the key; fail if it exists
+	IfMatch     string // only replace the object with this ETag
+}
+
+func (c Condition) ifNoneMatch() *string {
+	if c.IfNoneMatch {
+		return aws.String("*")
+	}
+	return nil
+}
+
+func (c Condition) ifMatch() *string {
+	if c.IfMatch != "" {
+		return aws.String(c.IfMatch)
+	}
+	return nil
+}
+
+// classifyWrite is classify for conditional writes: a failed precondition
+// becomes ErrPreconditionFailed.
+func classifyWrite(err error) error {
+	if isPreconditionFailed(err) {
+		return retryx.Permanent(fmt.Errorf("%w: %w", ErrPreconditionFailed, err))
+	}
+	return classify(err)
+}
+
+// Stat is Head that reports a missing object as found == false instead of
+// an error.
+func (c *Client) Stat(ctx context.Context, bucket, key string) (info ObjectInfo, found bool, err error) {
+	info, err = c.Head(ctx, bucket, key)
+	if err != nil {
+		if isNotFound(err) {
+			return ObjectInfo{}, false, nil
+		}
+		return ObjectInfo{}, false, err
+	}
+	return info, true, nil
+}
+
+// SameContent reports whether the stored object described by info holds the
+// same bytes as the local file. Objects stored without a SHA256 never
+// match. Multipart checksums are recomputed with this client's part layout,
+// so an object uploaded by another tool may compare as different even when
+// the bytes are equal.
+func (c *Client) SameContent(info ObjectInfo, path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, classify(fmt.Errorf("open file: %w", err))
+	}
+	defer file.Close()
+
+	st, err := file.Stat()
+	if err != nil {
+		return false, classify(fmt.Errorf("stat file: %w", err))
+	}
+	if info.Size != st.Size() || info.ChecksumSHA256 == "" {
+		return false, nil
+	}
+
+	if !isComposite(info.ChecksumSHA256) {
+		sums, err := checksumReader(file, st.Size())
+		if err != nil {
+			return false, err
+		}
+		return sums.SHA256 == info.ChecksumSHA256, nil
+	}
+
+	partSize, nParts := c.partLayout(st.Size())
+	digests := make([][]byte, nParts)
+	for i := range digests {
+		off := int64(i) * partSize
+		if digests[i], err = sectionSHA256(file, off, min(partSize, st.Size()-off)); err != nil {
+			return false, err
+		}
+	}
+	return compositeSHA256(digests) == info.ChecksumSHA256, nil
+}
+
+func isNotFound(err error) bool {
+	var respErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == 404
+}
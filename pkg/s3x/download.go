@@ -0,0 +1,291 @@
+package s3x
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+const DefaultDownloadConcurrency = 4
+
+// ObjectInfo is what HeadObject told us about an object. Checksums are
+// empty when the object was stored without one (or the store does not
+// support them).
+type ObjectInfo struct {
+	Size           int64
+	ETag           string
+	ChecksumSHA256 string
+	ChecksumCRC32C string
+}
+
+// downloadState is persisted next to a file being downloaded so a later
+// attempt can fetch only the ranges that are still missing.
+type downloadState struct {
+	ETag     string `json:"etag"`
+	Size     int64  `json:"size"`
+	PartSize int64  `json:"part_size"`
+	Done     []bool `json:"done"`
+}
+
+func (st *downloadState) complete() bool {
+	for _, d := range st.Done {
+		if !d {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) downloadConcurrency() int {
+	if c.DownloadConcurrency <= 0 {
+		return DefaultDownloadConcurrency
+	}
+	return c.DownloadConcurrency
+}
+
+// Head returns the size and ETag of an object.
+func (c *Client) Head(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	out, err := c.S3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return ObjectInfo{}, classify(fmt.Errorf("s3 head %s/%s: %w", bucket, key, err))
+	}
+	return ObjectInfo{
+		Size:           aws.ToInt64(out.ContentLength),
+		ETag:           aws.ToString(out.ETag),
+		ChecksumSHA256: aws.ToString(out.ChecksumSHA256),
+		ChecksumCRC32C: aws.ToString(out.ChecksumCRC32C),
+	}, nil
+}
+
+// Download fetches the object into w using parallel byte-range requests.
+// Each range is retried on its own, and all ranges are pinned to the ETag
+// seen up front so a concurrent overwrite cannot produce a mixed file. If w
+// can also be read back (like *os.File) the result is verified against the
+// object's size and checksum.
+func (c *Client) Download(ctx context.Context, bucket, key string, w io.WriterAt) (ObjectInfo, error) {
+	info, err := c.Head(ctx, bucket, key)
+	if err != nil {
+		return info, err
+	}
+	st := newDownloadState(info, c.partSize())
+	if err := c.fetchRanges(ctx, bucket, key, w, st, nil); err != nil {
+		return info, err
+	}
+
+	if r, ok := w.(io.ReaderAt); ok {
+		sums, err := checksumReader(r, info.Size)
+		if err != nil {
+			return info, err
+		}
+		return info, verify(bucket, key, info, info.Size, sums)
+	}
+	return info, nil
+}
+
+// DownloadToFile downloads the object to path. Progress is recorded in
+// path+".s3state"; if a previous attempt was interrupted and the object is
+// unchanged, only the missing ranges are fetched. The state file is kept
+// after success so a retry of the surrounding job does not download again;
+// callers own its cleanup along with the file.
+func (c *Client) DownloadToFile(ctx context.Context, bucket, key, path string) (ObjectInfo, error) {
+	info, err := c.Head(ctx, bucket, key)
+	if err != nil {
+		return info, err
+	}
+
+	statePath := path + ".s3state"
+	st, resumed := loadDownloadState(statePath, info, c.partSize())
+	if resumed && st.complete() {
+		if fi, err := os.Stat(path); err == nil && fi.Size() == info.Size {
+			return info, nil
+		}
+		st = newDownloadState(info, c.partSize())
+		resumed = false
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if !resumed {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return info, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(info.Size); err != nil {
+		return info, fmt.Errorf("truncate %s: %w", path, err)
+	}
+	if err := saveDownloadState(statePath, st); err != nil {
+		return info, err
+	}
+
+	save := func(st *downloadState) error { return saveDownloadState(statePath, st) }
+	if err := c.fetchRanges(ctx, bucket, key, f, st, save); err != nil {
+		return info, err
+	}
+	if err := f.Sync(); err != nil {
+		return info, fmt.Errorf("sync %s: %w", path, err)
+	}
+
+	rf, err := os.Open(path)
+	if err != nil {
+		return info, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer rf.Close()
+	sums, err := checksumReader(rf, info.Size)
+	if err != nil {
+		return info, err
+	}
+	if err := verify(bucket, key, info, info.Size, sums); err != nil {
+		// Whatever went wrong is in the bytes on disk; start over next time.
+		_ = os.Remove(statePath)
+		return info, err
+	}
+	return info, nil
+}
+
+func newDownloadState(info ObjectInfo, partSize int64) *downloadState {
+	n := int((info.Size + partSize - 1) / partSize)
+	return &downloadState{ETag: info.ETag, Size: info.Size, PartSize: partSize, Done: make([]bool, n)}
+}
+
+// loadDownloadState returns the saved state if it still describes the same
+// object, otherwise a fresh one.
+func loadDownloadState(path string, info ObjectInfo, partSize int64) (*downloadState, bool) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return newDownloadState(info, partSize), false
+	}
+	var st downloadState
+	if err := json.Unmarshal(raw, &st); err != nil ||
+		st.ETag != info.ETag || st.Size != info.Size || st.PartSize <= 0 ||
+		len(st.Done) != int((st.Size+st.PartSize-1)/st.PartSize) {
+		return newDownloadState(info, partSize), false
+	}
+	return &st, true
+}
+
+func saveDownloadState(path string, st *downloadState) error {
+	raw, _ := json.Marshal(st)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return fmt.Errorf("write download state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write download state: %w", err)
+	}
+	return nil
+}
+
+// fetchRanges downloads every range not yet marked done, calling save after
+// each one completes.
+func (c *Client) fetchRanges(
+	ctx context.Context,
+	bucket, key string,
+	w io.WriterAt,
+	st *downloadState,
+	save func(*downloadState) error,
+) error {
+	fctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	next := make(chan int)
+
+	for i := 0; i < c.downloadConcurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range next {
+				err := c.withPartRetries(fctx, func(rctx context.Context) error {
+					return c.fetchRange(rctx, bucket, key, w, st, idx)
+				})
+
+				mu.Lock()
+				if err == nil {
+					st.Done[idx] = true
+					if save != nil {
+						err = save(st)
+					}
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for idx, done := range st.Done {
+		if done {
+			continue
+		}
+		select {
+		case next <- idx:
+		case <-fctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = classify(ctx.Err())
+	}
+	return firstErr
+}
+
+func (c *Client) fetchRange(ctx context.Context, bucket, key string, w io.WriterAt, st *downloadState, idx int) error {
+	start := int64(idx) * st.PartSize
+	end := min(start+st.PartSize, st.Size) - 1
+
+	out, err := c.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(key),
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		IfMatch: aws.String(st.ETag),
+	})
+	if err != nil {
+		err = fmt.Errorf("s3 get %s/%s range %d-%d: %w", bucket, key, start, end, err)
+		if isPreconditionFailed(err) {
+			// The object changed under us; the next attempt starts over.
+			return retryx.Transient(err)
+		}
+		return classify(err)
+	}
+	defer out.Body.Close()
+
+	n, err := io.Copy(io.NewOffsetWriter(w, start), out.Body)
+	if err != nil {
+		return classify(fmt.Errorf("range copy: %w", err))
+	}
+	if want := end - start + 1; n != want {
+		return retryx.Transient(fmt.Errorf("s3 get %s/%s range %d-%d: short read %d of %d bytes", bucket, key, start, end, n, want))
+	}
+	return nil
+}
+
+func isPreconditionFailed(err error) bool {
+	var respErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == 412
+}
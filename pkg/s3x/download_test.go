@@ -0,0 +1,67 @@
+package s3x
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDownloadStateResume(t *testing.T) {
+	info := ObjectInfo{Size: 10, ETag: `"v1"`}
+	saved := &downloadState{ETag: info.ETag, Size: info.Size, PartSize: 4, Done: []bool{true, false, true}}
+
+	tests := []struct {
+		name       string
+		raw        string // state file contents; "" for no file
+		info       ObjectInfo
+		wantResume bool
+		wantDone   []bool
+	}{
+		{"no state", "", info, false, []bool{false, false, false, false, false}},
+		{"same object", "saved", info, true, []bool{true, false, true}},
+		{"object replaced", "saved", ObjectInfo{Size: 10, ETag: `"v2"`}, false, []bool{false, false, false, false, false}},
+		{"object resized", "saved", ObjectInfo{Size: 12, ETag: `"v1"`}, false, []bool{false, false, false, false, false, false}},
+		{"corrupt", "{not json", info, false, []bool{false, false, false, false, false}},
+		{"inconsistent", `{"etag":"\"v1\"","size":10,"part_size":4,"done":[true]}`, info, false, []bool{false, false, false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "in.mp4.state")
+			switch tt.raw {
+			case "":
+			case "saved":
+				if err := saveDownloadState(path, saved); err != nil {
+					t.Fatal(err)
+				}
+			default:
+				if err := os.WriteFile(path, []byte(tt.raw), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			st, resumed := loadDownloadState(path, tt.info, 2)
+			if resumed != tt.wantResume || !slices.Equal(st.Done, tt.wantDone) {
+				t.Errorf("loadDownloadState = %v %v, want %v %v", st.Done, resumed, tt.wantDone, tt.wantResume)
+			}
+			if st.complete() {
+				t.Error("partial state reported complete")
+			}
+		})
+	}
+}
+
+func TestDownloadStateComplete(t *testing.T) {
+	tests := []struct {
+		done []bool
+		want bool
+	}{
+		{nil, true}, // empty object
+		{[]bool{true, true}, true},
+		{[]bool{true, false}, false},
+	}
+	for _, tt := range tests {
+		if got := (&downloadState{Done: tt.done}).complete(); got != tt.want {
+			t.Errorf("complete(%v) = %v, want %v", tt.done, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package s3x
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/aws/smithy-go"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// Error codes that will fail the same way no matter how often we retry.
+var permanentCodes = map[string]bool{
+	"NoSuchKey":                    true,
+	"NoSuchBucket":                 true,
+	"NotFound":                     true,
+	"AccessDenied":                 true,
+	"InvalidAccessKeyId":           true,
+	"SignatureDoesNotMatch":        true,
+	"InvalidBucketName":            true,
+	"InvalidObjectState":           true,
+	"PermanentRedirect":            true,
+	"AuthorizationHeaderMalformed": true,
+	"EntityTooLarge":               true,
+	"InvalidArgument":              true,
+	"InvalidRange":                 true,
+}
+
+// Error codes for 4xx responses that clear up on their own: an expired
+// session token is replaced when the credentials refresh.
+var transientCodes = map[string]bool{
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+}
+
+// classify marks an S3 SDK error as permanent or transient.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return retryx.Permanent(err)
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case permanentCodes[apiErr.ErrorCode()]:
+			return retryx.Permanent(err)
+		case transientCodes[apiErr.ErrorCode()]:
+			return retryx.Transient(err)
+		case apiErr.ErrorCode() == "ConditionalRequestConflict":
+			// 409: another conditional write to the key is in flight
+			return retryx.Transient(err)
+		}
+	}
+
+	var respErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &respErr) {
+		code := respErr.HTTPStatusCode()
+		if code >= 400 && code < 500 && code != 408 && code != 429 {
+			return retryx.Permanent(err)
+		}
+	}
+
+	// Throttling, 5xx, timeouts and dropped connections
+	return retryx.Transient(err)
+}
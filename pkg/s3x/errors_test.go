@@ -0,0 +1,54 @@
+package s3x
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// statusError is a response error that only carries an HTTP status.
+type statusError int
+
+func (e statusError) Error() string       { return fmt.Sprintf("status %d", int(e)) }
+func (e statusError) HTTPStatusCode() int { return int(e) }
+
+func TestClassify(t *testing.T) {
+	api := func(code string) error { return &smithy.GenericAPIError{Code: code, Message: "x"} }
+	tests := []struct {
+		name          string
+		err           error
+		wantPermanent bool
+	}{
+		{"missing key", api("NoSuchKey"), true},
+		{"access denied", api("AccessDenied"), true},
+		{"expired token", api("ExpiredToken"), false},
+		{"expired token exception", api("ExpiredTokenException"), false},
+		{"conditional conflict", api("ConditionalRequestConflict"), false},
+		{"unknown code", api("SlowDown"), false},
+		{"local file missing", fmt.Errorf("open: %w", fs.ErrNotExist), true},
+		{"local permission", fmt.Errorf("open: %w", fs.ErrPermission), true},
+		{"403", statusError(403), true},
+		{"408", statusError(408), false},
+		{"429", statusError(429), false},
+		{"503", statusError(503), false},
+		{"dropped connection", errors.New("connection reset by peer"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classify(tt.err)
+			if !retryx.IsClassified(err) {
+				t.Fatalf("classify(%v) left the error unclassified", tt.err)
+			}
+			if got := retryx.IsPermanent(err); got != tt.wantPermanent {
+				t.Errorf("IsPermanent = %v, want %v", got, tt.wantPermanent)
+			}
+		})
+	}
+	if classify(nil) != nil {
+		t.Error("classify(nil) != nil")
+	}
+}
@@ -0,0 +1,66 @@
+package s3x
+
+import (
+	"context"
+	"sync"
+)
+
+// Pool hands out one shared Client per region/endpoint/role, created
+// lazily on first use. Clients are safe for concurrent use, so jobs share
+// credential caches and HTTP connections instead of rebuilding them.
+type Pool struct {
+	mu      sync.Mutex
+	clients map[poolKey]*poolEntry
+}
+
+type poolKey struct {
+	region   string
+	endpoint string
+	roleARN  string
+}
+
+type poolEntry struct {
+	ready  chan struct{}
+	client *Client
+	err    error
+}
+
+func NewPool() *Pool {
+	return &Pool{clients: make(map[poolKey]*poolEntry)}
+}
+
+// Get returns the client for c, creating it if needed. Concurrent callers
+// asking for the same key wait for a single construction. A failed
+// construction is not cached, so the next call tries again.
+func (p *Pool) Get(ctx context.Context, c Config) (*Client, error) {
+	key := poolKey{region: c.Region, endpoint: c.Endpoint, roleARN: c.RoleARN}
+
+	p.mu.Lock()
+	e, ok := p.clients[key]
+	if !ok {
+		e = &poolEntry{ready: make(chan struct{})}
+		p.clients[key] = e
+	}
+	p.mu.Unlock()
+
+	if ok {
+		select {
+		case <-e.ready:
+			return e.client, e.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// Build outside the lock; loading AWS config can hit the network.
+	// context.WithoutCancel keeps one caller's cancellation from failing
+	// everyone waiting on the same entry.
+	e.client, e.err = NewFromConfig(context.WithoutCancel(ctx), c)
+	if e.err != nil {
+		p.mu.Lock()
+		delete(p.clients, key)
+		p.mu.Unlock()
+	}
+	close(e.ready)
+	return e.client, e.err
+}
@@ -0,0 +1,85 @@
+package s3x
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestPoolGet(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	ctx := context.Background()
+	base := Config{Region: "eu-west-1", AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+
+	p := NewPool()
+	first, err := p.Get(ctx, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		change   func(c *Config)
+		wantSame bool
+	}{
+		{"same config", func(c *Config) {}, true},
+		{"tuning is not part of the key", func(c *Config) { c.Concurrency = 9 }, true},
+		{"region", func(c *Config) { c.Region = "us-east-1" }, false},
+		{"endpoint", func(c *Config) { c.Endpoint = "http://localhost:9000" }, false},
+		{"role", func(c *Config) { c.RoleARN = "arn:aws:iam::123456789012:role/media" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := base
+			tt.change(&c)
+			got, err := p.Get(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same := got == first; same != tt.wantSame {
+				t.Errorf("same client = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestPoolConcurrentGet(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	c := Config{Region: "eu-west-1", AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+	p := NewPool()
+
+	clients := make([]*Client, 16)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clients[i], _ = p.Get(context.Background(), c)
+		}()
+	}
+	wg.Wait()
+	for i, client := range clients {
+		if client == nil || client != clients[0] {
+			t.Fatalf("caller %d got client %p, want the shared %p", i, client, clients[0])
+		}
+	}
+}
+
+func TestPoolRetriesFailedConstruction(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	c := Config{Region: "eu-west-1", AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+	p := NewPool()
+
+	t.Setenv("AWS_PROFILE", "missing-profile")
+	if _, err := p.Get(context.Background(), c); err == nil {
+		t.Fatal("Get with a missing profile succeeded")
+	}
+	t.Setenv("AWS_PROFILE", "")
+	if client, err := p.Get(context.Background(), c); err != nil || client == nil {
+		t.Fatalf("Get after the failure = %v, %v; want a client", client, err)
+	}
+}
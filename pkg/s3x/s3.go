@@ -2,24 +2,106 @@ package s3x
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	appconfig "github.com/yangjie500/media_extractor_ffmpeg/pkg/config"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
 )
 
 type Client struct {
 	S3 *s3.Client
+
+	// Multipart upload tuning; zero values fall back to the defaults.
+	PartSize    int64         // bytes per part, at least 5 MiB
+	Concurrency int           // parts uploaded in parallel
+	PartRetries int           // attempts per part on transient errors
+	PartTimeout time.Duration // per-request timeout for a single part
+
+	// Ranged downloads reuse PartSize as the range size.
+	DownloadConcurrency int
+}
+
+// Config describes how to reach the object store. The zero value (plus a
+// region) means AWS S3 with the default credential chain.
+type Config struct {
+	Region string
+
+	// S3-compatible stores (MinIO, LocalStack, on-prem)
+	Endpoint           string // e.g. http://localhost:9000
+	UsePathStyle       bool   // bucket in the path instead of the host name
+	InsecureSkipVerify bool   // accept self-signed TLS certificates
+
+	// Static credentials; when empty the default AWS chain is used
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Optional role assumed on top of the credentials above
+	RoleARN string
+
+	// Transfer tuning, see Client
+	PartSize            int64
+	Concurrency         int
+	DownloadConcurrency int
+	PartTimeout         time.Duration
+}
+
+// ConfigFrom builds the S3 config from the application settings, so the
+// consumer and every command reach the same store the same way.
+func ConfigFrom(app appconfig.Config, region string) Config {
+	if region == "" {
+		region = app.Region
+	}
+	return Config{
+		Region:              region,
+		Endpoint:            app.S3Endpoint,
+		UsePathStyle:        app.S3UsePathStyle,
+		InsecureSkipVerify:  app.S3InsecureSkipVerify,
+		AccessKeyID:         app.S3AccessKeyID,
+		SecretAccessKey:     app.S3SecretAccessKey,
+		SessionToken:        app.S3SessionToken,
+		RoleARN:             app.S3RoleARN,
+		PartSize:            int64(app.S3PartSizeMB) << 20,
+		Concurrency:         app.S3UploadConcurrency,
+		DownloadConcurrency: app.S3DownloadConcurrency,
+		PartTimeout:         app.S3PartTimeout,
+	}
 }
 
 func New(ctx context.Context, region string, opts ...func(*config.LoadOptions) error) (*Client, error) {
+	return NewFromConfig(ctx, Config{Region: region}, opts...)
+}
+
+func NewFromConfig(ctx context.Context, c Config, opts ...func(*config.LoadOptions) error) (*Client, error) {
 	lo := []func(*config.LoadOptions) error{}
-	if region != "" {
-		lo = append(lo, config.WithRegion(region))
+	if c.Region != "" {
+		lo = append(lo, config.WithRegion(c.Region))
+	}
+	if c.AccessKeyID != "" {
+		lo = append(lo, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, c.SessionToken)))
+	}
+	if c.InsecureSkipVerify {
+		hc := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{}
+			}
+			tr.TLSClientConfig.InsecureSkipVerify = true
+		})
+		lo = append(lo, config.WithHTTPClient(hc))
 	}
 	lo = append(lo, opts...)
 
@@ -27,54 +109,184 @@ func New(ctx context.Context, region string, opts ...func(*config.LoadOptions) e
 	if err != nil {
 		return nil, fmt.Errorf("load aws config: %w", err)
 	}
+	if c.RoleARN != "" {
+		cfg.Credentials = aws.NewCredentialsCache(
+			stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), c.RoleARN))
+	}
 
-	return &Client{S3: s3.NewFromConfig(cfg)}, nil
-}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if c.Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.Endpoint)
+		}
+		o.UsePathStyle = c.UsePathStyle
+	})
 
-func (c *Client) GetObjectToWriter(ctx context.Context, bucket, key string, w io.Writer) error {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-	defer cancel()
+	return &Client{
+		S3:                  client,
+		PartSize:            c.PartSize,
+		Concurrency:         c.Concurrency,
+		DownloadConcurrency: c.DownloadConcurrency,
+		PartTimeout:         c.PartTimeout,
+	}, nil
+}
 
+// Open streams the object. The caller closes the body.
+func (c *Client) Open(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
 	out, err := c.S3.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
+	if err != nil {
+		return nil, classify(fmt.Errorf("s3 get %s/%s: %w", bucket, key, err))
+	}
+	return out.Body, nil
+}
 
+// Delete removes the object. Deleting a missing key is not an error.
+func (c *Client) Delete(ctx context.Context, bucket, key string) error {
+	_, err := c.S3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
 	if err != nil {
-		return fmt.Errorf("s3 get %s/%s: %w", bucket, key, err)
+		return classify(fmt.Errorf("s3 delete %s/%s: %w", bucket, key, err))
 	}
+	return nil
+}
+
+// MaxPresignTTL is the longest validity SigV4 allows for a presigned URL.
+const MaxPresignTTL = 7 * 24 * time.Hour
 
-	if _, err := io.Copy(w, out.Body); err != nil {
-		return fmt.Errorf("stream copy: %w", err)
+// PresignGet returns a URL that downloads the object without credentials
+// until it expires. A URL signed with temporary credentials (assumed role,
+// instance profile) stops working when they do, even before ttl is up.
+func (c *Client) PresignGet(ctx context.Context, bucket, key string, ttl time.Duration) (url string, expires time.Time, err error) {
+	if ttl <= 0 || ttl > MaxPresignTTL {
+		return "", time.Time{}, retryx.Permanent(fmt.Errorf("presign ttl %s out of range (0, %s]", ttl, MaxPresignTTL))
 	}
+	expires = time.Now().Add(ttl)
+	req, err := s3.NewPresignClient(c.S3).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", time.Time{}, classify(fmt.Errorf("s3 presign %s/%s: %w", bucket, key, err))
+	}
+	return req.URL, expires, nil
+}
 
-	return nil
+// GetObjectToWriter copies the object into w. Writers that support
+// WriteAt (such as *os.File) get a parallel ranged download; anything else
+// is streamed with a single GetObject.
+func (c *Client) GetObjectToWriter(ctx context.Context, bucket, key string, w io.Writer) error {
+	if wa, ok := w.(io.WriterAt); ok {
+		_, err := c.Download(ctx, bucket, key, wa)
+		return err
+	}
+
+	out, err := c.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+
+	if err != nil {
+		return classify(fmt.Errorf("s3 get %s/%s: %w", bucket, key, err))
+	}
+	defer out.Body.Close()
+
+	sum := newChecksummer()
+	if _, err := io.Copy(io.MultiWriter(w, sum), out.Body); err != nil {
+		return classify(fmt.Errorf("stream copy: %w", err))
+	}
+
+	info := ObjectInfo{
+		Size:           aws.ToInt64(out.ContentLength),
+		ChecksumSHA256: aws.ToString(out.ChecksumSHA256),
+		ChecksumCRC32C: aws.ToString(out.ChecksumCRC32C),
+	}
+	return verify(bucket, key, info, sum.n, sum.sums())
 }
 
-func (c *Client) PutObjectFromFile(ctx context.Context, bucket, key string, filepath, contentType string) error {
+// PutObjectFromFile uploads a local file. Files larger than one part go
+// through a parallel multipart upload, so outputs of any size (up to the
+// 5 TB S3 limit) can be stored. The SHA256 of the data is sent along so S3
+// rejects corrupted bodies, and the stored object is checked afterwards.
+// When opts.Condition does not hold nothing is written and the error wraps
+// ErrPreconditionFailed.
+func (c *Client) PutObjectFromFile(ctx context.Context, bucket, key string, filepath string, opts UploadOptions) (UploadResult, error) {
+	if err := opts.validate(); err != nil {
+		return UploadResult{}, err
+	}
+
 	file, err := os.Open(filepath)
 	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+		return UploadResult{}, classify(fmt.Errorf("open file: %w", err))
 	}
 	defer file.Close()
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-	defer cancel()
+	st, err := file.Stat()
+	if err != nil {
+		return UploadResult{}, classify(fmt.Errorf("stat file: %w", err))
+	}
 
-	input := &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Body:   file,
+	sums, err := checksumReader(file, st.Size())
+	if err != nil {
+		return UploadResult{}, err
 	}
 
-	if contentType != "" {
-		input.ContentType = aws.String(contentType)
+	if st.Size() > c.partSize() {
+		res, err := c.multipartUpload(ctx, bucket, key, file, st.Size(), opts)
+		if err != nil {
+			return res, err
+		}
+		res.ChecksumSHA256 = sums.SHA256
+		return res, nil
 	}
 
-	if _, err := c.S3.PutObject(ctx, input); err != nil {
-		return fmt.Errorf("s3 put %s/%s: %w", bucket, key, err)
+	return c.putObject(ctx, bucket, key, func() io.Reader {
+		return io.NewSectionReader(file, 0, st.Size())
+	}, st.Size(), sums, opts)
+}
+
+// putObject stores size bytes with a single PutObject, then checks the
+// stored object against sums. body is called once per attempt.
+func (c *Client) putObject(ctx context.Context, bucket, key string, body func() io.Reader, size int64, sums Checksums, opts UploadOptions) (UploadResult, error) {
+	var etag string
+	err := c.withPartRetries(ctx, func(ctx context.Context) error {
+		out, err := c.S3.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:               aws.String(bucket),
+			Key:                  aws.String(key),
+			Body:                 body(),
+			ContentLength:        aws.Int64(size),
+			ChecksumSHA256:       aws.String(sums.SHA256),
+			ContentType:          optional(opts.ContentType),
+			CacheControl:         optional(opts.CacheControl),
+			Metadata:             opts.metadata(),
+			Tagging:              opts.tagging(),
+			StorageClass:         types.StorageClass(opts.StorageClass),
+			ServerSideEncryption: types.ServerSideEncryption(opts.SSE),
+			SSEKMSKeyId:          optional(opts.SSEKMSKeyID),
+			IfNoneMatch:          opts.Condition.ifNoneMatch(),
+			IfMatch:              opts.Condition.ifMatch(),
+		})
+		if err != nil {
+			return classifyWrite(fmt.Errorf("s3 put %s/%s: %w", bucket, key, err))
+		}
+		etag = aws.ToString(out.ETag)
+		return nil
+	})
+	if err != nil {
+		return UploadResult{}, err
 	}
 
-	return nil
+	info, err := c.Head(ctx, bucket, key)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	if err := verify(bucket, key, info, size, sums); err != nil {
+		return UploadResult{}, c.discard(bucket, key, err)
+	}
 
+	return UploadResult{ETag: etag, Size: size, ChecksumSHA256: sums.SHA256}, nil
 }
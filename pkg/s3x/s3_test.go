@@ -1 +1,134 @@
 package s3x
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	appconfig "github.com/yangjie500/media_extractor_ffmpeg/pkg/config"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// testClient builds a client with static credentials, so nothing is read
+// from the environment or the network.
+func testClient(t *testing.T, c Config) *Client {
+	t.Helper()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	c.AccessKeyID, c.SecretAccessKey = "AKIDEXAMPLE", "secret"
+	client, err := NewFromConfig(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestNewFromConfigEndpoint(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        Config
+		wantEndpoint  string
+		wantURLPrefix string
+	}{
+		{
+			name:          "aws",
+			config:        Config{Region: "eu-west-1"},
+			wantURLPrefix: "https://media.s3.eu-west-1.amazonaws.com/in/v.mp4?",
+		},
+		{
+			name:          "minio path style",
+			config:        Config{Region: "us-east-1", Endpoint: "http://localhost:9000", UsePathStyle: true},
+			wantEndpoint:  "http://localhost:9000",
+			wantURLPrefix: "http://localhost:9000/media/in/v.mp4?",
+		},
+		{
+			name:          "custom endpoint, virtual hosts",
+			config:        Config{Region: "local", Endpoint: "https://s3.example.internal"},
+			wantEndpoint:  "https://s3.example.internal",
+			wantURLPrefix: "https://media.s3.example.internal/in/v.mp4?",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testClient(t, tt.config)
+			opts := c.S3.Options()
+			if got := aws.ToString(opts.BaseEndpoint); got != tt.wantEndpoint {
+				t.Errorf("endpoint = %q, want %q", got, tt.wantEndpoint)
+			}
+			if opts.UsePathStyle != tt.config.UsePathStyle {
+				t.Errorf("path style = %v, want %v", opts.UsePathStyle, tt.config.UsePathStyle)
+			}
+			url, _, err := c.PresignGet(context.Background(), "media", "in/v.mp4", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(url, tt.wantURLPrefix) {
+				t.Errorf("presigned URL %s, want prefix %s", url, tt.wantURLPrefix)
+			}
+		})
+	}
+}
+
+func TestConfigFrom(t *testing.T) {
+	app := appconfig.Config{
+		Region:         "eu-west-1",
+		S3Endpoint:     "http://localhost:9000",
+		S3UsePathStyle: true,
+		S3PartSizeMB:   8,
+	}
+	tests := []struct {
+		region, want string
+	}{
+		{"", "eu-west-1"},
+		{"ap-south-1", "ap-south-1"},
+	}
+	for _, tt := range tests {
+		c := ConfigFrom(app, tt.region)
+		if c.Region != tt.want || c.Endpoint != app.S3Endpoint || !c.UsePathStyle || c.PartSize != 8<<20 {
+			t.Errorf("ConfigFrom(%q) = %+v", tt.region, c)
+		}
+	}
+}
+
+func TestPresignGet(t *testing.T) {
+	c := testClient(t, Config{Region: "eu-west-1"})
+	tests := []struct {
+		ttl         time.Duration
+		wantExpires string
+		wantErr     bool
+	}{
+		{time.Minute, "60", false},
+		{MaxPresignTTL, "604800", false},
+		{0, "", true},
+		{-time.Minute, "", true},
+		{MaxPresignTTL + time.Second, "", true},
+	}
+	for _, tt := range tests {
+		before := time.Now()
+		raw, expires, err := c.PresignGet(context.Background(), "media", "out/v.mp4", tt.ttl)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("PresignGet(%s) error = %v, want error %v", tt.ttl, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			if !retryx.IsPermanent(err) {
+				t.Errorf("PresignGet(%s): %v is not permanent", tt.ttl, err)
+			}
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := u.Query().Get("X-Amz-Expires"); got != tt.wantExpires {
+			t.Errorf("PresignGet(%s): X-Amz-Expires = %s, want %s", tt.ttl, got, tt.wantExpires)
+		}
+		if expires.Before(before.Add(tt.ttl)) || expires.After(time.Now().Add(tt.ttl)) {
+			t.Errorf("PresignGet(%s): expires %s, want about %s", tt.ttl, expires, before.Add(tt.ttl))
+		}
+	}
+}
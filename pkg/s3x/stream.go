@@ -0,0 +1,139 @@
+package s3x
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// UploadStream uploads everything read from r, for outputs whose size is
+// not known up front (e.g. ffmpeg writing to a pipe). Parts are buffered in
+// memory, at most Concurrency+1 of them at a time, and retried on their
+// own; a stream shorter than one part goes up as a single PutObject. An
+// error from r aborts the upload and is returned wrapped.
+func (c *Client) UploadStream(ctx context.Context, bucket, key string, r io.Reader, opts UploadOptions) (UploadResult, error) {
+	if err := opts.validate(); err != nil {
+		return UploadResult{}, err
+	}
+
+	partSize := c.partSize()
+	whole := sha256.New()
+	read := func(buf []byte) (int, bool, error) {
+		n, err := io.ReadFull(r, buf)
+		whole.Write(buf[:n])
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			return n, true, nil
+		case err != nil:
+			return n, false, fmt.Errorf("read upload stream: %w", err)
+		}
+		return n, false, nil
+	}
+
+	first := make([]byte, partSize)
+	n, eof, err := read(first)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	if eof {
+		return c.putBytes(ctx, bucket, key, first[:n], opts)
+	}
+
+	mpu, err := c.startMultipart(ctx, bucket, key, opts)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	uctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+		size     int64
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+
+	// Free buffers; taking one blocks until a part upload returns it.
+	buffers := make(chan []byte, c.concurrency()+1)
+	for range c.concurrency() {
+		buffers <- make([]byte, partSize)
+	}
+
+	buf, length := first, n
+	for part := int32(1); ; part++ {
+		if part > maxParts {
+			fail(retryx.Permanent(fmt.Errorf("stream to s3://%s/%s exceeds %d parts of %d bytes", bucket, key, maxParts, partSize)))
+			break
+		}
+		data := buf[:length]
+		size += int64(length)
+
+		digest := sha256.Sum256(data)
+		wg.Add(1)
+		go func(n int32, data []byte) {
+			defer wg.Done()
+			err := mpu.uploadPart(uctx, n, int64(len(data)), digest[:], func() io.Reader {
+				return bytes.NewReader(data)
+			})
+			if err != nil {
+				fail(err)
+			}
+			buffers <- data[:cap(data)]
+		}(part, data)
+
+		if eof {
+			break
+		}
+		select {
+		case buf = <-buffers:
+		case <-uctx.Done():
+		}
+		if uctx.Err() != nil {
+			break
+		}
+		if length, eof, err = read(buf); err != nil {
+			fail(err)
+			break
+		}
+		if length == 0 {
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = classify(ctx.Err())
+	}
+	if firstErr != nil {
+		return UploadResult{}, mpu.abort(firstErr)
+	}
+
+	res, err := mpu.complete(ctx, size, opts.Condition)
+	if err != nil {
+		return res, err
+	}
+	res.ChecksumSHA256 = base64.StdEncoding.EncodeToString(whole.Sum(nil))
+	return res, nil
+}
+
+// putBytes stores a small, fully buffered object with a single PutObject.
+func (c *Client) putBytes(ctx context.Context, bucket, key string, data []byte, opts UploadOptions) (UploadResult, error) {
+	sum := newChecksummer()
+	sum.Write(data)
+	return c.putObject(ctx, bucket, key, func() io.Reader { return bytes.NewReader(data) }, int64(len(data)), sum.sums(), opts)
+}
@@ -0,0 +1,369 @@
+package s3x
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+const (
+	MinPartSize        = 5 << 20
+	DefaultPartSize    = 16 << 20
+	DefaultConcurrency = 4
+	DefaultPartRetries = 3
+	DefaultPartTimeout = 5 * time.Minute
+
+	maxParts = 10000
+)
+
+// UploadOptions are the object settings sent along with an upload. Zero
+// values leave the bucket defaults in place.
+type UploadOptions struct {
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string // x-amz-meta-*, keys are lower-cased by S3
+	Tags         map[string]string // at most 10
+	StorageClass string            // e.g. STANDARD_IA, INTELLIGENT_TIERING
+	SSE          string            // AES256 (SSE-S3) or aws:kms (SSE-KMS)
+	SSEKMSKeyID  string            // only with SSE aws:kms; empty uses the AWS managed key
+	Condition    Condition
+}
+
+const maxTags = 10
+
+func (o UploadOptions) validate() error {
+	if len(o.Tags) > maxTags {
+		return retryx.Permanent(fmt.Errorf("s3 allows at most %d object tags, got %d", maxTags, len(o.Tags)))
+	}
+	if o.SSEKMSKeyID != "" && o.SSE != string(types.ServerSideEncryptionAwsKms) {
+		return retryx.Permanent(fmt.Errorf("an SSE-KMS key ID requires SSE %q", types.ServerSideEncryptionAwsKms))
+	}
+	return nil
+}
+
+// tagging encodes the tags as the URL query string S3 expects.
+func (o UploadOptions) tagging() *string {
+	if len(o.Tags) == 0 {
+		return nil
+	}
+	q := url.Values{}
+	for k, v := range o.Tags {
+		q.Set(k, v)
+	}
+	return aws.String(q.Encode())
+}
+
+// metadata returns the user metadata safe to send as HTTP headers: values
+// with non-ASCII characters (e.g. from object keys) are percent-encoded.
+func (o UploadOptions) metadata() map[string]string {
+	if len(o.Metadata) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(o.Metadata))
+	for k, v := range o.Metadata {
+		if strings.ContainsFunc(v, func(r rune) bool { return r > unicode.MaxASCII || unicode.IsControl(r) }) {
+			v = url.PathEscape(v)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func optional(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return aws.String(v)
+}
+
+// UploadResult describes the stored object.
+type UploadResult struct {
+	ETag           string
+	Size           int64
+	Parts          int    // 0 for a single PutObject
+	ChecksumSHA256 string // of the whole object, base64
+}
+
+func (c *Client) partSize() int64 {
+	if c.PartSize < MinPartSize {
+		if c.PartSize <= 0 {
+			return DefaultPartSize
+		}
+		return MinPartSize
+	}
+	return c.PartSize
+}
+
+func (c *Client) concurrency() int {
+	if c.Concurrency <= 0 {
+		return DefaultConcurrency
+	}
+	return c.Concurrency
+}
+
+// withPartRetries runs fn with a per-attempt timeout, retrying transient
+// failures with backoff.
+func (c *Client) withPartRetries(ctx context.Context, fn func(ctx context.Context) error) error {
+	attempts := c.PartRetries
+	if attempts <= 0 {
+		attempts = DefaultPartRetries
+	}
+	timeout := c.PartTimeout
+	if timeout <= 0 {
+		timeout = DefaultPartTimeout
+	}
+
+	var err error
+	for i := 1; i <= attempts; i++ {
+		pctx, cancel := context.WithTimeout(ctx, timeout)
+		err = fn(pctx)
+		cancel()
+		if err == nil || retryx.IsPermanent(err) || ctx.Err() != nil {
+			break
+		}
+		if i < attempts {
+			select {
+			case <-time.After(retryx.Backoff(i, 200*time.Millisecond, 5*time.Second)):
+			case <-ctx.Done():
+				return classify(ctx.Err())
+			}
+		}
+	}
+	return err
+}
+
+// partLayout splits size bytes into parts, growing the part size when the
+// configured one would need more than S3's 10,000 parts.
+func (c *Client) partLayout(size int64) (partSize int64, nParts int) {
+	partSize = c.partSize()
+	for (size+partSize-1)/partSize > maxParts {
+		partSize *= 2
+	}
+	return partSize, int((size + partSize - 1) / partSize)
+}
+
+// multipartUpload uploads file in parallel parts. Failed parts are retried
+// on their own; if any part still fails the upload is aborted so no orphan
+// parts are left billing in the bucket. opts.Condition is checked when the
+// upload is completed, so a lost race costs the parts but never the
+// existing object.
+func (c *Client) multipartUpload(ctx context.Context, bucket, key string, file *os.File, size int64, opts UploadOptions) (UploadResult, error) {
+	partSize, nParts := c.partLayout(size)
+
+	mpu, err := c.startMultipart(ctx, bucket, key, opts)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	uctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	next := make(chan int32)
+
+	for w := 0; w < c.concurrency() && w < nParts; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				off := int64(n-1) * partSize
+				length := min(partSize, size-off)
+
+				digest, err := sectionSHA256(file, off, length)
+				if err == nil {
+					err = mpu.uploadPart(uctx, n, length, digest, func() io.Reader {
+						return io.NewSectionReader(file, off, length)
+					})
+				}
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for n := 1; n <= nParts; n++ {
+		select {
+		case next <- int32(n):
+		case <-uctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = classify(ctx.Err())
+	}
+	if firstErr != nil {
+		return UploadResult{}, mpu.abort(firstErr)
+	}
+	return mpu.complete(ctx, size, opts.Condition)
+}
+
+// multipart is one multipart upload in progress. Parts can be uploaded from
+// several goroutines; it ends with complete or abort.
+type multipart struct {
+	c           *Client
+	bucket, key string
+	id          *string
+
+	mu      sync.Mutex
+	parts   []types.CompletedPart
+	digests map[int32][]byte
+}
+
+func (c *Client) startMultipart(ctx context.Context, bucket, key string, opts UploadOptions) (*multipart, error) {
+	out, err := c.S3.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		ChecksumAlgorithm:    types.ChecksumAlgorithmSha256,
+		ContentType:          optional(opts.ContentType),
+		CacheControl:         optional(opts.CacheControl),
+		Metadata:             opts.metadata(),
+		Tagging:              opts.tagging(),
+		StorageClass:         types.StorageClass(opts.StorageClass),
+		ServerSideEncryption: types.ServerSideEncryption(opts.SSE),
+		SSEKMSKeyId:          optional(opts.SSEKMSKeyID),
+	})
+	if err != nil {
+		return nil, classify(fmt.Errorf("s3 create multipart %s/%s: %w", bucket, key, err))
+	}
+	return &multipart{c: c, bucket: bucket, key: key, id: out.UploadId, digests: make(map[int32][]byte)}, nil
+}
+
+// uploadPart sends part n with its SHA256, retrying transient failures.
+// body is called once per attempt.
+func (m *multipart) uploadPart(ctx context.Context, n int32, length int64, digest []byte, body func() io.Reader) error {
+	sum := base64.StdEncoding.EncodeToString(digest)
+
+	var etag *string
+	err := m.c.withPartRetries(ctx, func(pctx context.Context) error {
+		out, err := m.c.S3.UploadPart(pctx, &s3.UploadPartInput{
+			Bucket:         aws.String(m.bucket),
+			Key:            aws.String(m.key),
+			UploadId:       m.id,
+			PartNumber:     aws.Int32(n),
+			Body:           body(),
+			ContentLength:  aws.Int64(length),
+			ChecksumSHA256: aws.String(sum),
+		})
+		if err != nil {
+			return classify(fmt.Errorf("s3 upload part %d of %s/%s: %w", n, m.bucket, m.key, err))
+		}
+		etag = out.ETag
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.parts = append(m.parts, types.CompletedPart{
+		ETag:           etag,
+		PartNumber:     aws.Int32(n),
+		ChecksumSHA256: aws.String(sum),
+	})
+	m.digests[n] = digest
+	m.mu.Unlock()
+	return nil
+}
+
+// abort cancels the upload so its parts stop billing, and returns cause.
+func (m *multipart) abort(cause error) error {
+	actx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := m.c.S3.AbortMultipartUpload(actx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(m.bucket),
+		Key:      aws.String(m.key),
+		UploadId: m.id,
+	}); err != nil {
+		cause = errors.Join(cause, fmt.Errorf("abort multipart upload: %w", err))
+	}
+	return cause
+}
+
+// discard deletes an object that failed verification after it was
+// written, so a retry doesn't find it and take it for a finished upload,
+// and returns cause.
+func (c *Client) discard(bucket, key string, cause error) error {
+	dctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.Delete(dctx, bucket, key); err != nil {
+		cause = errors.Join(cause, fmt.Errorf("discard unverified object: %w", err))
+	}
+	return cause
+}
+
+// complete assembles the uploaded parts (size bytes in total) under cond,
+// and checks the result against the part checksums.
+func (m *multipart) complete(ctx context.Context, size int64, cond Condition) (UploadResult, error) {
+	sort.Slice(m.parts, func(i, j int) bool {
+		return aws.ToInt32(m.parts[i].PartNumber) < aws.ToInt32(m.parts[j].PartNumber)
+	})
+	digests := make([][]byte, 0, len(m.parts))
+	for _, p := range m.parts {
+		digests = append(digests, m.digests[aws.ToInt32(p.PartNumber)])
+	}
+
+	done, err := m.c.S3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(m.bucket),
+		Key:             aws.String(m.key),
+		UploadId:        m.id,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: m.parts},
+		IfNoneMatch:     cond.ifNoneMatch(),
+		IfMatch:         cond.ifMatch(),
+	})
+	if err != nil {
+		return UploadResult{}, m.abort(classifyWrite(fmt.Errorf("s3 complete multipart %s/%s: %w", m.bucket, m.key, err)))
+	}
+
+	// S3 already checked every part against its SHA256; make sure the
+	// assembled object is the one we meant to store.
+	info, err := m.c.Head(ctx, m.bucket, m.key)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	if info.Size != size {
+		return UploadResult{}, m.c.discard(m.bucket, m.key, verify(m.bucket, m.key, info, size, Checksums{}))
+	}
+	if want := compositeSHA256(digests); info.ChecksumSHA256 != "" && info.ChecksumSHA256 != want {
+		return UploadResult{}, m.c.discard(m.bucket, m.key, retryx.Transient(fmt.Errorf("%w: s3://%s/%s composite sha256 local=%s remote=%s",
+			ErrChecksumMismatch, m.bucket, m.key, want, info.ChecksumSHA256)))
+	}
+
+	return UploadResult{ETag: aws.ToString(done.ETag), Size: size, Parts: len(m.parts)}, nil
+}
+
+func sectionSHA256(r io.ReaderAt, off, n int64) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, off, n)); err != nil {
+		return nil, fmt.Errorf("checksum part: %w", err)
+	}
+	return h.Sum(nil), nil
+}
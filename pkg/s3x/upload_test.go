@@ -0,0 +1,133 @@
+package s3x
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+func TestPartLayout(t *testing.T) {
+	const mib = 1 << 20
+	tests := []struct {
+		name         string
+		partSize     int64
+		size         int64
+		wantPartSize int64
+		wantParts    int
+	}{
+		{"default part size", 0, 100 * mib, DefaultPartSize, 7},
+		{"exact multiple", 0, 32 * mib, DefaultPartSize, 2},
+		{"one byte", 0, 1, DefaultPartSize, 1},
+		{"below the S3 minimum", 1 * mib, 12 * mib, MinPartSize, 3},
+		{"configured", 8 * mib, 20 * mib, 8 * mib, 3},
+		{"at the part limit", MinPartSize, maxParts * MinPartSize, MinPartSize, maxParts},
+		{"grows past the part limit", MinPartSize, maxParts*MinPartSize + 1, 2 * MinPartSize, maxParts/2 + 1},
+		{"huge", DefaultPartSize, 5 << 40, 1 << 30, 5 << 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{PartSize: tt.partSize}
+			partSize, parts := c.partLayout(tt.size)
+			if partSize != tt.wantPartSize || parts != tt.wantParts {
+				t.Errorf("partLayout(%d) = %d x %d, want %d x %d", tt.size, parts, partSize, tt.wantParts, tt.wantPartSize)
+			}
+			if parts > maxParts || int64(parts)*partSize < tt.size {
+				t.Errorf("partLayout(%d) = %d x %d does not cover the object within %d parts", tt.size, parts, partSize, maxParts)
+			}
+		})
+	}
+}
+
+func TestUploadDefaults(t *testing.T) {
+	tests := []struct {
+		name            string
+		client          Client
+		wantConcurrency int
+	}{
+		{"unset", Client{}, DefaultConcurrency},
+		{"negative", Client{Concurrency: -1}, DefaultConcurrency},
+		{"set", Client{Concurrency: 3}, 3},
+	}
+	for _, tt := range tests {
+		if got := tt.client.concurrency(); got != tt.wantConcurrency {
+			t.Errorf("%s: concurrency = %d, want %d", tt.name, got, tt.wantConcurrency)
+		}
+	}
+}
+
+func TestUploadOptionsValidate(t *testing.T) {
+	tags := func(n int) map[string]string {
+		m := make(map[string]string, n)
+		for i := range n {
+			m[fmt.Sprint("k", i)] = "v"
+		}
+		return m
+	}
+	tests := []struct {
+		name    string
+		opts    UploadOptions
+		wantErr bool
+	}{
+		{"zero", UploadOptions{}, false},
+		{"ten tags", UploadOptions{Tags: tags(maxTags)}, false},
+		{"eleven tags", UploadOptions{Tags: tags(maxTags + 1)}, true},
+		{"kms key with kms", UploadOptions{SSE: "aws:kms", SSEKMSKeyID: "key"}, false},
+		{"kms key with AES256", UploadOptions{SSE: "AES256", SSEKMSKeyID: "key"}, true},
+		{"kms key alone", UploadOptions{SSEKMSKeyID: "key"}, true},
+	}
+	for _, tt := range tests {
+		err := tt.opts.validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validate() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !retryx.IsPermanent(err) {
+			t.Errorf("%s: %v is not permanent", tt.name, err)
+		}
+	}
+}
+
+func TestUploadOptionsTagging(t *testing.T) {
+	tests := []struct {
+		tags map[string]string
+		want string
+	}{
+		{nil, ""},
+		{map[string]string{"job": "merge"}, "job=merge"},
+		{map[string]string{"b": "2", "a": "1"}, "a=1&b=2"},
+		{map[string]string{"key": "a b&c=d"}, "key=a+b%26c%3Dd"},
+	}
+	for _, tt := range tests {
+		got := UploadOptions{Tags: tt.tags}.tagging()
+		if tt.want == "" {
+			if got != nil {
+				t.Errorf("tagging(%v) = %q, want nil", tt.tags, *got)
+			}
+			continue
+		}
+		if got == nil || *got != tt.want {
+			t.Errorf("tagging(%v) = %v, want %q", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestUploadOptionsMetadata(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain", "plain"},
+		{"videos/clip 1.mp4", "videos/clip 1.mp4"},
+		{"vidéo.mp4", "vid%C3%A9o.mp4"},
+		{"line\nbreak", "line%0Abreak"},
+	}
+	for _, tt := range tests {
+		got := UploadOptions{Metadata: map[string]string{"source": tt.value}}.metadata()
+		if got["source"] != tt.want {
+			t.Errorf("metadata(%q) = %q, want %q", tt.value, got["source"], tt.want)
+		}
+	}
+	if got := (UploadOptions{}).metadata(); got != nil {
+		t.Errorf("empty metadata = %v, want nil", got)
+	}
+}
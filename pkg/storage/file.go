@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// FileBackend stores objects as files under Root. Locations outside Root
+// are refused, so a request cannot read or overwrite arbitrary host files.
+type FileBackend struct {
+	Root string
+}
+
+func NewFile(root string) (*FileBackend, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("file storage root %s: %w", root, err)
+	}
+	return &FileBackend{Root: abs}, nil
+}
+
+// path maps a location to a file under Root. Symlinks are resolved first,
+// so a link under Root cannot point the location outside it.
+func (b *FileBackend) path(loc Location) (string, error) {
+	p := filepath.Clean(filepath.FromSlash(loc.Key))
+	outside := retryx.Permanent(fmt.Errorf("%s is outside the file storage root %s", loc, b.Root))
+	if !within(b.Root, p) {
+		return "", outside
+	}
+	root, err := resolveExisting(b.Root)
+	if err != nil {
+		return "", fileErr(loc, err)
+	}
+	real, err := resolveExisting(p)
+	if err != nil {
+		return "", fileErr(loc, err)
+	}
+	if !within(root, real) {
+		return "", outside
+	}
+	return p, nil
+}
+
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveExisting evaluates the symlinks in the longest existing prefix of
+// p, keeping the not yet created rest (an output's new dirs and file).
+func resolveExisting(p string) (string, error) {
+	real, err := filepath.EvalSymlinks(p)
+	if err == nil {
+		return real, nil
+	}
+	parent := filepath.Dir(p)
+	if !errors.Is(err, fs.ErrNotExist) || parent == p {
+		return "", err
+	}
+	if _, lerr := os.Lstat(p); lerr == nil {
+		// A dangling link; writing through it would create its target.
+		return "", retryx.Permanent(fmt.Errorf("%s is a dangling symlink", p))
+	}
+	real, err = resolveExisting(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(real, filepath.Base(p)), nil
+}
+
+func (b *FileBackend) Open(_ context.Context, loc Location) (io.ReadCloser, error) {
+	p, err := b.path(loc)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fileErr(loc, err)
+	}
+	return f, nil
+}
+
+// LocalPath is the file holding loc, which must exist.
+func (b *FileBackend) LocalPath(loc Location) (string, error) {
+	p, err := b.path(loc)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(p); err != nil {
+		return "", fileErr(loc, err)
+	}
+	return p, nil
+}
+
+func (b *FileBackend) Stat(_ context.Context, loc Location) (Info, error) {
+	p, err := b.path(loc)
+	if err != nil {
+		return Info{}, err
+	}
+	st, err := os.Stat(p)
+	if err != nil {
+		return Info{}, fileErr(loc, err)
+	}
+	if st.IsDir() {
+		return Info{}, retryx.Permanent(fmt.Errorf("%s is a directory", loc))
+	}
+	return Info{Size: st.Size(), ETag: fileETag(st)}, nil
+}
+
+// Put copies localPath into place through a temp file in the target
+// directory, so readers never see a partial file. IfNoneMatch is atomic
+// (hard link); IfMatch is checked just before the rename.
+func (b *FileBackend) Put(_ context.Context, loc Location, localPath string, opts PutOptions) (PutResult, error) {
+	p, err := b.path(loc)
+	if err != nil {
+		return PutResult{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return PutResult{}, fileErr(loc, err)
+	}
+
+	tmp, sum, size, err := copyToTemp(localPath, filepath.Dir(p))
+	if err != nil {
+		return PutResult{}, err
+	}
+	defer os.Remove(tmp)
+
+	cond := opts.Condition
+	switch {
+	case cond.IfNoneMatch:
+		if err := os.Link(tmp, p); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return PutResult{}, retryx.Permanent(fmt.Errorf("%w: %s exists", ErrPreconditionFailed, loc))
+			}
+			return PutResult{}, fileErr(loc, err)
+		}
+	case cond.IfMatch != "":
+		st, err := os.Stat(p)
+		if err != nil || fileETag(st) != cond.IfMatch {
+			return PutResult{}, retryx.Permanent(fmt.Errorf("%w: %s changed", ErrPreconditionFailed, loc))
+		}
+		fallthrough
+	default:
+		if err := os.Rename(tmp, p); err != nil {
+			return PutResult{}, fileErr(loc, err)
+		}
+	}
+
+	st, err := os.Stat(p)
+	if err != nil {
+		return PutResult{}, fileErr(loc, err)
+	}
+	return PutResult{ETag: fileETag(st), Size: size, ChecksumSHA256: sum}, nil
+}
+
+func (b *FileBackend) Delete(_ context.Context, loc Location) error {
+	p, err := b.path(loc)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fileErr(loc, err)
+	}
+	return nil
+}
+
+// SameContent hashes the stored file, since files carry no checksum.
+func (b *FileBackend) SameContent(_ context.Context, loc Location, info Info, localPath string) (bool, error) {
+	p, err := b.path(loc)
+	if err != nil {
+		return false, err
+	}
+	stored, storedSize, err := fileSHA256(p)
+	if err != nil {
+		return false, err
+	}
+	local, localSize, err := fileSHA256(localPath)
+	if err != nil {
+		return false, err
+	}
+	return storedSize == localSize && stored == local, nil
+}
+
+// fileETag changes whenever the file is replaced or modified.
+func fileETag(st fs.FileInfo) string {
+	return strconv.FormatInt(st.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(st.Size(), 36)
+}
+
+func copyToTemp(src, dir string) (tmp, sum string, size int64, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", "", 0, retryx.Permanent(fmt.Errorf("open %s: %w", src, err))
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(dir, ".put-*")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("create temp in %s: %w", dir, err)
+	}
+	h := sha256.New()
+	size, err = io.Copy(io.MultiWriter(out, h), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(out.Name())
+		return "", "", 0, fmt.Errorf("copy %s: %w", src, err)
+	}
+	return out.Name(), base64.StdEncoding.EncodeToString(h.Sum(nil)), size, nil
+}
+
+func fileSHA256(p string) (sum string, size int64, err error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", 0, fmt.Errorf("open %s: %w", p, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if size, err = io.Copy(h, f); err != nil {
+		return "", 0, fmt.Errorf("checksum %s: %w", p, err)
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), size, nil
+}
+
+func fileErr(loc Location, err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return retryx.Permanent(fmt.Errorf("%w: %s", ErrNotFound, loc))
+	case errors.Is(err, fs.ErrPermission):
+		return retryx.Permanent(fmt.Errorf("%s: %w", loc, err))
+	default:
+		return fmt.Errorf("%s: %w", loc, err)
+	}
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+func fileLoc(p string) Location {
+	return Location{Scheme: SchemeFile, Key: filepath.ToSlash(p)}
+}
+
+func TestFileBackendPath(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, d := range []string{filepath.Join(root, "in"), outside} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"escape":   outside,
+		"internal": filepath.Join(root, "in"),
+		"dangling": filepath.Join(outside, "missing.mp4"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := NewFile(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		p       string
+		wantErr bool
+	}{
+		{"existing dir", filepath.Join(root, "in", "v.mp4"), false},
+		{"new dirs", filepath.Join(root, "out", "a", "b.mp4"), false},
+		{"root itself", root, false},
+		{"outside", filepath.Join(outside, "v.mp4"), true},
+		{"dot dot", root + "/in/../../outside/v.mp4", true},
+		{"sibling prefix", root + "-other/v.mp4", true},
+		{"link out of root", filepath.Join(root, "escape", "v.mp4"), true},
+		{"new file under a link out of root", filepath.Join(root, "escape", "new", "v.mp4"), true},
+		{"link within root", filepath.Join(root, "internal", "v.mp4"), false},
+		{"dangling link", filepath.Join(root, "dangling"), true},
+	}
+	for _, tt := range tests {
+		_, err := b.path(fileLoc(tt.p))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: path(%s) error = %v, want error %v", tt.name, tt.p, err, tt.wantErr)
+		}
+		if err != nil && !retryx.IsPermanent(err) {
+			t.Errorf("%s: %v is not permanent", tt.name, err)
+		}
+	}
+}
+
+func TestFileBackendRootBehindSymlink(t *testing.T) {
+	real := t.TempDir()
+	link := filepath.Join(t.TempDir(), "root")
+	if err := os.Symlink(real, link); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewFile(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.path(fileLoc(filepath.Join(link, "v.mp4"))); err != nil {
+		t.Errorf("a root behind a symlink refused its own files: %v", err)
+	}
+}
+
+func TestFileBackendPutConditions(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	b, err := NewFile(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "src.mp4")
+	if err := os.WriteFile(src, []byte("merged"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loc := fileLoc(filepath.Join(root, "out", "v.mp4"))
+
+	first, err := b.Put(ctx, loc, src, PutOptions{Condition: Condition{IfNoneMatch: true}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if first.Size != 6 || first.ChecksumSHA256 == "" {
+		t.Errorf("create result %+v", first)
+	}
+	_, err = b.Put(ctx, loc, src, PutOptions{Condition: Condition{IfNoneMatch: true}})
+	if !errors.Is(err, ErrPreconditionFailed) || !retryx.IsPermanent(err) {
+		t.Errorf("create over an existing file: %v", err)
+	}
+	_, err = b.Put(ctx, loc, src, PutOptions{Condition: Condition{IfMatch: "stale"}})
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("replace with a stale etag: %v", err)
+	}
+	info, err := b.Stat(ctx, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Put(ctx, loc, src, PutOptions{Condition: Condition{IfMatch: info.ETag}}); err != nil {
+		t.Errorf("replace with the current etag: %v", err)
+	}
+
+	same, err := b.SameContent(ctx, loc, info, src)
+	if err != nil || !same {
+		t.Errorf("SameContent = %v, %v; want true", same, err)
+	}
+	if err := b.Delete(ctx, loc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Stat(ctx, loc); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat after Delete: %v", err)
+	}
+	if err := b.Delete(ctx, loc); err != nil {
+		t.Errorf("Delete of a missing file: %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "out"))
+	if len(entries) != 0 {
+		t.Errorf("temp files left behind: %v", entries)
+	}
+}
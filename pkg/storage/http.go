@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+// HTTPBackend reads objects over HTTP(S). It cannot write. Only hosts in
+// AllowedHosts are fetched, so requests cannot make the worker call
+// arbitrary (internal) URLs.
+type HTTPBackend struct {
+	Client       *http.Client
+	AllowedHosts []string
+}
+
+func NewHTTP(allowedHosts []string) *HTTPBackend {
+	b := &HTTPBackend{AllowedHosts: allowedHosts}
+	b.Client = &http.Client{
+		// Redirects must stay on allowed hosts too.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !slices.Contains(b.AllowedHosts, req.URL.Host) {
+				return retryx.Permanent(fmt.Errorf("redirect to host %q is not allowed", req.URL.Host))
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+	return b
+}
+
+func (b *HTTPBackend) Open(ctx context.Context, loc Location) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, loc)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *HTTPBackend) Stat(ctx context.Context, loc Location) (Info, error) {
+	resp, err := b.do(ctx, http.MethodHead, loc)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	return Info{Size: max(resp.ContentLength, 0), ETag: resp.Header.Get("ETag")}, nil
+}
+
+func (b *HTTPBackend) Put(context.Context, Location, string, PutOptions) (PutResult, error) {
+	return PutResult{}, retryx.Permanent(fmt.Errorf("http: %w", ErrReadOnly))
+}
+
+func (b *HTTPBackend) Delete(context.Context, Location) error {
+	return retryx.Permanent(fmt.Errorf("http: %w", ErrReadOnly))
+}
+
+func (b *HTTPBackend) do(ctx context.Context, method string, loc Location) (*http.Response, error) {
+	if !slices.Contains(b.AllowedHosts, loc.Bucket) {
+		return nil, retryx.Permanent(fmt.Errorf("host %q is not an allowed HTTP source", loc.Bucket))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, loc.URL(), nil)
+	if err != nil {
+		return nil, retryx.Permanent(fmt.Errorf("%s %s: %w", method, loc, err))
+	}
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		// url.Error repeats the full URL; keep the signature out of it.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, retryx.Transient(fmt.Errorf("%s %s: %w", method, loc, err))
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	err = fmt.Errorf("%s %s: %s", method, loc, resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, retryx.Permanent(fmt.Errorf("%w: %w", ErrNotFound, err))
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return nil, retryx.Permanent(err)
+	default:
+		return nil, retryx.Transient(err)
+	}
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+)
+
+func TestHTTPBackend(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("elsewhere"))
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v.mp4":
+			w.Write([]byte("video"))
+		case "/away":
+			http.Redirect(w, r, other.URL+"/v.mp4", http.StatusFound)
+		case "/here":
+			http.Redirect(w, r, "/v.mp4", http.StatusFound)
+		case "/busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/denied":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	b := NewHTTP([]string{host})
+
+	tests := []struct {
+		path          string
+		wantErr       bool
+		wantPermanent bool
+		wantNotFound  bool
+	}{
+		{"/v.mp4", false, false, false},
+		{"/here", false, false, false},
+		{"/away", true, true, false},
+		{"/busy", true, false, false},
+		{"/broken", true, false, false},
+		{"/denied", true, true, false},
+		{"/missing", true, true, true},
+	}
+	for _, tt := range tests {
+		loc, err := Parse(srv.URL + tt.path + "?sig=secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := b.Open(context.Background(), loc)
+		if err == nil {
+			body.Close()
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Open error = %v, want error %v", tt.path, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		if retryx.IsPermanent(err) != tt.wantPermanent {
+			t.Errorf("%s: %v permanent = %v, want %v", tt.path, err, retryx.IsPermanent(err), tt.wantPermanent)
+		}
+		if errors.Is(err, ErrNotFound) != tt.wantNotFound {
+			t.Errorf("%s: %v not found = %v, want %v", tt.path, err, errors.Is(err, ErrNotFound), tt.wantNotFound)
+		}
+		if strings.Contains(err.Error(), "secret") {
+			t.Errorf("%s: error leaks the query string: %v", tt.path, err)
+		}
+	}
+
+	loc, _ := Parse(other.URL + "/v.mp4")
+	if _, err := b.Stat(context.Background(), loc); err == nil || !retryx.IsPermanent(err) {
+		t.Errorf("host not allowed: %v", err)
+	}
+	if _, err := b.Put(context.Background(), loc, "", PutOptions{}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put: %v", err)
+	}
+}
+
+func TestHTTPBackendUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	u, _ := url.Parse(srv.URL)
+	srv.Close()
+	b := NewHTTP([]string{u.Host})
+	loc, _ := Parse(srv.URL + "/v.mp4?sig=secret")
+	_, err := b.Stat(context.Background(), loc)
+	if err == nil || !retryx.IsRetryable(err) {
+		t.Errorf("unreachable host: %v, want a retryable error", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "secret") {
+		t.Errorf("error leaks the query string: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/s3x"
+)
+
+// S3Backend stores objects in S3 (or an S3-compatible store) through one
+// s3x client, so it serves the client's region.
+type S3Backend struct {
+	Client *s3x.Client
+}
+
+func NewS3(c *s3x.Client) *S3Backend { return &S3Backend{Client: c} }
+
+func (b *S3Backend) Open(ctx context.Context, loc Location) (io.ReadCloser, error) {
+	return b.Client.Open(ctx, loc.Bucket, loc.Key)
+}
+
+func (b *S3Backend) Stat(ctx context.Context, loc Location) (Info, error) {
+	info, found, err := b.Client.Stat(ctx, loc.Bucket, loc.Key)
+	if err != nil {
+		return Info{}, err
+	}
+	if !found {
+		return Info{}, retryx.Permanent(fmt.Errorf("%w: %s", ErrNotFound, loc))
+	}
+	return fromS3(info), nil
+}
+
+func (b *S3Backend) Put(ctx context.Context, loc Location, localPath string, opts PutOptions) (PutResult, error) {
+	return b.Client.PutObjectFromFile(ctx, loc.Bucket, loc.Key, localPath, opts)
+}
+
+// PutStream uploads r as it is read, in multipart chunks.
+func (b *S3Backend) PutStream(ctx context.Context, loc Location, r io.Reader, opts PutOptions) (PutResult, error) {
+	return b.Client.UploadStream(ctx, loc.Bucket, loc.Key, r, opts)
+}
+
+func (b *S3Backend) Delete(ctx context.Context, loc Location) error {
+	return b.Client.Delete(ctx, loc.Bucket, loc.Key)
+}
+
+// DownloadToFile uses the parallel, resumable, verified s3x download.
+func (b *S3Backend) DownloadToFile(ctx context.Context, loc Location, localPath string) (Info, error) {
+	info, err := b.Client.DownloadToFile(ctx, loc.Bucket, loc.Key, localPath)
+	return fromS3(info), err
+}
+
+// SameContent understands the composite checksums of multipart objects.
+func (b *S3Backend) SameContent(_ context.Context, _ Location, info Info, localPath string) (bool, error) {
+	return b.Client.SameContent(s3x.ObjectInfo{
+		Size:           info.Size,
+		ETag:           info.ETag,
+		ChecksumSHA256: info.ChecksumSHA256,
+	}, localPath)
+}
+
+func (b *S3Backend) PresignGet(ctx context.Context, loc Location, ttl time.Duration) (string, time.Time, error) {
+	return b.Client.PresignGet(ctx, loc.Bucket, loc.Key, ttl)
+}
+
+func fromS3(info s3x.ObjectInfo) Info {
+	return Info{Size: info.Size, ETag: info.ETag, ChecksumSHA256: info.ChecksumSHA256}
+}
@@ -0,0 +1,224 @@
+// Package storage hides where job inputs and outputs live. Locations are
+// URIs (s3://bucket/key, file:///data/x.mp4, https://host/x.mp4), and each
+// scheme has a Backend.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/retryx"
+	"github.com/yangjie500/media_extractor_ffmpeg/pkg/s3x"
+)
+
+const (
+	SchemeS3    = "s3"
+	SchemeFile  = "file"
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+)
+
+var (
+	ErrNotFound = errors.New("object not found")
+	ErrReadOnly = errors.New("storage is read-only")
+
+	// ErrPreconditionFailed is returned by Put when opts.Condition does
+	// not hold; it is the same error s3x reports.
+	ErrPreconditionFailed = s3x.ErrPreconditionFailed
+)
+
+// Upload settings and results are shared with s3x; backends other than S3
+// honour the Condition and ignore what they cannot store (tags, storage
+// class, encryption).
+type (
+	PutOptions = s3x.UploadOptions
+	PutResult  = s3x.UploadResult
+	Condition  = s3x.Condition
+)
+
+// Info describes a stored object. ETag is whatever the backend uses to
+// detect changes; ChecksumSHA256 is base64 and may be empty.
+type Info struct {
+	Size           int64
+	ETag           string
+	ChecksumSHA256 string
+}
+
+// Backend reads and writes objects of one scheme.
+type Backend interface {
+	Open(ctx context.Context, loc Location) (io.ReadCloser, error)
+	Stat(ctx context.Context, loc Location) (Info, error) // ErrNotFound if missing
+	Put(ctx context.Context, loc Location, localPath string, opts PutOptions) (PutResult, error)
+	Delete(ctx context.Context, loc Location) error
+}
+
+// Downloader is implemented by backends with a faster (parallel,
+// resumable) way to fetch an object into a local file than Open.
+type Downloader interface {
+	DownloadToFile(ctx context.Context, loc Location, localPath string) (Info, error)
+}
+
+// ContentComparer is implemented by backends whose checksums need more than
+// a plain SHA256 comparison, such as S3 multipart objects.
+type ContentComparer interface {
+	SameContent(ctx context.Context, loc Location, info Info, localPath string) (bool, error)
+}
+
+// Presigner is implemented by backends that can hand out time-limited
+// download links to callers without credentials.
+type Presigner interface {
+	PresignGet(ctx context.Context, loc Location, ttl time.Duration) (url string, expires time.Time, err error)
+}
+
+// StreamPutter is implemented by backends that can store an object of
+// unknown size straight from a reader, without a local copy.
+type StreamPutter interface {
+	PutStream(ctx context.Context, loc Location, r io.Reader, opts PutOptions) (PutResult, error)
+}
+
+// LocalPather is implemented by backends whose objects are local files that
+// can be read in place.
+type LocalPather interface {
+	LocalPath(loc Location) (string, error)
+}
+
+// Location is a parsed storage URI.
+type Location struct {
+	Scheme string
+	Bucket string // S3 bucket or HTTP host; empty for files
+	Key    string // S3 key, absolute file path, or HTTP path and query
+}
+
+// S3 is the location of an S3 object.
+func S3(bucket, key string) Location {
+	return Location{Scheme: SchemeS3, Bucket: bucket, Key: key}
+}
+
+// Parse reads a storage URI.
+func Parse(uri string) (Location, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return Location{}, fmt.Errorf("parse %q: %w", uri, err)
+	}
+	switch u.Scheme {
+	case SchemeS3:
+		key := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || key == "" {
+			return Location{}, fmt.Errorf("%q: want s3://bucket/key", uri)
+		}
+		return S3(u.Host, key), nil
+	case SchemeFile:
+		if u.Host != "" && u.Host != "localhost" {
+			return Location{}, fmt.Errorf("%q: remote file hosts are not supported", uri)
+		}
+		if !path.IsAbs(u.Path) {
+			return Location{}, fmt.Errorf("%q: want file:///absolute/path", uri)
+		}
+		return Location{Scheme: SchemeFile, Key: path.Clean(u.Path)}, nil
+	case SchemeHTTP, SchemeHTTPS:
+		if u.Host == "" {
+			return Location{}, fmt.Errorf("%q: missing host", uri)
+		}
+		return Location{Scheme: u.Scheme, Bucket: u.Host, Key: u.RequestURI()}, nil
+	default:
+		return Location{}, fmt.Errorf("%q: unsupported scheme %q", uri, u.Scheme)
+	}
+}
+
+// String is the URI without any query string: HTTP sources are often
+// signed URLs, and the signature must not end up in logs or results.
+func (l Location) String() string {
+	switch l.Scheme {
+	case SchemeS3:
+		return "s3://" + l.Bucket + "/" + l.Key
+	case SchemeFile:
+		return "file://" + l.Key
+	default:
+		return l.Scheme + "://" + l.Bucket + l.Path()
+	}
+}
+
+// URL is the full URI, query included.
+func (l Location) URL() string {
+	if l.Scheme == SchemeHTTP || l.Scheme == SchemeHTTPS {
+		return l.Scheme + "://" + l.Bucket + l.Key
+	}
+	return l.String()
+}
+
+// Path is the key without an HTTP query string.
+func (l Location) Path() string {
+	if l.Scheme != SchemeHTTP && l.Scheme != SchemeHTTPS {
+		return l.Key
+	}
+	p, _, _ := strings.Cut(l.Key, "?")
+	return p
+}
+
+// Ext is the file extension of the location's path, e.g. ".mp4".
+func (l Location) Ext() string {
+	return path.Ext(l.Path())
+}
+
+// DownloadToFile fetches loc into localPath, using the backend's Downloader
+// when it has one.
+func DownloadToFile(ctx context.Context, b Backend, loc Location, localPath string) (Info, error) {
+	if d, ok := b.(Downloader); ok {
+		return d.DownloadToFile(ctx, loc, localPath)
+	}
+
+	info, err := b.Stat(ctx, loc)
+	if err != nil {
+		return info, err
+	}
+	body, err := b.Open(ctx, loc)
+	if err != nil {
+		return info, err
+	}
+	defer body.Close()
+
+	tmp := localPath + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return info, fmt.Errorf("create %s: %w", tmp, err)
+	}
+	n, err := io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return info, retryx.Transient(fmt.Errorf("download %s: %w", loc, err))
+	}
+	if info.Size > 0 && n != info.Size {
+		_ = os.Remove(tmp)
+		return info, retryx.Transient(fmt.Errorf("download %s: short read %d of %d bytes", loc, n, info.Size))
+	}
+	if err := os.Rename(tmp, localPath); err != nil {
+		return info, fmt.Errorf("rename %s: %w", tmp, err)
+	}
+	return info, nil
+}
+
+// SameContent reports whether the object described by info holds the same
+// bytes as the local file. Objects without a SHA256 never match.
+func SameContent(ctx context.Context, b Backend, loc Location, info Info, localPath string) (bool, error) {
+	if c, ok := b.(ContentComparer); ok {
+		return c.SameContent(ctx, loc, info, localPath)
+	}
+	if info.ChecksumSHA256 == "" {
+		return false, nil
+	}
+	sum, size, err := fileSHA256(localPath)
+	if err != nil {
+		return false, err
+	}
+	return size == info.Size && sum == info.ChecksumSHA256, nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		uri     string
+		want    Location
+		wantErr bool
+	}{
+		{"s3://media-in/videos/v.mp4", S3("media-in", "videos/v.mp4"), false},
+		{"s3://media-in/", Location{}, true},
+		{"s3:///videos/v.mp4", Location{}, true},
+		{"file:///data/in/v.mp4", Location{Scheme: SchemeFile, Key: "/data/in/v.mp4"}, false},
+		{"file://localhost/data/../in/v.mp4", Location{Scheme: SchemeFile, Key: "/in/v.mp4"}, false},
+		{"file://other/data/v.mp4", Location{}, true},
+		{"file:data/v.mp4", Location{}, true},
+		{"https://cdn.example.com/v.mp4?X-Amz-Signature=abc", Location{Scheme: SchemeHTTPS, Bucket: "cdn.example.com", Key: "/v.mp4?X-Amz-Signature=abc"}, false},
+		{"http://cdn.example.com", Location{Scheme: SchemeHTTP, Bucket: "cdn.example.com", Key: "/"}, false},
+		{"https:///v.mp4", Location{}, true},
+		{"ftp://host/v.mp4", Location{}, true},
+		{"videos/v.mp4", Location{}, true},
+		{"s3://bad host/x", Location{}, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.uri)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v, error %v", tt.uri, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLocationStrings(t *testing.T) {
+	tests := []struct {
+		uri                  string
+		wantString, wantPath string
+		wantExt              string
+	}{
+		{"s3://media-in/videos/v.mp4", "s3://media-in/videos/v.mp4", "videos/v.mp4", ".mp4"},
+		{"file:///data/a.m4a", "file:///data/a.m4a", "/data/a.m4a", ".m4a"},
+		{"https://cdn.example.com/v.webm?sig=secret", "https://cdn.example.com/v.webm", "/v.webm", ".webm"},
+		{"http://cdn.example.com/stream", "http://cdn.example.com/stream", "/stream", ""},
+	}
+	for _, tt := range tests {
+		loc, err := Parse(tt.uri)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.uri, err)
+		}
+		if got := loc.String(); got != tt.wantString {
+			t.Errorf("%q: String() = %q, want %q", tt.uri, got, tt.wantString)
+		}
+		if got := loc.URL(); got != tt.uri {
+			t.Errorf("%q: URL() = %q", tt.uri, got)
+		}
+		if got := loc.Path(); got != tt.wantPath {
+			t.Errorf("%q: Path() = %q, want %q", tt.uri, got, tt.wantPath)
+		}
+		if got := loc.Ext(); got != tt.wantExt {
+			t.Errorf("%q: Ext() = %q, want %q", tt.uri, got, tt.wantExt)
+		}
+	}
+}